@@ -0,0 +1,187 @@
+package msgpack
+
+import (
+	"bytes"
+	"sort"
+)
+
+const (
+	canonicalNaN32 = 0x7fc00000
+	canonicalNaN64 = 0x7ff8000000000000
+)
+
+// Canonicalize returns the canonical encoding of the MessagePack encoded
+// values in raw. The canonical encoding is deterministic, i.e. values which
+// only differ in their encoding have the same canonical encoding, which
+// makes it suitable for signatures and content-addressed storage:
+//   - integers, lengths and headers are encoded in their shortest form, where
+//     non-negative integers are encoded as unsigned integers
+//   - NaN values are encoded as the canonical quiet NaN of their width
+//   - binary values which hold valid UTF-8 are encoded as strings
+//   - time values are encoded with the smallest timestamp layout
+//   - the entries of maps are sorted by the encoding of their keys
+//
+// Floating-point values keep their width. Maps with several keys of the same
+// canonical encoding cannot be canonicalized and result in an error.
+func Canonicalize(raw []byte) ([]byte, error) {
+	w := newAppendWriter(make([]byte, 0, len(raw)))
+	w.canonical = true
+	if err := canonicalize(w, raw); err != nil {
+		return nil, err
+	}
+	return w.buf, nil
+}
+
+// IsCanonical reports whether the MessagePack encoded values in raw are
+// encoded canonically. See Canonicalize for details.
+func IsCanonical(raw []byte) (bool, error) {
+	c, err := Canonicalize(raw)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(c, raw), nil
+}
+
+// canonicalize writes all values in raw to w, which needs to be in canonical
+// mode.
+func canonicalize(w *Writer, raw []byte) error {
+	r := NewReaderBytes(raw)
+	c := canonicalizer{w: w}
+	for int(r.offset) < len(raw) {
+		r.beginValue()
+		if err := r.walk(c.visit, c.end); err != nil {
+			return err
+		}
+		r.endValue()
+	}
+	return nil
+}
+
+// canonicalizer writes the items visited by Reader.walk canonically. The
+// entries of maps are collected in a separate writer, so that they can be
+// sorted when the map is closed.
+type canonicalizer struct {
+	w       *Writer   // writer of the innermost map or the output writer
+	parents []*Writer // writers enclosing the open maps, innermost last
+}
+
+func (c *canonicalizer) visit(it item, p []byte) error {
+	data := p[it.headerLen:]
+	switch it.typ {
+	case Nil:
+		return c.w.WriteNil()
+	case Bool:
+		return c.w.WriteBool(p[0] == tagTrue)
+	case Int:
+		return c.w.WriteInt64(parseInt(p))
+	case Uint:
+		return c.w.WriteUint64(parseUint(p))
+	case Float:
+		if p[0] == tagFloat32 {
+			return c.w.WriteFloat32(float32(parseFloat(p)))
+		}
+		return c.w.WriteFloat64(parseFloat(p))
+	case String:
+		return c.w.writeString(data)
+	case Bytes:
+		return c.w.WriteBytes(data)
+	case Time:
+		tm, err := parseTimestamp(data)
+		if err != nil {
+			return err
+		}
+		return c.w.WriteTime(tm)
+	case Ext:
+		return c.w.writeExtension(it.ext, data)
+	case Array:
+		return c.w.WriteArrayHeader(it.n)
+	default: // Map
+		tmp := newAppendWriter(nil)
+		tmp.canonical = true
+		c.parents = append(c.parents, c.w)
+		c.w = tmp
+		return c.w.WriteMapHeader(it.n)
+	}
+}
+
+func (c *canonicalizer) end(isMap bool) error {
+	if !isMap {
+		return nil
+	}
+
+	sorted, err := sortMapEntries(c.w.buf)
+	if err != nil {
+		return err
+	}
+	c.w = c.parents[len(c.parents)-1]
+	c.parents = c.parents[:len(c.parents)-1]
+	return c.w.writePayload(sorted)
+}
+
+// writeMap writes a map with f, which needs to write the map header and all
+// of its entries. In canonical mode, the entries are sorted by the encoding
+// of their keys.
+func (w *Writer) writeMap(f func(w *Writer) error) error {
+	if !w.canonical {
+		return f(w)
+	}
+
+	tmp := newAppendWriter(nil)
+	tmp.canonical = true
+	if err := f(tmp); err != nil {
+		return err
+	}
+
+	sorted, err := sortMapEntries(tmp.buf)
+	if err != nil {
+		return err
+	}
+	return w.writePayload(sorted)
+}
+
+// sortMapEntries returns a copy of the encoded map m, where the entries are
+// sorted by the encoding of their keys.
+func sortMapEntries(m []byte) ([]byte, error) {
+	r := NewReaderBytes(m)
+	n, err := r.ReadMapHeader()
+	if err != nil {
+		return nil, err
+	}
+	headerLen := int(r.offset)
+
+	type entry struct {
+		key  []byte
+		data []byte
+	}
+	entries := make([]entry, n)
+	for i := range entries {
+		start := int(r.offset)
+		if err := r.Skip(); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		keyEnd := int(r.offset)
+		if err := r.Skip(); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		entries[i] = entry{key: m[start:keyEnd], data: m[start:r.offset]}
+	}
+	if int(r.offset) != len(m) {
+		return nil, errorString("map entries do not match the map header")
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	res := make([]byte, 0, len(m))
+	res = append(res, m[:headerLen]...)
+	for i, e := range entries {
+		if i > 0 && bytes.Equal(e.key, entries[i-1].key) {
+			var key Value
+			Unmarshal(e.key, &key)
+			return nil, errorf("duplicate map key %s", formatValue(key))
+		}
+		res = append(res, e.data...)
+	}
+	return res, nil
+}
@@ -0,0 +1,179 @@
+package msgpack
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		raw      []byte
+		expected []byte
+	}{
+		{ // shortest integers
+			raw:      []byte{tagInt64, 0, 0, 0, 0, 0, 0, 0, 5},
+			expected: []byte{posFixintTag(5)},
+		},
+		{
+			raw:      []byte{tagInt16, 0xff, 0xff},
+			expected: []byte{negFixintTag(-1)},
+		},
+		{
+			raw:      []byte{tagInt8, 0xe0},
+			expected: []byte{negFixintTag(-32)},
+		},
+		{
+			raw:      []byte{tagInt16, 0xff, 0xdf},
+			expected: []byte{tagInt8, 0xdf},
+		},
+		{
+			raw:      []byte{tagInt16, 0x00, 0xc8},
+			expected: []byte{tagUint8, 0xc8},
+		},
+		{
+			raw:      []byte{tagUint32, 0x00, 0x00, 0x01, 0x00},
+			expected: []byte{tagUint16, 0x01, 0x00},
+		},
+		{ // shortest lengths and headers
+			raw:      []byte{tagStr8, 0x01, 'a'},
+			expected: []byte{fixstrTag(1), 'a'},
+		},
+		{
+			raw:      []byte{tagArray16, 0x00, 0x01, tagNil},
+			expected: []byte{fixarrayTag(1), tagNil},
+		},
+		{
+			raw:      []byte{tagExt8, 0x04, 0x05, 0x01, 0x02, 0x03, 0x04},
+			expected: []byte{tagFixExt4, 0x05, 0x01, 0x02, 0x03, 0x04},
+		},
+		{ // str over bin
+			raw:      []byte{tagBin8, 0x01, 'a'},
+			expected: []byte{fixstrTag(1), 'a'},
+		},
+		{
+			raw:      []byte{tagBin8, 0x01, 0xff},
+			expected: []byte{tagBin8, 0x01, 0xff},
+		},
+		{ // canonical NaN
+			raw:      []byte{tagFloat64, 0xff, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
+			expected: []byte{tagFloat64, 0x7f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			raw:      []byte{tagFloat32, 0x7f, 0x80, 0x00, 0x01},
+			expected: []byte{tagFloat32, 0x7f, 0xc0, 0x00, 0x00},
+		},
+		{ // minimal timestamp
+			raw:      []byte{tagExt8, 0x0c, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x59, 0xca, 0x52, 0xa7},
+			expected: []byte{tagFixExt4, 0xff, 0x59, 0xca, 0x52, 0xa7},
+		},
+		{ // sorted map keys
+			raw: []byte{
+				fixmapTag(3),
+				fixstrTag(1), 'b', tagNil,
+				fixstrTag(1), 'a', fixmapTag(2), fixstrTag(1), 'y', tagTrue, fixstrTag(1), 'x', tagFalse,
+				tagInt8, 0x01, tagNil,
+			},
+			expected: []byte{
+				fixmapTag(3),
+				posFixintTag(1), tagNil,
+				fixstrTag(1), 'a', fixmapTag(2), fixstrTag(1), 'x', tagFalse, fixstrTag(1), 'y', tagTrue,
+				fixstrTag(1), 'b', tagNil,
+			},
+		},
+		{ // sequence of values
+			raw:      []byte{tagUint8, 0x01, tagStr8, 0x00},
+			expected: []byte{posFixintTag(1), fixstrTag(0)},
+		},
+	}
+
+	for _, test := range tests {
+		c, err := Canonicalize(test.raw)
+		if err != nil {
+			t.Errorf("unexpected error for %x: %v", test.raw, err)
+			continue
+		} else if !bytes.Equal(c, test.expected) {
+			t.Errorf("unexpected canonical encoding for %x: %x", test.raw, c)
+		}
+
+		if ok, err := IsCanonical(test.raw); err != nil || ok != bytes.Equal(test.raw, test.expected) {
+			t.Errorf("unexpected canonical check for %x: %t (error: %v)", test.raw, ok, err)
+		}
+		if ok, err := IsCanonical(c); err != nil || !ok {
+			t.Errorf("unexpected canonical check for %x: %t (error: %v)", c, ok, err)
+		}
+	}
+
+	dup := []byte{fixmapTag(2), tagInt64, 0, 0, 0, 0, 0, 0, 0, 1, tagNil, posFixintTag(1), tagTrue}
+	if _, err := Canonicalize(dup); err == nil || err.Error() != "duplicate map key 1" {
+		t.Errorf("unexpected error for duplicate keys: %v", err)
+	}
+	if _, err := Canonicalize([]byte{fixarrayTag(1)}); err == nil {
+		t.Errorf("expected error for truncated value")
+	}
+}
+
+func TestCanonicalizeDeepNesting(t *testing.T) {
+	limitStack(t)
+
+	raw := nestedArrays(200000)
+	raw[len(raw)-1] = tagInt8
+	raw = append(raw, 0x01)
+
+	c, err := Canonicalize(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := append(nestedArrays(200000)[:200000], posFixintTag(1))
+	if !bytes.Equal(c, expected) {
+		t.Errorf("unexpected canonical encoding")
+	}
+
+	m := nestedMaps(1000)
+	if ok, err := IsCanonical(m); err != nil || !ok {
+		t.Errorf("unexpected canonical check for nested maps: %t (error: %v)", ok, err)
+	}
+}
+
+func TestWriterCanonical(t *testing.T) {
+	type entry struct {
+		Name string
+		ID   int
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetCanonical(true)
+	w.SetTimestampLayout(Timestamp96)
+	w.WriteValue(map[string]int{"b": 2, "a": 1, "c": 3})
+	w.WriteValue(entry{Name: "x", ID: 7})
+	w.WriteValue(MapValue(KeyValue{Key: StringValue("k"), Value: IntValue(7)}))
+	w.WriteBytes([]byte("foo"))
+	w.WriteInt64(-32)
+	w.WriteInt64(-33)
+	w.WriteFloat64(math.NaN())
+	w.WriteTime(time.Unix(1, 0))
+	w.WriteRaw(Raw{tagArray16, 0x00, 0x01, tagInt32, 0x00, 0x00, 0x00, 0x01})
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []byte{
+		fixmapTag(3), fixstrTag(1), 'a', posFixintTag(1), fixstrTag(1), 'b', posFixintTag(2), fixstrTag(1), 'c', posFixintTag(3),
+		fixmapTag(2), fixstrTag(2), 'I', 'D', posFixintTag(7), fixstrTag(4), 'N', 'a', 'm', 'e', fixstrTag(1), 'x',
+		fixmapTag(1), fixstrTag(1), 'k', posFixintTag(7),
+		fixstrTag(3), 'f', 'o', 'o',
+		negFixintTag(-32),
+		tagInt8, 0xdf,
+		tagFloat64, 0x7f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		tagFixExt4, 0xff, 0x00, 0x00, 0x00, 0x01,
+		fixarrayTag(1), posFixintTag(1),
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("unexpected encoding: %x", buf.Bytes())
+	}
+	if !w.Canonical() {
+		t.Errorf("unexpected canonical mode")
+	}
+}
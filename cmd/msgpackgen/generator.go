@@ -0,0 +1,724 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"go/types"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const msgpackPath = "github.com/mprot/msgpack-go"
+
+// generator writes the methods of struct types and their tests.
+type generator struct {
+	fset *token.FileSet
+	pkg  *types.Package
+
+	queue  []*types.Named // types to generate methods for
+	queued map[*types.Named]bool
+
+	buf     bytes.Buffer
+	imports map[string]string // import path by package name
+	err     error
+}
+
+func newGenerator(fset *token.FileSet, pkg *types.Package) *generator {
+	return &generator{
+		fset:   fset,
+		pkg:    pkg,
+		queued: make(map[*types.Named]bool),
+	}
+}
+
+// generate returns the source of the methods of the given types and of all
+// struct types they depend on.
+func (g *generator) generate(named []*types.Named) ([]byte, error) {
+	g.reset()
+	for _, n := range named {
+		g.enqueue(n)
+	}
+	for i := 0; i < len(g.queue) && g.err == nil; i++ {
+		g.generateStruct(g.queue[i])
+	}
+	return g.source()
+}
+
+// generateTests returns the source of the tests for the types of the last
+// call to generate.
+func (g *generator) generateTests() ([]byte, error) {
+	g.reset()
+	g.imports["bytes"] = "bytes"
+	g.imports["testing"] = "testing"
+	for _, n := range g.queue {
+		g.generateTest(n)
+	}
+	return g.source()
+}
+
+func (g *generator) reset() {
+	g.buf.Reset()
+	g.imports = map[string]string{"msgpack": msgpackPath}
+}
+
+func (g *generator) source() ([]byte, error) {
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	var src bytes.Buffer
+	formatImports(&src, g.pkg.Name(), g.imports)
+	g.buf.WriteTo(&src)
+	return format.Source(src.Bytes())
+}
+
+func (g *generator) failf(pos token.Pos, format string, args ...interface{}) {
+	if g.err == nil {
+		g.err = fmt.Errorf("%s: %s", g.fset.Position(pos), fmt.Sprintf(format, args...))
+	}
+}
+
+// enqueue adds n to the types to generate methods for.
+func (g *generator) enqueue(n *types.Named) {
+	if !g.queued[n] {
+		g.queued[n] = true
+		g.queue = append(g.queue, n)
+	}
+}
+
+// qualifier returns the name under which the package p is imported by the
+// generated file.
+func (g *generator) qualifier(p *types.Package) string {
+	if p == g.pkg {
+		return ""
+	}
+	for name, path := range g.imports {
+		if path == p.Path() {
+			return name
+		}
+	}
+
+	name := p.Name()
+	for i := 2; g.imports[name] != ""; i++ {
+		name = fmt.Sprintf("%s%d", p.Name(), i)
+	}
+	g.imports[name] = p.Path()
+	return name
+}
+
+// typeExpr returns the Go expression of the type t in the generated file.
+func (g *generator) typeExpr(t types.Type) string {
+	return types.TypeString(t, g.qualifier)
+}
+
+// generates reports whether methods are generated for t. Struct types of the
+// generated package without methods of their own are added to the generated
+// types when they are first encountered.
+func (g *generator) generates(t types.Type) bool {
+	n, ok := t.(*types.Named)
+	if !ok || n.Obj().Pkg() != g.pkg || n.TypeParams().Len() != 0 {
+		return false
+	}
+	if g.queued[n] {
+		return true
+	}
+	if _, ok := n.Underlying().(*types.Struct); !ok {
+		return false
+	}
+
+	mset := types.NewMethodSet(types.NewPointer(n))
+	if mset.Lookup(g.pkg, "EncodeMsgpack") != nil || mset.Lookup(g.pkg, "DecodeMsgpack") != nil {
+		return false
+	}
+	g.enqueue(n)
+	return true
+}
+
+// isEncoder reports whether values of t are encoded by their EncodeMsgpack
+// method. Like in the reflection-based encoding, the method may also be
+// declared on the pointer type.
+func (g *generator) isEncoder(t types.Type) bool {
+	if p, ok := t.(*types.Pointer); ok && g.generates(p.Elem()) {
+		return true
+	}
+	if g.generates(t) {
+		return true
+	}
+
+	mset := types.NewMethodSet(t)
+	if _, ok := t.Underlying().(*types.Pointer); !ok && !types.IsInterface(t) {
+		mset = types.NewMethodSet(types.NewPointer(t))
+	}
+	return mset.Lookup(g.pkg, "EncodeMsgpack") != nil
+}
+
+// isDecoder reports whether values of t are decoded by the DecodeMsgpack
+// method of their pointer type.
+func (g *generator) isDecoder(t types.Type) bool {
+	if g.generates(t) {
+		return true
+	}
+	if _, ok := t.Underlying().(*types.Pointer); ok || types.IsInterface(t) {
+		return false
+	}
+	return types.NewMethodSet(types.NewPointer(t)).Lookup(g.pkg, "DecodeMsgpack") != nil
+}
+
+func isTime(t types.Type) bool {
+	n, ok := t.(*types.Named)
+	return ok && n.Obj().Pkg() != nil && n.Obj().Pkg().Path() == "time" && n.Obj().Name() == "Time"
+}
+
+// isByteType reports whether t is encoded as a single byte of binary data,
+// if it is the element type of a slice or array.
+func (g *generator) isByteType(t types.Type) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Kind() == types.Uint8 && !g.isEncoder(t) && !g.isDecoder(t)
+}
+
+// isByte reports whether t is the byte type. Slices and arrays of other byte
+// types are encoded by reflection, since they cannot be passed to the Writer
+// and Reader methods.
+func isByte(t types.Type) bool {
+	return types.Identical(t, types.Typ[types.Byte])
+}
+
+// basicMethod returns the suffix of the Writer and Reader methods for values
+// of the basic type b, and the Go type which these methods take and return.
+func basicMethod(b *types.Basic) (method, goType string) {
+	switch b.Kind() {
+	case types.Bool:
+		return "Bool", "bool"
+	case types.Int:
+		return "Int", "int"
+	case types.Int8:
+		return "Int8", "int8"
+	case types.Int16:
+		return "Int16", "int16"
+	case types.Int32:
+		return "Int32", "int32"
+	case types.Int64:
+		return "Int64", "int64"
+	case types.Uint:
+		return "Uint", "uint"
+	case types.Uint8:
+		return "Uint8", "uint8"
+	case types.Uint16:
+		return "Uint16", "uint16"
+	case types.Uint32:
+		return "Uint32", "uint32"
+	case types.Uint64, types.Uintptr:
+		return "Uint64", "uint64"
+	case types.Float32:
+		return "Float32", "float32"
+	case types.Float64:
+		return "Float64", "float64"
+	case types.String:
+		return "String", "string"
+	default:
+		return "", ""
+	}
+}
+
+// needsConversion reports whether values of t need to be converted to and
+// from goType.
+func needsConversion(t types.Type, goType string) bool {
+	return !types.Identical(t, types.Universe.Lookup(goType).Type())
+}
+
+// paren wraps expr in parentheses, if it is a pointer indirection.
+func paren(expr string) string {
+	if strings.HasPrefix(expr, "*") {
+		return "(" + expr + ")"
+	}
+	return expr
+}
+
+// field describes an encoded field of a struct type.
+type field struct {
+	key       string
+	path      []string    // field names from the struct to the field
+	inlined   []inlinePtr // pointers to inlined structs on the path
+	typ       types.Type
+	omitEmpty bool
+	depth     int
+	pos       token.Pos
+}
+
+// inlinePtr describes a pointer to an inlined struct.
+type inlinePtr struct {
+	pathLen int // length of the path to the pointer field
+	elem    types.Type
+}
+
+// expr returns the selector expression of the field.
+func (f field) expr() string {
+	return "v." + strings.Join(f.path, ".")
+}
+
+// structFields returns the encoded fields of the struct type n in the order
+// of the reflection-based encoding and reports whether they are encoded as
+// an array.
+func structFields(n *types.Named) (fields []field, asArray bool) {
+	st := n.Underlying().(*types.Struct)
+
+	var candidates []field
+	collectFields(st, nil, nil, 0, map[types.Type]bool{n: true}, &candidates)
+
+	// find the dominant field for each name
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return candidates[order[i]].depth < candidates[order[j]].depth
+	})
+	dominant := make(map[string]int, len(candidates))
+	for _, i := range order {
+		if _, ok := dominant[candidates[i].key]; !ok {
+			dominant[candidates[i].key] = i
+		}
+	}
+	for i, f := range candidates {
+		if dominant[f.key] == i {
+			fields = append(fields, f)
+		}
+	}
+
+	for i := 0; i < st.NumFields(); i++ {
+		if st.Field(i).Name() != "_" {
+			continue
+		}
+		if _, opts := parseTag(reflect.StructTag(st.Tag(i)).Get("msgpack")); opts.contains("array") {
+			asArray = true
+		}
+	}
+	return fields, asArray
+}
+
+func collectFields(st *types.Struct, path []string, inlined []inlinePtr, depth int, visited map[types.Type]bool, fields *[]field) {
+	for i := 0; i < st.NumFields(); i++ {
+		sf := st.Field(i)
+		tag := reflect.StructTag(st.Tag(i)).Get("msgpack")
+		if tag == "-" || sf.Name() == "_" {
+			continue
+		}
+
+		name, opts := parseTag(tag)
+		if opts.contains("inline") {
+			ft, isPtr := sf.Type(), false
+			if p, ok := ft.Underlying().(*types.Pointer); ok {
+				ft, isPtr = p.Elem(), true
+			}
+			// Pointers to unexported struct types cannot be allocated
+			// while decoding, so they are not inlined.
+			inner, isStruct := ft.Underlying().(*types.Struct)
+			if isStruct && (sf.Exported() || !isPtr) && !visited[ft] {
+				fieldPath := appendPath(path, sf.Name())
+				fieldInlined := inlined
+				if isPtr {
+					fieldInlined = append(inlined[:len(inlined):len(inlined)], inlinePtr{pathLen: len(fieldPath), elem: ft})
+				}
+				visited[ft] = true
+				collectFields(inner, fieldPath, fieldInlined, depth+1, visited, fields)
+				delete(visited, ft)
+				continue
+			}
+		}
+
+		if !sf.Exported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name()
+		}
+		*fields = append(*fields, field{
+			key:       name,
+			path:      appendPath(path, sf.Name()),
+			inlined:   inlined,
+			typ:       sf.Type(),
+			omitEmpty: opts.contains("omitempty"),
+			depth:     depth,
+			pos:       sf.Pos(),
+		})
+	}
+}
+
+func appendPath(path []string, name string) []string {
+	res := make([]string, len(path)+1)
+	copy(res, path)
+	res[len(path)] = name
+	return res
+}
+
+// tagOptions holds the comma-separated options of a struct tag.
+type tagOptions string
+
+// parseTag splits a struct tag into its name and its options.
+func parseTag(tag string) (string, tagOptions) {
+	name, opts, _ := strings.Cut(tag, ",")
+	return name, tagOptions(opts)
+}
+
+func (o tagOptions) contains(name string) bool {
+	s := string(o)
+	for s != "" {
+		var opt string
+		opt, s, _ = strings.Cut(s, ",")
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// present returns the condition under which the field is encoded as part of
+// a map, or an empty string if it is always encoded. Fields of nil inlined
+// structs are left out, as are empty values of omitempty fields.
+func (f field) present() string {
+	var conds []string
+	for _, p := range f.inlined {
+		conds = append(conds, "v."+strings.Join(f.path[:p.pathLen], ".")+" != nil")
+	}
+	if f.omitEmpty {
+		if cond := notEmpty(f.expr(), f.typ); cond != "" {
+			conds = append(conds, cond)
+		}
+	}
+	return strings.Join(conds, " && ")
+}
+
+// notEmpty returns the condition under which the value of expr is not empty,
+// or an empty string if it is never empty.
+func notEmpty(expr string, t types.Type) string {
+	if isTime(t) {
+		return "!" + expr + ".IsZero()"
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			return expr
+		case u.Info()&types.IsString != 0:
+			return "len(" + expr + ") != 0"
+		case u.Info()&types.IsNumeric != 0:
+			return expr + " != 0"
+		}
+	case *types.Array, *types.Slice, *types.Map:
+		return "len(" + expr + ") != 0"
+	case *types.Pointer, *types.Interface:
+		return expr + " != nil"
+	}
+	return ""
+}
+
+func (g *generator) generateStruct(n *types.Named) {
+	name := n.Obj().Name()
+	fields, asArray := structFields(n)
+
+	// encoding
+	fmt.Fprintf(&g.buf, "\n// EncodeMsgpack implements the msgpack.Encoder interface.\n")
+	fmt.Fprintf(&g.buf, "func (v *%s) EncodeMsgpack(w *msgpack.Writer) error {\n", name)
+	g.buf.WriteString("if w.Canonical() {\n// WriteRaw canonicalizes the encoding, e.g. sorts the entries of maps.\ndata, err := msgpack.Marshal(v)\nif err != nil {\nreturn err\n}\nreturn w.WriteRaw(data)\n}\n\n")
+	if asArray {
+		fmt.Fprintf(&g.buf, "if err := w.WriteArrayHeader(%d); err != nil {\nreturn err\n}\n", len(fields))
+		for _, f := range fields {
+			// Fields of nil inlined structs are encoded as nil.
+			var conds []string
+			for _, p := range f.inlined {
+				conds = append(conds, "v."+strings.Join(f.path[:p.pathLen], ".")+" != nil")
+			}
+			if len(conds) != 0 {
+				fmt.Fprintf(&g.buf, "if %s {\n", strings.Join(conds, " && "))
+			}
+			g.encode(f.expr(), f.typ, false, 1, f.pos)
+			if len(conds) != 0 {
+				g.buf.WriteString("} else if err := w.WriteNil(); err != nil {\nreturn err\n}\n")
+			}
+		}
+	} else {
+		count := 0
+		for _, f := range fields {
+			if f.present() == "" {
+				count++
+			}
+		}
+		if count == len(fields) {
+			fmt.Fprintf(&g.buf, "if err := w.WriteMapHeader(%d); err != nil {\nreturn err\n}\n", count)
+		} else {
+			fmt.Fprintf(&g.buf, "n := %d\n", count)
+			for _, f := range fields {
+				if cond := f.present(); cond != "" {
+					fmt.Fprintf(&g.buf, "if %s {\nn++\n}\n", cond)
+				}
+			}
+			g.buf.WriteString("if err := w.WriteMapHeader(n); err != nil {\nreturn err\n}\n")
+		}
+		for _, f := range fields {
+			cond := f.present()
+			if cond != "" {
+				fmt.Fprintf(&g.buf, "if %s {\n", cond)
+			}
+			fmt.Fprintf(&g.buf, "if err := w.WriteString(%q); err != nil {\nreturn err\n}\n", f.key)
+			// Fields with the omitempty option are only encoded if they
+			// are not empty, and hence not nil.
+			g.encode(f.expr(), f.typ, f.omitEmpty, 1, f.pos)
+			if cond != "" {
+				g.buf.WriteString("}\n")
+			}
+		}
+	}
+	g.buf.WriteString("return nil\n}\n")
+
+	// decoding
+	fmt.Fprintf(&g.buf, "\n// DecodeMsgpack implements the msgpack.Decoder interface. Both the map and\n// the array layout are accepted.\n")
+	fmt.Fprintf(&g.buf, "func (v *%s) DecodeMsgpack(r *msgpack.Reader) error {\n", name)
+	g.buf.WriteString("typ, err := r.Peek()\nif err != nil {\nreturn err\n}\n")
+	g.buf.WriteString("asArray := typ == msgpack.Array\nvar n int\nif asArray {\nn, err = r.ReadArrayHeader()\n} else {\nn, err = r.ReadMapHeader()\n}\nif err != nil {\nreturn err\n}\n\n")
+	g.buf.WriteString("for i := 0; i < n; i++ {\nfield := i\nif !asArray {\n")
+	g.buf.WriteString("key, err := r.ReadString()\nif err != nil {\nreturn err\n}\nswitch key {\n")
+	for i, f := range fields {
+		fmt.Fprintf(&g.buf, "case %q:\nfield = %d\n", f.key, i)
+	}
+	g.buf.WriteString("default:\nfield = -1\n}\n}\n\nswitch field {\n")
+	for i, f := range fields {
+		fmt.Fprintf(&g.buf, "case %d:\n", i)
+		if len(f.inlined) != 0 {
+			// Fields of nil inlined structs are encoded as nil in the
+			// array layout, which keeps the structs nil.
+			var conds []string
+			for _, p := range f.inlined {
+				conds = append(conds, "v."+strings.Join(f.path[:p.pathLen], ".")+" == nil")
+			}
+			fmt.Fprintf(&g.buf, "if asArray && (%s) {\n", strings.Join(conds, " || "))
+			g.buf.WriteString("if typ, err := r.Peek(); err != nil {\nreturn err\n} else if typ == msgpack.Nil {\n")
+			g.buf.WriteString("if err := r.ReadNil(); err != nil {\nreturn err\n}\ncontinue\n}\n}\n")
+		}
+		for _, p := range f.inlined {
+			expr := "v." + strings.Join(f.path[:p.pathLen], ".")
+			fmt.Fprintf(&g.buf, "if %s == nil {\n%s = new(%s)\n}\n", expr, expr, g.typeExpr(p.elem))
+		}
+		g.decode(f.expr(), f.typ, 1, f.pos)
+	}
+	g.buf.WriteString("default:\nif err := r.Skip(); err != nil {\nreturn err\n}\n}\n}\nreturn nil\n}\n")
+}
+
+// encode writes the statements which encode the value of expr of type t. If
+// nonNil is set, the value is known not to be nil.
+func (g *generator) encode(expr string, t types.Type, nonNil bool, depth int, pos token.Pos) {
+	if g.isEncoder(t) {
+		switch t.Underlying().(type) {
+		case *types.Pointer, *types.Interface:
+			if !nonNil {
+				g.encodeNil(expr)
+				defer g.buf.WriteString("}\n")
+			}
+		}
+		fmt.Fprintf(&g.buf, "if err := %s.EncodeMsgpack(w); err != nil {\nreturn err\n}\n", paren(expr))
+		return
+	}
+	if isTime(t) {
+		fmt.Fprintf(&g.buf, "if err := w.WriteTime(%s); err != nil {\nreturn err\n}\n", expr)
+		return
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		method, goType := basicMethod(u)
+		if method == "" {
+			g.failf(pos, "unsupported type %s", t)
+			return
+		}
+		if needsConversion(t, goType) {
+			expr = goType + "(" + expr + ")"
+		}
+		fmt.Fprintf(&g.buf, "if err := w.Write%s(%s); err != nil {\nreturn err\n}\n", method, expr)
+
+	case *types.Pointer:
+		if !nonNil {
+			g.encodeNil(expr)
+			defer g.buf.WriteString("}\n")
+		}
+		g.encode("*"+expr, u.Elem(), false, depth, pos)
+
+	case *types.Slice:
+		if g.isByteType(u.Elem()) && !isByte(u.Elem()) {
+			g.encodeValue(expr)
+			return
+		}
+		if !nonNil {
+			g.encodeNil(expr)
+			defer g.buf.WriteString("}\n")
+		}
+		if isByte(u.Elem()) {
+			fmt.Fprintf(&g.buf, "if err := w.WriteBytes(%s); err != nil {\nreturn err\n}\n", expr)
+		} else {
+			g.encodeArray(expr, u.Elem(), depth, pos)
+		}
+
+	case *types.Array:
+		switch {
+		case isByte(u.Elem()):
+			fmt.Fprintf(&g.buf, "if err := w.WriteBytes(%s[:]); err != nil {\nreturn err\n}\n", paren(expr))
+		case g.isByteType(u.Elem()):
+			g.encodeValue(expr)
+		default:
+			g.encodeArray(expr, u.Elem(), depth, pos)
+		}
+
+	case *types.Map:
+		if !nonNil {
+			g.encodeNil(expr)
+			defer g.buf.WriteString("}\n")
+		}
+		k, e := fmt.Sprintf("k%d", depth), fmt.Sprintf("e%d", depth)
+		fmt.Fprintf(&g.buf, "if err := w.WriteMapHeader(len(%s)); err != nil {\nreturn err\n}\n", expr)
+		fmt.Fprintf(&g.buf, "for %s, %s := range %s {\n", k, e, expr)
+		g.encode(k, u.Key(), false, depth+1, pos)
+		g.encode(e, u.Elem(), false, depth+1, pos)
+		g.buf.WriteString("}\n")
+
+	case *types.Interface, *types.Struct:
+		// Interfaces and structs without generated methods, e.g. those
+		// of other packages, are encoded by reflection.
+		g.encodeValue(expr)
+
+	default:
+		g.failf(pos, "unsupported type %s", t)
+	}
+}
+
+// encodeNil opens an if statement, which writes nil if the value of expr is
+// nil. The else branch is left open for encoding non-nil values.
+func (g *generator) encodeNil(expr string) {
+	fmt.Fprintf(&g.buf, "if %s == nil {\nif err := w.WriteNil(); err != nil {\nreturn err\n}\n} else {\n", expr)
+}
+
+// encodeValue writes the statements which encode the value of expr by
+// reflection.
+func (g *generator) encodeValue(expr string) {
+	fmt.Fprintf(&g.buf, "if err := w.WriteValue(%s); err != nil {\nreturn err\n}\n", expr)
+}
+
+func (g *generator) encodeArray(expr string, elem types.Type, depth int, pos token.Pos) {
+	e := fmt.Sprintf("e%d", depth)
+	fmt.Fprintf(&g.buf, "if err := w.WriteArrayHeader(len(%s)); err != nil {\nreturn err\n}\n", expr)
+	fmt.Fprintf(&g.buf, "for _, %s := range %s {\n", e, expr)
+	g.encode(e, elem, false, depth+1, pos)
+	g.buf.WriteString("}\n")
+}
+
+// decode writes the statements which decode the next value into lvalue of
+// type t.
+func (g *generator) decode(lvalue string, t types.Type, depth int, pos token.Pos) {
+	if g.isDecoder(t) {
+		fmt.Fprintf(&g.buf, "if err := %s.DecodeMsgpack(r); err != nil {\nreturn err\n}\n", paren(lvalue))
+		return
+	}
+
+	x := fmt.Sprintf("x%d", depth)
+	if isTime(t) {
+		g.decodeNil(lvalue, g.typeExpr(t)+"{}")
+		fmt.Fprintf(&g.buf, "%s, err := r.ReadTime()\nif err != nil {\nreturn err\n}\n%s = %s\n}\n", x, lvalue, x)
+		return
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		method, goType := basicMethod(u)
+		if method == "" {
+			g.failf(pos, "unsupported type %s", t)
+			return
+		}
+		value := x
+		if needsConversion(t, goType) {
+			value = g.typeExpr(t) + "(" + x + ")"
+		}
+		fmt.Fprintf(&g.buf, "%s, err := r.Read%s()\nif err != nil {\nreturn err\n}\n%s = %s\n", x, method, lvalue, value)
+
+	case *types.Pointer:
+		g.decodeNil(lvalue, "nil")
+		fmt.Fprintf(&g.buf, "if %s == nil {\n%s = new(%s)\n}\n", lvalue, lvalue, g.typeExpr(u.Elem()))
+		g.decode("*"+lvalue, u.Elem(), depth+1, pos)
+		g.buf.WriteString("}\n")
+
+	case *types.Slice:
+		if g.isByteType(u.Elem()) && !isByte(u.Elem()) {
+			g.decodeValue(lvalue)
+			return
+		}
+		g.decodeNil(lvalue, "nil")
+		if isByte(u.Elem()) {
+			fmt.Fprintf(&g.buf, "%s, err := r.ReadBytes(nil)\nif err != nil {\nreturn err\n}\n", x)
+			fmt.Fprintf(&g.buf, "if %s == nil {\n%s = []byte{}\n}\n%s = %s\n}\n", x, x, lvalue, x)
+			return
+		}
+
+		// The elements are appended one by one, so that a forged header
+		// cannot trigger a huge allocation.
+		n, i, e := fmt.Sprintf("n%d", depth), fmt.Sprintf("i%d", depth), fmt.Sprintf("e%d", depth)
+		fmt.Fprintf(&g.buf, "%s, err := r.ReadArrayHeader()\nif err != nil {\nreturn err\n}\n", n)
+		fmt.Fprintf(&g.buf, "%s := %s[:0]\nif %s == nil {\n%s = %s{}\n}\n", x, paren(lvalue), x, x, g.typeExpr(t))
+		fmt.Fprintf(&g.buf, "for %s := 0; %s < %s; %s++ {\nvar %s %s\n", i, i, n, i, e, g.typeExpr(u.Elem()))
+		g.decode(e, u.Elem(), depth+1, pos)
+		fmt.Fprintf(&g.buf, "%s = append(%s, %s)\n}\n%s = %s\n}\n", x, x, e, lvalue, x)
+
+	case *types.Array:
+		if g.isByteType(u.Elem()) && !isByte(u.Elem()) {
+			g.decodeValue(lvalue)
+			return
+		}
+		if isByte(u.Elem()) {
+			a := fmt.Sprintf("a%d", depth)
+			fmt.Fprintf(&g.buf, "%s, err := r.ReadBytesNoCopy()\nif err != nil {\nreturn err\n}\n", x)
+			fmt.Fprintf(&g.buf, "var %s %s\ncopy(%s[:], %s)\n%s = %s\n", a, g.typeExpr(t), a, x, lvalue, a)
+			return
+		}
+
+		// Surplus elements are skipped and missing elements are zero.
+		n, i := fmt.Sprintf("n%d", depth), fmt.Sprintf("i%d", depth)
+		fmt.Fprintf(&g.buf, "%s, err := r.ReadArrayHeader()\nif err != nil {\nreturn err\n}\n", n)
+		fmt.Fprintf(&g.buf, "var %s %s\nfor %s := 0; %s < %s; %s++ {\n", x, g.typeExpr(t), i, i, n, i)
+		fmt.Fprintf(&g.buf, "if %s >= len(%s) {\nif err := r.Skip(); err != nil {\nreturn err\n}\ncontinue\n}\n", i, x)
+		g.decode(fmt.Sprintf("%s[%s]", x, i), u.Elem(), depth+1, pos)
+		fmt.Fprintf(&g.buf, "}\n%s = %s\n", lvalue, x)
+
+	case *types.Map:
+		g.decodeNil(lvalue, "nil")
+		n, i := fmt.Sprintf("n%d", depth), fmt.Sprintf("i%d", depth)
+		k, e := fmt.Sprintf("k%d", depth), fmt.Sprintf("e%d", depth)
+		fmt.Fprintf(&g.buf, "%s, err := r.ReadMapHeader()\nif err != nil {\nreturn err\n}\n", n)
+		fmt.Fprintf(&g.buf, "if %s == nil {\n%s = make(%s)\n}\n", lvalue, lvalue, g.typeExpr(t))
+		fmt.Fprintf(&g.buf, "for %s := 0; %s < %s; %s++ {\n", i, i, n, i)
+		// The key and the element are decoded in the same block, so
+		// they need distinct depths for their variables.
+		fmt.Fprintf(&g.buf, "var %s %s\n", k, g.typeExpr(u.Key()))
+		g.decode(k, u.Key(), depth+1, pos)
+		fmt.Fprintf(&g.buf, "var %s %s\n", e, g.typeExpr(u.Elem()))
+		g.decode(e, u.Elem(), depth+2, pos)
+		fmt.Fprintf(&g.buf, "%s[%s] = %s\n}\n}\n", paren(lvalue), k, e)
+
+	case *types.Interface, *types.Struct:
+		g.decodeValue(lvalue)
+
+	default:
+		g.failf(pos, "unsupported type %s", t)
+	}
+}
+
+// decodeValue writes the statements which decode the next value into lvalue
+// by reflection.
+func (g *generator) decodeValue(lvalue string) {
+	fmt.Fprintf(&g.buf, "if err := r.ReadValue(&%s); err != nil {\nreturn err\n}\n", lvalue)
+}
+
+// decodeNil opens an if statement, which assigns zero to lvalue if the next
+// value is nil. The else branch is left open for decoding non-nil values.
+func (g *generator) decodeNil(lvalue, zero string) {
+	g.buf.WriteString("if typ, err := r.Peek(); err != nil {\nreturn err\n} else if typ == msgpack.Nil {\n")
+	fmt.Fprintf(&g.buf, "if err := r.ReadNil(); err != nil {\nreturn err\n}\n%s = %s\n} else {\n", lvalue, zero)
+}
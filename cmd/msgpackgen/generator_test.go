@@ -0,0 +1,199 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTypes = `package gentest
+
+import (
+	"time"
+
+	msgpack "github.com/mprot/msgpack-go"
+)
+
+type Color uint8
+
+type Tags []string
+
+// Reading is a sample.
+//
+//msgpack:generate
+type Reading struct {
+	ID       int64               ` + "`msgpack:\"id\"`" + `
+	Name     string              ` + "`msgpack:\"name,omitempty\"`" + `
+	Color    Color               ` + "`msgpack:\"color\"`" + `
+	Taken    time.Time           ` + "`msgpack:\"taken\"`" + `
+	Note     *string             ` + "`msgpack:\"note,omitempty\"`" + `
+	Pos      Point               ` + "`msgpack:\"pos\"`" + `
+	Path     []Point             ` + "`msgpack:\"path\"`" + `
+	Labels   map[string]int      ` + "`msgpack:\"labels\"`" + `
+	Nested   map[string][]*Point ` + "`msgpack:\"nested\"`" + `
+	Tags     Tags                ` + "`msgpack:\"tags\"`" + `
+	Raw      []byte              ` + "`msgpack:\"raw\"`" + `
+	Hash     [4]byte             ` + "`msgpack:\"hash\"`" + `
+	Grid     [2][2]int16         ` + "`msgpack:\"grid\"`" + `
+	Any      interface{}         ` + "`msgpack:\"any\"`" + `
+	Value    msgpack.Value       ` + "`msgpack:\"value\"`" + `
+	Children []*Reading          ` + "`msgpack:\"children,omitempty\"`" + `
+	Skipped  int                 ` + "`msgpack:\"-\"`" + `
+	F32      float32
+	When     *time.Time
+	Meta     ` + "`msgpack:\",inline\"`" + `
+	*Extra   ` + "`msgpack:\",inline\"`" + `
+
+	unexported int
+}
+
+type Meta struct {
+	Version int ` + "`msgpack:\"version\"`" + `
+}
+
+type Extra struct {
+	ID      string ` + "`msgpack:\"id\"`" + ` // hidden by Reading.ID
+	Comment string ` + "`msgpack:\"comment\"`" + `
+}
+
+type Point struct {
+	_      struct{} ` + "`msgpack:\",array\"`" + `
+	X, Y   float64
+	*Extra ` + "`msgpack:\",inline\"`" + `
+}
+
+// Track embeds a type with generated methods.
+//
+//msgpack:generate
+type Track struct {
+	_      struct{} ` + "`msgpack:\",array\"`" + `
+	X      int
+	S      []string
+	*Point ` + "`msgpack:\",inline\"`" + `
+}
+
+// Custom has its own methods, which are used by the generated ones.
+type Custom struct{ N int }
+
+func (c *Custom) EncodeMsgpack(w *msgpack.Writer) error { return w.WriteInt(c.N) }
+
+func (c *Custom) DecodeMsgpack(r *msgpack.Reader) (err error) {
+	c.N, err = r.ReadInt()
+	return err
+}
+
+//msgpack:generate
+type Wrapper struct {
+	Custom  Custom
+	Customs []*Custom
+}
+`
+
+func TestGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping generation in short mode")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOWORK", "off")
+	t.Setenv("GOFLAGS", "-mod=mod")
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":   "module gentest\n\ngo 1.21\n\nrequire github.com/mprot/msgpack-go v0.0.0\n\nreplace github.com/mprot/msgpack-go => " + root + "\n",
+		"types.go": testTypes,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second run must ignore the methods generated by the first one.
+	for i := 0; i < 2; i++ {
+		if err := run(dir, "msgpack_gen.go", nil, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	src, err := os.ReadFile(filepath.Join(dir, "msgpack_gen.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Reading", "Point", "Wrapper", "Track"} {
+		if !strings.Contains(string(src), "func (v *"+name+") EncodeMsgpack(") {
+			t.Errorf("missing methods of %s", name)
+		}
+	}
+	for _, name := range []string{"Custom", "Meta", "Extra"} {
+		if strings.Contains(string(src), "func (v *"+name+") EncodeMsgpack(") {
+			t.Errorf("unexpected methods of %s", name)
+		}
+	}
+	if n := strings.Count(string(src), "WriteValue(v."); n != 1 {
+		t.Errorf("unexpected number of reflection-based encodings: %d", n)
+	}
+
+	for _, args := range [][]string{{"vet", "."}, {"test", "."}} {
+		cmd := exec.Command(gobin, args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("go %s failed: %v\n%s", args[0], err, out)
+		}
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping generation in short mode")
+	}
+
+	tests := []struct {
+		src       string
+		typeNames []string
+		expected  string
+	}{
+		{
+			src:      "package p\n\ntype T struct{ N int }\n",
+			expected: "no types annotated with //msgpack:generate",
+		},
+		{
+			src:       "package p\n\ntype T struct{ N int }\n",
+			typeNames: []string{"U"},
+			expected:  "type U not found",
+		},
+		{
+			src:       "package p\n\ntype T []int\n",
+			typeNames: []string{"T"},
+			expected:  "T is not a struct type",
+		},
+		{
+			src:      "package p\n\n//msgpack:generate\ntype T struct{ C chan int }\n",
+			expected: "unsupported type chan int",
+		},
+		{
+			src:      "package p\n\n//msgpack:generate\ntype T struct{ N int }\n\nfunc (T) DecodeMsgpack() {}\n",
+			expected: "type T already has a DecodeMsgpack method",
+		},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "p.go"), []byte(test.src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := run(dir, "msgpack_gen.go", test.typeNames, true)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("unexpected error for %q: %v (expected %q)", test.src, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Command msgpackgen generates EncodeMsgpack and DecodeMsgpack methods for
+// struct types, which call the methods of msgpack.Writer and msgpack.Reader
+// directly instead of using reflection. It is meant to be run by go generate:
+//
+//	//go:generate go run github.com/mprot/msgpack-go/cmd/msgpackgen
+//
+// Usage:
+//
+//	msgpackgen [flags] [dir]
+//
+// The package in dir, or in the current directory if no directory is given,
+// is searched for struct types annotated with a //msgpack:generate line in
+// their doc comment:
+//
+//	//msgpack:generate
+//	type Point struct {
+//		X, Y int
+//	}
+//
+// Methods are generated for the annotated types and for all struct types of
+// the same package which are used by their fields and have no methods of
+// their own. The generated methods encode a struct exactly like the
+// reflection-based encoding (see msgpack.Writer.WriteValue), including the
+// handling of msgpack struct tags and the positional array layout, and
+// decode a struct from both layouts. In canonical mode (see
+// msgpack.Writer.SetCanonical), the generated methods encode the struct
+// normally and canonicalize the result, which sorts the entries of maps.
+//
+// Besides the methods, a test file is generated, which checks that the
+// generated encoding matches the reflection-based one, unless the struct
+// embeds a type with methods of its own, and that values survive a round
+// trip. The flags are:
+//
+//	-o file
+//		name of the generated file (default "msgpack_gen.go"). The test
+//		file is named after it with a "_test.go" suffix.
+//	-type names
+//		comma-separated list of additional types to generate methods for
+//	-tests
+//		generate the test file (default true)
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// generatedHeader starts every file written by msgpackgen.
+const generatedHeader = "// Code generated by msgpackgen. DO NOT EDIT."
+
+// directive marks the struct types to generate methods for.
+const directive = "//msgpack:generate"
+
+func main() {
+	output := flag.String("o", "msgpack_gen.go", "name of the generated `file`")
+	typeNames := flag.String("type", "", "comma-separated list of additional type `names`")
+	tests := flag.Bool("tests", true, "generate the test file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: msgpackgen [flags] [dir]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	dir := "."
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		dir = flag.Arg(0)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var names []string
+	if *typeNames != "" {
+		names = strings.Split(*typeNames, ",")
+	}
+	if err := run(dir, *output, names, *tests); err != nil {
+		fmt.Fprintf(os.Stderr, "msgpackgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, output string, typeNames []string, tests bool) error {
+	fset := token.NewFileSet()
+	pkg, files, err := loadPackage(fset, dir)
+	if err != nil {
+		return err
+	}
+
+	named, err := selectTypes(pkg, files, typeNames)
+	if err != nil {
+		return err
+	}
+
+	g := newGenerator(fset, pkg)
+	src, err := g.generate(named)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, output), src, 0o644); err != nil {
+		return err
+	}
+
+	if !tests {
+		return nil
+	}
+	src, err = g.generateTests()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, strings.TrimSuffix(output, ".go")+"_test.go"), src, 0o644)
+}
+
+// loadPackage parses and type-checks the package in dir. Files generated by
+// msgpackgen are left out, so that their methods do not interfere with a new
+// run. Type errors are ignored, because the remaining files may depend on the
+// methods of the left out files.
+func loadPackage(fset *token.FileSet, dir string) (*types.Package, []*ast.File, error) {
+	bpkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var files []*ast.File
+	for _, name := range bpkg.GoFiles {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(f.Comments) != 0 && strings.HasPrefix(f.Comments[0].List[0].Text, generatedHeader) {
+			continue
+		}
+		files = append(files, f)
+	}
+
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(error) {},
+	}
+	pkg, _ := conf.Check(bpkg.ImportPath, fset, files, nil)
+	return pkg, files, nil
+}
+
+// selectTypes returns the types annotated with the msgpack:generate directive
+// and the types listed in typeNames.
+func selectTypes(pkg *types.Package, files []*ast.File, typeNames []string) ([]*types.Named, error) {
+	selected := make(map[string]bool)
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if hasDirective(ts.Doc) || (len(gen.Specs) == 1 && hasDirective(gen.Doc)) {
+					selected[ts.Name.Name] = true
+				}
+			}
+		}
+	}
+	for _, name := range typeNames {
+		selected[strings.TrimSpace(name)] = true
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no types annotated with %s in package %s", directive, pkg.Name())
+	}
+
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	named := make([]*types.Named, len(names))
+	for i, name := range names {
+		obj, ok := pkg.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			return nil, fmt.Errorf("type %s not found in package %s", name, pkg.Name())
+		}
+		n, ok := obj.Type().(*types.Named)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a defined type", name)
+		}
+		if _, ok := n.Underlying().(*types.Struct); !ok {
+			return nil, fmt.Errorf("%s is not a struct type", name)
+		}
+		if n.TypeParams().Len() != 0 {
+			return nil, fmt.Errorf("generic type %s is not supported", name)
+		}
+		for _, method := range []string{"EncodeMsgpack", "DecodeMsgpack"} {
+			if sel := types.NewMethodSet(types.NewPointer(n)).Lookup(pkg, method); sel != nil {
+				return nil, fmt.Errorf("type %s already has a %s method", name, method)
+			}
+		}
+		named[i] = n
+	}
+	return named, nil
+}
+
+func hasDirective(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.TrimSpace(c.Text) == directive {
+			return true
+		}
+	}
+	return false
+}
+
+// formatImports writes the package clause and the import declaration of a
+// generated file. The imports map package names to import paths. Packages of
+// the standard library are grouped before all others.
+func formatImports(buf *bytes.Buffer, pkgName string, imports map[string]string) {
+	var std, other []string
+	for name, path := range imports {
+		if elem, _, _ := strings.Cut(path, "/"); strings.Contains(elem, ".") {
+			other = append(other, name)
+		} else {
+			std = append(std, name)
+		}
+	}
+
+	fmt.Fprintf(buf, "%s\n\npackage %s\n\nimport (\n", generatedHeader, pkgName)
+	for i, names := range [][]string{std, other} {
+		if i > 0 && len(std) != 0 && len(other) != 0 {
+			buf.WriteByte('\n')
+		}
+		sort.Slice(names, func(i, j int) bool {
+			return imports[names[i]] < imports[names[j]]
+		})
+		for _, name := range names {
+			path := imports[name]
+			if name == path[strings.LastIndex(path, "/")+1:] {
+				fmt.Fprintf(buf, "%q\n", path)
+			} else {
+				fmt.Fprintf(buf, "%s %q\n", name, path)
+			}
+		}
+	}
+	buf.WriteString(")\n")
+}
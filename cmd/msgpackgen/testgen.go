@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxSampleDepth limits the nesting of generated struct types in a sample
+// value, so that samples of recursive types are finite.
+const maxSampleDepth = 2
+
+// generateTest writes a test for the generated methods of n. The test encodes
+// the zero value and a sample value of n, compares the result with the
+// reflection-based encoding of a method-free copy of n and decodes it again.
+// The copy is left out if n promotes the methods of an embedded field, which
+// the copy would keep. The canonical encoding is compared with Canonicalize.
+func (g *generator) generateTest(n *types.Named) {
+	name := n.Obj().Name()
+	r, size := utf8.DecodeRuneInString(name)
+	testName := "TestMsgpack" + string(unicode.ToUpper(r)) + name[size:]
+
+	sample, ok := g.sample(n, 1)
+	if !ok {
+		sample = name + "{}"
+	}
+	sample = strings.TrimPrefix(sample, name)
+	plain := !g.promotesMethods(n.Underlying().(*types.Struct), map[types.Type]bool{})
+
+	fmt.Fprintf(&g.buf, "\nfunc %s(t *testing.T) {\n", testName)
+	if plain {
+		fmt.Fprintf(&g.buf, "type plain %s // encoded by reflection\n\n", name)
+	}
+	g.buf.WriteString("opts := msgpack.EqualOptions{IgnoreMapOrder: true}\n")
+	fmt.Fprintf(&g.buf, "for i, v := range []%s{{}, %s} {\n", name, sample)
+	g.buf.WriteString(`data, err := msgpack.Marshal(&v)
+if err != nil {
+	t.Fatalf("value %d: unexpected encoding error: %v", i, err)
+}
+`)
+	if plain {
+		g.buf.WriteString(`expected, err := msgpack.MarshalValue((*plain)(&v))
+if err != nil {
+	t.Fatalf("value %d: unexpected reflection error: %v", i, err)
+}
+if eq, err := msgpack.EqualWithOptions(data, expected, opts); err != nil || !eq {
+	t.Errorf("value %d: encoding %x differs from reflection %x", i, data, expected)
+}
+`)
+	}
+	g.buf.WriteString(`
+canonical, err := msgpack.Canonicalize(data)
+if err != nil {
+	t.Fatalf("value %d: unexpected canonicalization error: %v", i, err)
+}
+var buf bytes.Buffer
+w := msgpack.NewWriter(&buf)
+w.SetCanonical(true)
+if err := v.EncodeMsgpack(w); err != nil {
+	t.Fatalf("value %d: unexpected canonical encoding error: %v", i, err)
+}
+if err := w.Flush(); err != nil {
+	t.Fatalf("value %d: unexpected flush error: %v", i, err)
+}
+if !bytes.Equal(buf.Bytes(), canonical) {
+	t.Errorf("value %d: canonical encoding %x differs from %x", i, buf.Bytes(), canonical)
+}
+
+`)
+	fmt.Fprintf(&g.buf, "var decoded %s\n", name)
+	g.buf.WriteString(`if err := msgpack.Unmarshal(data, &decoded); err != nil {
+	t.Fatalf("value %d: unexpected decoding error: %v", i, err)
+}
+reencoded, err := msgpack.Marshal(&decoded)
+if err != nil {
+	t.Fatalf("value %d: unexpected encoding error: %v", i, err)
+}
+if eq, err := msgpack.EqualWithOptions(data, reencoded, opts); err != nil || !eq {
+	t.Errorf("value %d: round trip mismatch: %x != %x", i, data, reencoded)
+}
+}
+}
+`)
+}
+
+// promotesMethods reports whether an embedded field of st, or of the structs
+// embedded in it, has an EncodeMsgpack or DecodeMsgpack method, including the
+// generated ones, which would be promoted to st.
+func (g *generator) promotesMethods(st *types.Struct, visited map[types.Type]bool) bool {
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		if !f.Embedded() {
+			continue
+		}
+		t := f.Type()
+		if p, ok := t.(*types.Pointer); ok {
+			t = p.Elem()
+		}
+		if n, ok := t.(*types.Named); ok && g.queued[n] {
+			return true
+		}
+		mset := types.NewMethodSet(types.NewPointer(t))
+		if mset.Lookup(g.pkg, "EncodeMsgpack") != nil || mset.Lookup(g.pkg, "DecodeMsgpack") != nil {
+			return true
+		}
+		if visited[t] {
+			continue
+		}
+		visited[t] = true
+		if est, ok := t.Underlying().(*types.Struct); ok && g.promotesMethods(est, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// sample returns the Go expression of a non-zero value of type t. If no such
+// value can be constructed, false is returned.
+func (g *generator) sample(t types.Type, depth int) (string, bool) {
+	if n, ok := t.(*types.Named); ok && g.queued[n] {
+		return g.sampleStruct(n, depth)
+	}
+	if p, ok := t.(*types.Pointer); ok {
+		if n, ok := p.Elem().(*types.Named); ok && g.queued[n] {
+			if elem, ok := g.sampleStruct(n, depth); ok {
+				return "&" + elem, true
+			}
+			return "", false
+		}
+	}
+	if g.isEncoder(t) || g.isDecoder(t) {
+		// Types with their own methods are left to their own tests.
+		return "", false
+	}
+	if isTime(t) {
+		pkg := strings.TrimSuffix(g.typeExpr(t), ".Time")
+		return pkg + ".Unix(1700000000, 123456789)", true
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			return "true", true
+		case u.Info()&types.IsString != 0:
+			return `"msgpack"`, true
+		case u.Info()&types.IsUnsigned != 0:
+			return "200", true
+		case u.Info()&types.IsInteger != 0:
+			return "-100", true
+		case u.Info()&types.IsFloat != 0:
+			return "1.5", true
+		}
+		return "", false
+
+	case *types.Pointer:
+		elem, ok := g.sample(u.Elem(), depth)
+		if !ok {
+			return "", false
+		}
+		if isComposite(u.Elem()) {
+			return "&" + elem, true
+		}
+		e := g.typeExpr(u.Elem())
+		if _, ok := u.Elem().Underlying().(*types.Basic); ok {
+			elem = e + "(" + elem + ")"
+		}
+		return fmt.Sprintf("func() *%s { x := %s; return &x }()", e, elem), true
+
+	case *types.Slice:
+		if isByte(u.Elem()) {
+			return g.typeExpr(t) + `("msgpack")`, true
+		}
+		elem, _ := g.sample(u.Elem(), depth)
+		return g.typeExpr(t) + "{" + g.elide(elem, u.Elem()) + "}", true
+
+	case *types.Array:
+		elem, _ := g.sample(u.Elem(), depth)
+		return g.typeExpr(t) + "{" + g.elide(elem, u.Elem()) + "}", true
+
+	case *types.Map:
+		key, ok := g.sample(u.Key(), depth)
+		elem, ok2 := g.sample(u.Elem(), depth)
+		if !ok || !ok2 {
+			return g.typeExpr(t) + "{}", true
+		}
+		return g.typeExpr(t) + "{" + g.elide(key, u.Key()) + ": " + g.elide(elem, u.Elem()) + "}", true
+
+	case *types.Interface:
+		if u.NumMethods() == 0 {
+			return `"msgpack"`, true
+		}
+	}
+	return "", false
+}
+
+// inlinedStruct returns the struct type of a field of the generated package,
+// which can be inlined, and reports whether the field is a pointer. Samples
+// of inlined structs are constructed like those of generated structs.
+func (g *generator) inlinedStruct(sf *types.Var) (*types.Named, bool) {
+	t, isPtr := sf.Type(), false
+	if p, ok := t.(*types.Pointer); ok {
+		t, isPtr = p.Elem(), true
+	}
+	n, ok := t.(*types.Named)
+	if !ok || n.Obj().Pkg() != g.pkg {
+		return nil, false
+	}
+	if _, ok := n.Underlying().(*types.Struct); !ok {
+		return nil, false
+	}
+	return n, isPtr
+}
+
+// sampleStruct returns a composite literal of the struct type n,
+// which sets all fields for which a sample can be constructed.
+func (g *generator) sampleStruct(n *types.Named, depth int) (string, bool) {
+	if depth > maxSampleDepth {
+		return "", false
+	}
+
+	st := n.Underlying().(*types.Struct)
+	var elems []string
+	for i := 0; i < st.NumFields(); i++ {
+		sf := st.Field(i)
+		tag := reflect.StructTag(st.Tag(i)).Get("msgpack")
+		if tag == "-" || sf.Name() == "_" {
+			continue
+		}
+		_, opts := parseTag(tag)
+		if !sf.Exported() && !opts.contains("inline") {
+			continue
+		}
+		value, ok := g.sample(sf.Type(), depth+1)
+		if inlined, isPtr := g.inlinedStruct(sf); inlined != nil && opts.contains("inline") {
+			value, ok = g.sampleStruct(inlined, depth+1)
+			if isPtr {
+				value = "&" + value
+			}
+		}
+		if ok {
+			elems = append(elems, sf.Name()+": "+value)
+		}
+	}
+	if len(elems) == 0 {
+		return g.typeExpr(n) + "{}", true
+	}
+	return g.typeExpr(n) + "{\n" + strings.Join(elems, ",\n") + ",\n}", true
+}
+
+// elide removes the type of a composite literal, which is the element of a
+// slice, array or map of type t.
+func (g *generator) elide(expr string, t types.Type) string {
+	typ := g.typeExpr(t)
+	if p, ok := t.(*types.Pointer); ok {
+		typ = "&" + g.typeExpr(p.Elem())
+	}
+	if strings.HasPrefix(expr, typ+"{") {
+		return expr[len(typ):]
+	}
+	return expr
+}
+
+// isComposite reports whether samples of t are composite literals, whose
+// address can be taken.
+func isComposite(t types.Type) bool {
+	if isTime(t) {
+		return false
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Struct, *types.Array, *types.Map:
+		return true
+	case *types.Slice:
+		return !isByte(u.Elem())
+	default:
+		return false
+	}
+}
@@ -0,0 +1,90 @@
+// Command msgpackinfer infers a schema from a sequence of MessagePack sample
+// values and generates Go types with EncodeMsgpack and DecodeMsgpack methods
+// for it.
+//
+// Usage:
+//
+//	msgpackinfer [flags] [file ...]
+//
+// The samples are read from the given files, or from the standard input if
+// no files are given. The flags are:
+//
+//	-o file
+//		write the output to file instead of the standard output
+//	-package name
+//		package name of the generated code (default "main")
+//	-type name
+//		name of the generated root type (default "Root")
+//	-schema
+//		write the inferred schema as JSON instead of Go code
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	msgpack "github.com/mprot/msgpack-go"
+)
+
+func main() {
+	output := flag.String("o", "", "write the output to `file` instead of the standard output")
+	pkg := flag.String("package", "main", "package `name` of the generated code")
+	typeName := flag.String("type", "Root", "`name` of the generated root type")
+	schemaOnly := flag.Bool("schema", false, "write the inferred schema as JSON instead of Go code")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: msgpackinfer [flags] [file ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(*output, *pkg, *typeName, *schemaOnly, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "msgpackinfer: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(output, pkg, typeName string, schemaOnly bool, files []string) error {
+	var samples io.Reader = os.Stdin
+	if len(files) != 0 {
+		readers := make([]io.Reader, len(files))
+		for i, name := range files {
+			f, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			readers[i] = f
+		}
+		samples = io.MultiReader(readers...)
+	}
+
+	schema, err := msgpack.InferSchema(samples)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if schemaOnly {
+		data, err := json.MarshalIndent(schema, "", "\t")
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	} else {
+		opts := msgpack.GoOptions{Package: pkg, TypeName: typeName}
+		if err := msgpack.GenerateGo(&buf, schema, opts); err != nil {
+			return err
+		}
+	}
+
+	if output == "" {
+		_, err = buf.WriteTo(os.Stdout)
+		return err
+	}
+	return os.WriteFile(output, buf.Bytes(), 0o644)
+}
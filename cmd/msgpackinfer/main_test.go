@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	msgpack "github.com/mprot/msgpack-go"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	samples := []msgpack.Value{
+		msgpack.MapValue(
+			msgpack.KeyValue{Key: msgpack.StringValue("id"), Value: msgpack.UintValue(1)},
+			msgpack.KeyValue{Key: msgpack.StringValue("name"), Value: msgpack.StringValue("a")},
+		),
+		msgpack.MapValue(
+			msgpack.KeyValue{Key: msgpack.StringValue("id"), Value: msgpack.UintValue(300)},
+		),
+	}
+
+	var files []string
+	for i, v := range samples {
+		data, err := msgpack.Marshal(v)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		name := filepath.Join(dir, fmt.Sprintf("sample%d.msgpack", i))
+		if err := os.WriteFile(name, data, 0o644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		files = append(files, name)
+	}
+
+	schemaFile := filepath.Join(dir, "schema.json")
+	if err := run(schemaFile, "main", "Root", true, files); err != nil {
+		t.Fatalf("unexpected error in schema mode: %v", err)
+	}
+	data, err := os.ReadFile(schemaFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	schema, err := msgpack.ParseSchema(data)
+	if err != nil {
+		t.Fatalf("unexpected error for schema %s: %v", data, err)
+	}
+	if len(schema.Required) != 1 || schema.Required[0] != "id" {
+		t.Errorf("unexpected required properties: %v", schema.Required)
+	}
+	for _, v := range samples {
+		raw, _ := msgpack.Marshal(v)
+		if err := schema.ValidateBytes(raw); err != nil {
+			t.Errorf("unexpected validation error: %v", err)
+		}
+	}
+
+	goFile := filepath.Join(dir, "types.go")
+	if err := run(goFile, "samples", "Reading", false, files); err != nil {
+		t.Fatalf("unexpected error in Go mode: %v", err)
+	}
+	src, err := os.ReadFile(goFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, s := range []string{
+		"package samples",
+		"type Reading struct",
+		"ID   uint16",
+		"Name *string",
+		"func (v *Reading) EncodeMsgpack(w *msgpack.Writer) error",
+		"func (v *Reading) DecodeMsgpack(r *msgpack.Reader) error",
+	} {
+		if !strings.Contains(string(src), s) {
+			t.Errorf("missing %q in generated code:\n%s", s, src)
+		}
+	}
+
+	if err := run(goFile, "samples", "Reading", false, []string{filepath.Join(dir, "missing")}); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
@@ -0,0 +1,850 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const msgpackPath = "github.com/mprot/msgpack-go"
+
+// diagnostic is a problem found in the methods of a type.
+type diagnostic struct {
+	pos token.Pos
+	msg string
+}
+
+// valueKind describes the values, which are written or read by a method of
+// msgpack.Writer or msgpack.Reader.
+type valueKind struct {
+	family string // "any" for methods which handle all values
+	bits   int    // size of numbers
+}
+
+var writeKinds = map[string]valueKind{
+	"WriteNil":         {"nil", 0},
+	"WriteBool":        {"bool", 0},
+	"WriteInt":         {"int", 64},
+	"WriteInt8":        {"int", 8},
+	"WriteInt16":       {"int", 16},
+	"WriteInt32":       {"int", 32},
+	"WriteInt64":       {"int", 64},
+	"WriteUint":        {"uint", 64},
+	"WriteUint8":       {"uint", 8},
+	"WriteUint16":      {"uint", 16},
+	"WriteUint32":      {"uint", 32},
+	"WriteUint64":      {"uint", 64},
+	"WriteFloat32":     {"float", 32},
+	"WriteFloat64":     {"float", 64},
+	"WriteBytes":       {"blob", 0},
+	"WriteString":      {"blob", 0},
+	"WriteArrayHeader": {"array", 0},
+	"WriteMapHeader":   {"map", 0},
+	"WriteExt":         {"ext", 0},
+	"WriteTime":        {"time", 0},
+	"WriteRaw":         {"any", 0},
+	"WriteValue":       {"any", 0},
+}
+
+var readKinds = map[string]valueKind{
+	"ReadNil":                 {"nil", 0},
+	"ReadBool":                {"bool", 0},
+	"ReadInt":                 {"int", 64},
+	"ReadInt8":                {"int", 8},
+	"ReadInt16":               {"int", 16},
+	"ReadInt32":               {"int", 32},
+	"ReadInt64":               {"int", 64},
+	"ReadUint":                {"uint", 64},
+	"ReadUint8":               {"uint", 8},
+	"ReadUint16":              {"uint", 16},
+	"ReadUint32":              {"uint", 32},
+	"ReadUint64":              {"uint", 64},
+	"ReadFloat32":             {"float", 32},
+	"ReadFloat64":             {"float", 64},
+	"ReadBytes":               {"blob", 0},
+	"ReadBytesNoCopy":         {"blob", 0},
+	"ReadString":              {"blob", 0},
+	"ReadArrayHeader":         {"array", 0},
+	"ReadArrayHeaderWithSize": {"array", 0},
+	"ReadMapHeader":           {"map", 0},
+	"ReadExt":                 {"ext", 0},
+	"ReadExtAny":              {"ext", 0},
+	"ReadTime":                {"time", 0},
+	"ReadRaw":                 {"any", 0},
+	"ReadValue":               {"any", 0},
+	"Skip":                    {"any", 0},
+}
+
+// compareKinds reports whether a value written as w can be read as r. For
+// values which can only be read in some cases, a note is returned.
+func compareKinds(w, r valueKind) (bool, string) {
+	switch {
+	case w.family == "any" || r.family == "any" || w.family == "nil":
+		// The reader accepts nil for all values except ReadNil.
+		return true, ""
+	case w.family == "time" && r.family == "ext":
+		return true, ""
+	case w.family != r.family && !(w.family == "int" && r.family == "uint") && !(w.family == "uint" && r.family == "int"):
+		return false, ""
+	case w.family == "int" && r.family == "uint":
+		return true, "which fails for negative values"
+	case w.family == "uint" && r.family == "int" && r.bits <= w.bits:
+		return true, "which may overflow"
+	case r.bits < w.bits:
+		return true, "which may overflow"
+	}
+	return true, ""
+}
+
+// opKind is the kind of an operation of an EncodeMsgpack or DecodeMsgpack
+// method.
+type opKind int
+
+const (
+	opCall    opKind = iota // call of a Writer or Reader method
+	opNested                // call of an EncodeMsgpack or DecodeMsgpack method
+	opLoop                  // loop with operations in its body
+	opBranch                // conditional with operations in its branches
+	opUnknown               // call of another function, which is passed the Writer or Reader
+)
+
+// op is an operation of an EncodeMsgpack or DecodeMsgpack method.
+type op struct {
+	kind  opKind
+	pos   token.Pos
+	name  string     // method name, e.g. "WriteInt" or "Inner.EncodeMsgpack"
+	typ   types.Type // type of nested calls
+	size  int64      // constant header size, or -1
+	field string     // receiver field which is written or read, if known
+	body  []*op      // body of loops
+}
+
+func isHeader(name string) bool {
+	return strings.HasSuffix(name, "ArrayHeader") || strings.HasSuffix(name, "MapHeader") || name == "ReadArrayHeaderWithSize"
+}
+
+// headerValues returns the number of values following a header with a
+// constant size, or -1 if it is not known.
+func (o *op) headerValues() int64 {
+	if o.kind != opCall || !isHeader(o.name) || o.size < 0 {
+		return -1
+	}
+	if strings.HasSuffix(o.name, "MapHeader") {
+		return 2 * o.size
+	}
+	return o.size
+}
+
+// checker checks the EncodeMsgpack and DecodeMsgpack methods of a package.
+type checker struct {
+	fset  *token.FileSet
+	info  *types.Info
+	diags []diagnostic
+}
+
+// check checks the types of a package, which have both an EncodeMsgpack and
+// a DecodeMsgpack method. Methods in generated files are ignored.
+func check(fset *token.FileSet, files []*ast.File, info *types.Info) []diagnostic {
+	c := &checker{fset: fset, info: info}
+
+	methods := make(map[string]*[2]*ast.FuncDecl)
+	var names []string
+	for _, f := range files {
+		if ast.IsGenerated(f) {
+			continue
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || fn.Body == nil || len(fn.Type.Params.List) != 1 {
+				continue
+			}
+
+			var idx int
+			switch fn.Name.Name {
+			case "EncodeMsgpack":
+				idx = 0
+			case "DecodeMsgpack":
+				idx = 1
+			default:
+				continue
+			}
+			if !isStream(info.TypeOf(fn.Type.Params.List[0].Type), [2]string{"Writer", "Reader"}[idx]) {
+				continue
+			}
+
+			name := recvTypeName(fn.Recv.List[0].Type)
+			if methods[name] == nil {
+				methods[name] = new([2]*ast.FuncDecl)
+				names = append(names, name)
+			}
+			methods[name][idx] = fn
+		}
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		if m := methods[name]; m[0] != nil && m[1] != nil {
+			c.checkType(name, m[0], m[1])
+		}
+	}
+
+	sort.SliceStable(c.diags, func(i, j int) bool {
+		return c.diags[i].pos < c.diags[j].pos
+	})
+	return c.diags
+}
+
+func (c *checker) reportf(pos token.Pos, format string, args ...interface{}) {
+	c.diags = append(c.diags, diagnostic{pos: pos, msg: fmt.Sprintf(format, args...)})
+}
+
+// where returns the short position of an operation, which is mentioned in
+// a diagnostic.
+func (c *checker) where(pos token.Pos) string {
+	p := c.fset.Position(pos)
+	return fmt.Sprintf("%s:%d", filepath.Base(p.Filename), p.Line)
+}
+
+func (c *checker) checkType(name string, enc, dec *ast.FuncDecl) {
+	encOps := c.extract(enc)
+	decOps := c.extract(dec)
+
+	c.checkHeaders(name, encOps)
+	c.checkHeaders(name, decOps)
+
+	// Missing fields shift all following operations, so the operations are
+	// only compared if no field is missing.
+	if c.checkFields(name, enc, dec) {
+		c.compare(name, encOps, decOps)
+	}
+}
+
+// checkFields reports the receiver fields, which are only used by one of the
+// methods, and reports whether all fields are used by both. The fields are
+// not checked if the receiver is used for anything else than field access.
+func (c *checker) checkFields(name string, enc, dec *ast.FuncDecl) bool {
+	encFields, ok := c.usedFields(enc)
+	if !ok {
+		return true
+	}
+	decFields, ok := c.usedFields(dec)
+	if !ok {
+		return true
+	}
+
+	complete := true
+	for _, f := range sortedFields(encFields) {
+		if _, ok := decFields[f]; !ok {
+			c.reportf(encFields[f], "%s: field %s is encoded but not decoded", name, f)
+			complete = false
+		}
+	}
+	for _, f := range sortedFields(decFields) {
+		if _, ok := encFields[f]; !ok {
+			c.reportf(decFields[f], "%s: field %s is decoded but not encoded", name, f)
+			complete = false
+		}
+	}
+	return complete
+}
+
+// usedFields returns the receiver fields used in fn with the position of
+// their first use. Like in the extracted operations, conditions and early
+// returns, e.g. of a cached encoding, are left out, as they do not encode or
+// decode fields. If the receiver is unnamed or used for anything else than
+// field access, false is returned.
+func (c *checker) usedFields(fn *ast.FuncDecl) (map[string]token.Pos, bool) {
+	recv := c.recvObject(fn)
+	if recv == nil {
+		return nil, false
+	}
+
+	fields := make(map[string]token.Pos)
+	ok := true
+	var visit func(n ast.Node)
+	visit = func(n ast.Node) {
+		if n == nil {
+			return
+		}
+		ast.Inspect(n, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.IfStmt:
+				visit(n.Init)
+				if n.Else != nil || !terminates(n.Body) {
+					visit(n.Body)
+				}
+				visit(n.Else)
+				return false
+			case *ast.ForStmt:
+				visit(n.Init)
+				visit(n.Post)
+				visit(n.Body)
+				return false
+			case *ast.SwitchStmt:
+				visit(n.Init)
+				for _, clause := range n.Body.List {
+					for _, s := range clause.(*ast.CaseClause).Body {
+						visit(s)
+					}
+				}
+				return false
+			case *ast.SelectorExpr:
+				if id, isIdent := n.X.(*ast.Ident); isIdent && c.info.Uses[id] == recv {
+					if f := c.rootField(recv, n); f != "" {
+						if _, seen := fields[f]; !seen {
+							fields[f] = n.Pos()
+						}
+					} else {
+						ok = false
+					}
+					return false
+				}
+			case *ast.Ident:
+				if c.info.Uses[n] == recv {
+					ok = false
+				}
+			}
+			return true
+		})
+	}
+	visit(fn.Body)
+	return fields, ok
+}
+
+func sortedFields(fields map[string]token.Pos) []string {
+	names := make([]string, 0, len(fields))
+	for f := range fields {
+		names = append(names, f)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return fields[names[i]] < fields[names[j]]
+	})
+	return names
+}
+
+// checkHeaders reports headers of a constant size, which are followed by less
+// values than announced.
+func (c *checker) checkHeaders(name string, ops []*op) {
+	for i, o := range ops {
+		if o.kind == opLoop {
+			c.checkHeaders(name, o.body)
+			continue
+		}
+
+		n := o.headerValues()
+		if n < 0 {
+			continue
+		}
+		j := i + 1
+		for k := int64(0); k < n; k++ {
+			if j == len(ops) {
+				c.reportf(o.pos, "%s: %s(%d) is followed by only %d values", name, o.name, o.size, k)
+				break
+			}
+			if j = skipValue(ops, j); j < 0 {
+				break
+			}
+		}
+	}
+}
+
+// skipValue returns the index of the operation following the value, which
+// starts at ops[i]. If the end of the value is not known, -1 is returned.
+func skipValue(ops []*op, i int) int {
+	o := ops[i]
+	if o.kind != opCall && o.kind != opNested {
+		return -1
+	}
+	i++
+	if o.kind == opCall && isHeader(o.name) {
+		n := o.headerValues()
+		if n < 0 {
+			return -1
+		}
+		for ; n > 0; n-- {
+			if i == len(ops) {
+				return -1
+			}
+			if i = skipValue(ops, i); i < 0 {
+				return -1
+			}
+		}
+	}
+	return i
+}
+
+// compare matches the operations of EncodeMsgpack with those of DecodeMsgpack
+// and reports the first mismatch. It reports whether the comparison can be
+// continued after the given operations.
+func (c *checker) compare(name string, enc, dec []*op) bool {
+	for i := 0; ; i++ {
+		switch {
+		case i == len(enc) && i == len(dec):
+			return true
+		case i == len(dec):
+			if o := enc[i]; o.kind == opCall || o.kind == opNested {
+				c.reportf(o.pos, "%s: %s is not read by DecodeMsgpack", name, o.name)
+			}
+			return false
+		case i == len(enc):
+			if o := dec[i]; o.kind == opCall || o.kind == opNested {
+				c.reportf(o.pos, "%s: %s is not written by EncodeMsgpack", name, o.name)
+			}
+			return false
+		}
+
+		e, d := enc[i], dec[i]
+		switch {
+		case e.kind == opBranch || e.kind == opUnknown || d.kind == opBranch || d.kind == opUnknown:
+			return false
+		case e.kind == opLoop && d.kind == opLoop:
+			if !c.compare(name, e.body, d.body) {
+				return false
+			}
+		case e.kind == opLoop || d.kind == opLoop:
+			return false
+		case !c.match(name, e, d):
+			return false
+		}
+	}
+}
+
+// match compares a write with a read and reports whether they match.
+func (c *checker) match(name string, e, d *op) bool {
+	if e.field != "" && d.field != "" && e.field != d.field {
+		c.reportf(e.pos, "%s: field %s is written where DecodeMsgpack reads field %s at %s", name, e.field, d.field, c.where(d.pos))
+		return false
+	}
+
+	switch {
+	case e.kind == opNested && d.kind == opNested:
+		if !types.Identical(e.typ, d.typ) {
+			c.reportf(e.pos, "%s: %s does not match %s at %s", name, e.name, d.name, c.where(d.pos))
+			return false
+		}
+
+	case e.kind == opCall && d.kind == opCall:
+		ok, note := compareKinds(writeKinds[e.name], readKinds[d.name])
+		switch {
+		case !ok:
+			c.reportf(e.pos, "%s: %s does not match %s at %s", name, e.name, d.name, c.where(d.pos))
+			return false
+		case note != "":
+			c.reportf(e.pos, "%s: %s is read by %s at %s, %s", name, e.name, d.name, c.where(d.pos), note)
+			return false
+		}
+
+		if e.name == "WriteArrayHeader" && d.name == "ReadArrayHeaderWithSize" && e.size >= 0 && d.size >= 0 && e.size != d.size {
+			c.reportf(e.pos, "%s: WriteArrayHeader(%d) does not match ReadArrayHeaderWithSize(%d) at %s", name, e.size, d.size, c.where(d.pos))
+			return false
+		}
+
+	case e.kind == opNested:
+		// The values of nested calls are not known, so they only match calls,
+		// which handle all values.
+		return readKinds[d.name].family == "any"
+
+	default:
+		return writeKinds[e.name].family == "any"
+	}
+	return true
+}
+
+// extract returns the operations of an EncodeMsgpack or DecodeMsgpack method
+// in the order in which they are executed.
+func (c *checker) extract(fn *ast.FuncDecl) []*op {
+	x := &extractor{
+		checker: c,
+		recv:    c.recvObject(fn),
+		vars:    make(map[types.Object]*op),
+	}
+	return x.stmts(fn.Body.List)
+}
+
+func (c *checker) recvObject(fn *ast.FuncDecl) types.Object {
+	names := fn.Recv.List[0].Names
+	if len(names) == 0 || names[0].Name == "_" {
+		return nil
+	}
+	return c.info.Defs[names[0]]
+}
+
+// rootField returns the name of the receiver field, which is accessed by
+// sel or contains the accessed promoted field. If sel is no field access,
+// an empty string is returned.
+func (c *checker) rootField(recv types.Object, sel *ast.SelectorExpr) string {
+	s := c.info.Selections[sel]
+	if s == nil || s.Kind() != types.FieldVal {
+		return ""
+	}
+	st, ok := deref(recv.Type()).Underlying().(*types.Struct)
+	if !ok {
+		return ""
+	}
+	return st.Field(s.Index()[0]).Name()
+}
+
+// extractor collects the operations of a method.
+type extractor struct {
+	*checker
+	recv types.Object
+	vars map[types.Object]*op // local variables holding the result of a read
+}
+
+func (x *extractor) stmts(list []ast.Stmt) []*op {
+	var ops []*op
+	for _, s := range list {
+		ops = append(ops, x.stmt(s)...)
+	}
+	return ops
+}
+
+func (x *extractor) stmt(s ast.Stmt) []*op {
+	switch s := s.(type) {
+	case nil:
+		return nil
+
+	case *ast.BlockStmt:
+		return x.stmts(s.List)
+
+	case *ast.LabeledStmt:
+		return x.stmt(s.Stmt)
+
+	case *ast.AssignStmt:
+		ops := x.calls(s)
+		x.assign(s, ops)
+		return ops
+
+	case *ast.IfStmt:
+		ops := x.stmt(s.Init)
+		ops = append(ops, x.calls(s.Cond)...)
+		body := x.stmts(s.Body.List)
+		els := x.stmt(s.Else)
+		switch {
+		case len(body) == 0 && len(els) == 0:
+		case s.Else == nil && terminates(s.Body):
+			// Early returns, e.g. for nil values, are left out.
+		default:
+			ops = append(ops, &op{kind: opBranch, pos: s.Pos()})
+		}
+		return ops
+
+	case *ast.ForStmt:
+		ops := x.stmt(s.Init)
+		ops = append(ops, x.calls(s.Cond)...)
+		body := x.stmts(s.Body.List)
+		body = append(body, x.stmt(s.Post)...)
+		if len(body) != 0 {
+			ops = append(ops, &op{kind: opLoop, pos: s.Pos(), body: body})
+		}
+		return ops
+
+	case *ast.RangeStmt:
+		ops := x.calls(s.X)
+		if body := x.stmts(s.Body.List); len(body) != 0 {
+			ops = append(ops, &op{kind: opLoop, pos: s.Pos(), body: body})
+		}
+		return ops
+
+	case *ast.SwitchStmt:
+		ops := x.stmt(s.Init)
+		ops = append(ops, x.calls(s.Tag)...)
+		return append(ops, x.clauses(s.Pos(), s.Body)...)
+
+	case *ast.TypeSwitchStmt:
+		ops := x.stmt(s.Init)
+		ops = append(ops, x.stmt(s.Assign)...)
+		return append(ops, x.clauses(s.Pos(), s.Body)...)
+
+	case *ast.SelectStmt:
+		return x.clauses(s.Pos(), s.Body)
+
+	default:
+		return x.calls(s)
+	}
+}
+
+// clauses returns a branch operation, if one of the clauses of a switch or
+// select statement contains operations.
+func (x *extractor) clauses(pos token.Pos, body *ast.BlockStmt) []*op {
+	for _, clause := range body.List {
+		var ops []*op
+		switch clause := clause.(type) {
+		case *ast.CaseClause:
+			for _, e := range clause.List {
+				ops = append(ops, x.calls(e)...)
+			}
+			ops = append(ops, x.stmts(clause.Body)...)
+		case *ast.CommClause:
+			ops = append(ops, x.stmt(clause.Comm)...)
+			ops = append(ops, x.stmts(clause.Body)...)
+		}
+		if len(ops) != 0 {
+			return []*op{{kind: opBranch, pos: pos}}
+		}
+	}
+	return nil
+}
+
+// assign records the receiver fields, which are assigned the results of the
+// reads in ops, directly or through local variables.
+func (x *extractor) assign(s *ast.AssignStmt, ops []*op) {
+	if len(ops) != 0 && len(s.Rhs) == 1 {
+		if call, ok := unparen(s.Rhs[0]).(*ast.CallExpr); ok {
+			if last := ops[len(ops)-1]; last.pos == call.Pos() && last.field == "" {
+				if f := x.field(s.Lhs[0]); f != "" {
+					last.field = f
+				} else if id, ok := s.Lhs[0].(*ast.Ident); ok {
+					if obj := x.info.ObjectOf(id); obj != nil {
+						x.vars[obj] = last
+					}
+				}
+				return
+			}
+		}
+	}
+
+	if len(s.Lhs) != len(s.Rhs) {
+		return
+	}
+	for i, lhs := range s.Lhs {
+		f := x.field(lhs)
+		if f == "" {
+			continue
+		}
+		ast.Inspect(s.Rhs[i], func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				if o := x.vars[x.info.Uses[id]]; o != nil && o.field == "" {
+					o.field = f
+				}
+			}
+			return true
+		})
+	}
+}
+
+// calls returns the operations of the calls in n in the order in which they
+// are evaluated. Function literals are skipped.
+func (x *extractor) calls(n ast.Node) []*op {
+	if n == nil {
+		return nil
+	}
+
+	var ops []*op
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			// The function and its arguments are evaluated before the call.
+			ops = append(ops, x.calls(n.Fun)...)
+			for _, arg := range n.Args {
+				ops = append(ops, x.calls(arg)...)
+			}
+			if o := x.call(n); o != nil {
+				ops = append(ops, o)
+			}
+			return false
+		}
+		return true
+	})
+	return ops
+}
+
+// call returns the operation of a call, or nil if it does not write or read
+// values.
+func (x *extractor) call(call *ast.CallExpr) *op {
+	if sel, ok := unparen(call.Fun).(*ast.SelectorExpr); ok {
+		if s := x.info.Selections[sel]; s != nil && s.Kind() == types.MethodVal {
+			name := sel.Sel.Name
+			switch {
+			case isStream(s.Recv(), "Writer"):
+				if _, ok := writeKinds[name]; ok {
+					return &op{kind: opCall, pos: call.Pos(), name: name, size: x.headerSize(call), field: x.argField(call)}
+				}
+				return nil
+
+			case isStream(s.Recv(), "Reader"):
+				if _, ok := readKinds[name]; ok {
+					return &op{kind: opCall, pos: call.Pos(), name: name, size: x.headerSize(call), field: x.argField(call)}
+				}
+				return nil
+
+			case (name == "EncodeMsgpack" || name == "DecodeMsgpack") && len(call.Args) == 1 && x.isStreamArg(call.Args[0]):
+				t := deref(s.Recv())
+				return &op{
+					kind:  opNested,
+					pos:   call.Pos(),
+					name:  types.TypeString(t, types.RelativeTo(x.recvPkg())) + "." + name,
+					typ:   t,
+					size:  -1,
+					field: x.field(sel.X),
+				}
+			}
+		}
+	}
+
+	for _, arg := range call.Args {
+		if x.isStreamArg(arg) {
+			return &op{kind: opUnknown, pos: call.Pos()}
+		}
+	}
+	return nil
+}
+
+func (x *extractor) recvPkg() *types.Package {
+	if x.recv == nil {
+		return nil
+	}
+	return x.recv.Pkg()
+}
+
+func (x *extractor) isStreamArg(arg ast.Expr) bool {
+	t := x.info.TypeOf(arg)
+	return isStream(t, "Writer") || isStream(t, "Reader")
+}
+
+// headerSize returns the constant size argument of a header call, or -1.
+func (x *extractor) headerSize(call *ast.CallExpr) int64 {
+	sel := unparen(call.Fun).(*ast.SelectorExpr)
+	if !isHeader(sel.Sel.Name) || len(call.Args) != 1 {
+		return -1
+	}
+	tv, ok := x.info.Types[call.Args[0]]
+	if !ok || tv.Value == nil {
+		return -1
+	}
+	size, ok := constant.Int64Val(constant.ToInt(tv.Value))
+	if !ok {
+		return -1
+	}
+	return size
+}
+
+// argField returns the receiver field used in the arguments of a call, if
+// there is exactly one.
+func (x *extractor) argField(call *ast.CallExpr) string {
+	var field string
+	for _, arg := range call.Args {
+		f, ok := x.fields(arg)
+		if !ok || (f != "" && field != "" && f != field) {
+			return ""
+		}
+		if f != "" {
+			field = f
+		}
+	}
+	return field
+}
+
+// field returns the receiver field used in e, if there is exactly one.
+func (x *extractor) field(e ast.Expr) string {
+	f, _ := x.fields(e)
+	return f
+}
+
+// fields returns the receiver field used in n. If there are several fields,
+// false is returned.
+func (x *extractor) fields(n ast.Node) (string, bool) {
+	if x.recv == nil {
+		return "", true
+	}
+
+	var field string
+	ok := true
+	ast.Inspect(n, func(n ast.Node) bool {
+		sel, isSel := n.(*ast.SelectorExpr)
+		if !isSel {
+			return true
+		}
+		id, isIdent := sel.X.(*ast.Ident)
+		if !isIdent || x.info.Uses[id] != x.recv {
+			return true
+		}
+		if f := x.rootField(x.recv, sel); f != "" {
+			if field != "" && f != field {
+				ok = false
+			}
+			field = f
+		}
+		return false
+	})
+	if !ok {
+		return "", false
+	}
+	return field, true
+}
+
+// terminates reports whether a block ends with a return statement or a call
+// of panic.
+func terminates(b *ast.BlockStmt) bool {
+	if len(b.List) == 0 {
+		return false
+	}
+	switch s := b.List[len(b.List)-1].(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.ExprStmt:
+		if call, ok := s.X.(*ast.CallExpr); ok {
+			id, ok := call.Fun.(*ast.Ident)
+			return ok && id.Name == "panic"
+		}
+	}
+	return false
+}
+
+// isStream reports whether t is msgpack.Writer or msgpack.Reader, or a
+// pointer to it.
+func isStream(t types.Type, name string) bool {
+	if t == nil {
+		return false
+	}
+	n, ok := deref(t).(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := n.Obj()
+	return obj.Name() == name && obj.Pkg() != nil && obj.Pkg().Path() == msgpackPath
+}
+
+func deref(t types.Type) types.Type {
+	if p, ok := t.(*types.Pointer); ok {
+		return p.Elem()
+	}
+	return t
+}
+
+// recvTypeName returns the name of the receiver type of a method.
+func recvTypeName(e ast.Expr) string {
+	for {
+		switch t := e.(type) {
+		case *ast.StarExpr:
+			e = t.X
+		case *ast.ParenExpr:
+			e = t.X
+		case *ast.IndexExpr:
+			e = t.X
+		case *ast.IndexListExpr:
+			e = t.X
+		case *ast.Ident:
+			return t.Name
+		default:
+			return ""
+		}
+	}
+}
+
+func unparen(e ast.Expr) ast.Expr {
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			return e
+		}
+		e = p.X
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testSource contains the types to check. Lines with expected diagnostics
+// end in a comment "// want" followed by a regular expression.
+const testSource = `package vettest
+
+import msgpack "github.com/mprot/msgpack-go"
+
+type Point struct{ X, Y float64 }
+
+func (p *Point) EncodeMsgpack(w *msgpack.Writer) error {
+	if p == nil {
+		return w.WriteNil()
+	}
+	if err := w.WriteArrayHeader(2); err != nil {
+		return err
+	}
+	if err := w.WriteFloat64(p.X); err != nil {
+		return err
+	}
+	return w.WriteFloat64(p.Y)
+}
+
+func (p *Point) DecodeMsgpack(r *msgpack.Reader) (err error) {
+	if err = r.ReadArrayHeaderWithSize(2); err != nil {
+		return err
+	}
+	if p.X, err = r.ReadFloat64(); err != nil {
+		return err
+	}
+	p.Y, err = r.ReadFloat64()
+	return err
+}
+
+type Path struct {
+	Name   string
+	Points []Point
+	Color  uint8
+}
+
+func (p *Path) EncodeMsgpack(w *msgpack.Writer) error {
+	w.WriteBytes([]byte(p.Name))
+	w.WriteArrayHeader(len(p.Points))
+	for i := range p.Points {
+		if err := p.Points[i].EncodeMsgpack(w); err != nil {
+			return err
+		}
+	}
+	return w.WriteUint8(p.Color)
+}
+
+func (p *Path) DecodeMsgpack(r *msgpack.Reader) error {
+	name, err := r.ReadString()
+	if err != nil {
+		return err
+	}
+	p.Name = name
+	n, err := r.ReadArrayHeader()
+	if err != nil {
+		return err
+	}
+	p.Points = make([]Point, n)
+	for i := range p.Points {
+		if err := r.ReadValue(&p.Points[i]); err != nil {
+			return err
+		}
+	}
+	c, err := r.ReadUint16()
+	p.Color = uint8(c)
+	return err
+}
+
+type Delegated struct{ N int }
+
+func (d Delegated) EncodeMsgpack(w *msgpack.Writer) error { return encodeInt(w, d.N) }
+
+func (d *Delegated) DecodeMsgpack(r *msgpack.Reader) (err error) {
+	d.N, err = r.ReadInt()
+	return err
+}
+
+func encodeInt(w *msgpack.Writer, n int) error { return w.WriteInt(n) }
+
+type Kinds struct{ Name string }
+
+func (k Kinds) EncodeMsgpack(w *msgpack.Writer) error {
+	return w.WriteString(k.Name) // want "Kinds: WriteString does not match ReadInt at types.go:\\d+"
+}
+
+func (k *Kinds) DecodeMsgpack(r *msgpack.Reader) error {
+	n, err := r.ReadInt()
+	k.Name = string(rune(n))
+	return err
+}
+
+type Narrow struct{ N int64 }
+
+func (n Narrow) EncodeMsgpack(w *msgpack.Writer) error {
+	return w.WriteInt64(n.N) // want "Narrow: WriteInt64 is read by ReadInt32 at .*, which may overflow"
+}
+
+func (n *Narrow) DecodeMsgpack(r *msgpack.Reader) error {
+	v, err := r.ReadInt32()
+	n.N = int64(v)
+	return err
+}
+
+type Signed struct{ N int }
+
+func (s Signed) EncodeMsgpack(w *msgpack.Writer) error {
+	return w.WriteInt(s.N) // want "Signed: WriteInt is read by ReadUint at .*, which fails for negative values"
+}
+
+func (s *Signed) DecodeMsgpack(r *msgpack.Reader) error {
+	v, err := r.ReadUint()
+	s.N = int(v)
+	return err
+}
+
+type Order struct{ A, B string }
+
+func (o Order) EncodeMsgpack(w *msgpack.Writer) error {
+	w.WriteString(o.A) // want "Order: field A is written where DecodeMsgpack reads field B"
+	return w.WriteString(o.B)
+}
+
+func (o *Order) DecodeMsgpack(r *msgpack.Reader) (err error) {
+	if o.B, err = r.ReadString(); err != nil {
+		return err
+	}
+	o.A, err = r.ReadString()
+	return err
+}
+
+type Size struct{ A, B int }
+
+func (s Size) EncodeMsgpack(w *msgpack.Writer) error {
+	w.WriteArrayHeader(2) // want "Size: WriteArrayHeader\\(2\\) does not match ReadArrayHeaderWithSize\\(3\\)"
+	w.WriteInt(s.A)
+	return w.WriteInt(s.B)
+}
+
+func (s *Size) DecodeMsgpack(r *msgpack.Reader) (err error) {
+	if err = r.ReadArrayHeaderWithSize(3); err != nil { // want "Size: ReadArrayHeaderWithSize\\(3\\) is followed by only 2 values"
+		return err
+	}
+	s.A, err = r.ReadInt()
+	s.B, err = r.ReadInt()
+	return err
+}
+
+type Count struct{ A, B int }
+
+func (c Count) EncodeMsgpack(w *msgpack.Writer) error {
+	w.WriteMapHeader(2) // want "Count: WriteMapHeader\\(2\\) is followed by only 3 values"
+	w.WriteString("a")
+	w.WriteInt(c.A)
+	return w.WriteInt(c.B)
+}
+
+func (c *Count) DecodeMsgpack(r *msgpack.Reader) error {
+	if _, err := r.ReadMapHeader(); err != nil {
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		key, err := r.ReadString()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "a":
+			c.A, err = r.ReadInt()
+		case "b":
+			c.B, err = r.ReadInt()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type Missing struct{ A, B, C int }
+
+func (m Missing) EncodeMsgpack(w *msgpack.Writer) error {
+	w.WriteInt(m.A)
+	w.WriteInt(m.B) // want "Missing: field B is encoded but not decoded"
+	return w.WriteInt(m.C)
+}
+
+func (m *Missing) DecodeMsgpack(r *msgpack.Reader) (err error) {
+	m.A, err = r.ReadInt()
+	m.C, err = r.ReadInt()
+	return err
+}
+
+type Cached struct {
+	ID     int
+	Name   string
+	cache  []byte
+	strict bool
+}
+
+func (c *Cached) EncodeMsgpack(w *msgpack.Writer) error {
+	if c.cache != nil {
+		return w.WriteRaw(c.cache)
+	}
+	if c.strict && c.ID < 0 {
+		return w.WriteNil()
+	}
+	w.WriteInt(c.ID)
+	return w.WriteString(c.Name) // want "Cached: WriteString does not match ReadBool at types.go:\\d+"
+}
+
+func (c *Cached) DecodeMsgpack(r *msgpack.Reader) (err error) {
+	if c.ID, err = r.ReadInt(); err != nil {
+		return err
+	}
+	named, err := r.ReadBool()
+	if named {
+		c.Name = "x"
+	}
+	return err
+}
+
+type Extra struct{ N int }
+
+func (e Extra) EncodeMsgpack(w *msgpack.Writer) error {
+	w.WriteInt(e.N)
+	return w.WriteNil() // want "Extra: WriteNil is not read by DecodeMsgpack"
+}
+
+func (e *Extra) DecodeMsgpack(r *msgpack.Reader) (err error) {
+	e.N, err = r.ReadInt()
+	return err
+}
+`
+
+// generatedSource contains a mismatch, which is not reported for generated
+// files.
+const generatedSource = `// Code generated by hand. DO NOT EDIT.
+
+package vettest
+
+import msgpack "github.com/mprot/msgpack-go"
+
+type Generated struct{ N int }
+
+func (g Generated) EncodeMsgpack(w *msgpack.Writer) error { return w.WriteInt(g.N) }
+
+func (g *Generated) DecodeMsgpack(r *msgpack.Reader) (err error) {
+	_, err = r.ReadString()
+	return err
+}
+`
+
+func TestCheck(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping type-checking in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOWORK", "off")
+	t.Setenv("GOFLAGS", "-mod=mod")
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":       "module vettest\n\ngo 1.21\n\nrequire github.com/mprot/msgpack-go v0.0.0\n\nreplace github.com/mprot/msgpack-go => " + root + "\n",
+		"types.go":     testSource,
+		"generated.go": generatedSource,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diags, err := checkDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantRegexp := regexp.MustCompile(`// want (".*")$`)
+	want := make(map[int]*regexp.Regexp)
+	for i, line := range strings.Split(testSource, "\n") {
+		if m := wantRegexp.FindStringSubmatch(line); m != nil {
+			expr, err := strconv.Unquote(m[1])
+			if err != nil {
+				t.Fatalf("line %d: invalid expectation: %v", i+1, err)
+			}
+			want[i+1] = regexp.MustCompile(expr)
+		}
+	}
+
+	prefix := filepath.Join(dir, "types.go") + ":"
+	for _, d := range diags {
+		rest, ok := strings.CutPrefix(d, prefix)
+		if !ok {
+			t.Errorf("unexpected diagnostic: %s", d)
+			continue
+		}
+		pos, msg, _ := strings.Cut(rest, ": ")
+		line, _ := strconv.Atoi(strings.Split(pos, ":")[0])
+		switch re := want[line]; {
+		case re == nil:
+			t.Errorf("unexpected diagnostic: %s", d)
+		case !re.MatchString(msg):
+			t.Errorf("line %d: diagnostic %q does not match %q", line, msg, re)
+		default:
+			delete(want, line)
+		}
+	}
+	for line, re := range want {
+		t.Errorf("line %d: missing diagnostic %q", line, re)
+	}
+}
@@ -0,0 +1,148 @@
+// Command msgpackvet checks that hand-written EncodeMsgpack and DecodeMsgpack
+// methods agree with each other.
+//
+// Usage:
+//
+//	msgpackvet [dir ...]
+//
+// The packages in the given directories, or in the current directory if no
+// directory is given, are checked. A directory ending in "/..." includes all
+// packages below it.
+//
+// For every type with both methods, the calls of msgpack.Writer methods in
+// EncodeMsgpack are matched with the calls of msgpack.Reader methods in
+// DecodeMsgpack in the order in which they are executed. Loops are matched
+// with loops, and conditional early returns, e.g. for nil values, are left
+// out. The following problems are reported:
+//   - values which are read with a method of another type, e.g. WriteString
+//     and ReadInt, or with a narrower one, e.g. WriteInt64 and ReadInt32
+//   - values which are written and read in a different order, if the written
+//     and read struct fields are known
+//   - array headers of a constant size, which differs between WriteArrayHeader
+//     and ReadArrayHeaderWithSize, or which are followed by fewer values
+//   - values which are only written or only read
+//   - struct fields which are only used by one of the methods, where fields
+//     in conditions and early returns, e.g. of a cached encoding, do not
+//     count
+//
+// The matching stops at the first problem, and wherever the methods differ
+// in their structure, e.g. at conditionals with different branches. Files
+// generated by tools are not checked.
+//
+// The exit status is 1 if a problem was found or a package could not be
+// loaded.
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	dirs := os.Args[1:]
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	failed := false
+	for _, dir := range expandDirs(dirs) {
+		diags, err := checkDir(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "msgpackvet: %v\n", err)
+			failed = true
+			continue
+		}
+		for _, d := range diags {
+			fmt.Fprintln(os.Stderr, d)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// expandDirs replaces the directories ending in "/..." by all directories
+// below them, which are not ignored by the go command.
+func expandDirs(dirs []string) []string {
+	var res []string
+	for _, dir := range dirs {
+		root, ok := strings.CutSuffix(dir, "/...")
+		if !ok {
+			res = append(res, dir)
+			continue
+		}
+
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+			name := d.Name()
+			if path != root && (name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			if _, err := build.ImportDir(path, 0); err == nil {
+				res = append(res, path)
+			}
+			return nil
+		})
+	}
+	return res
+}
+
+// checkDir checks the package in dir and returns the diagnostics as strings.
+func checkDir(dir string) ([]string, error) {
+	fset := token.NewFileSet()
+	files, info, err := loadPackage(fset, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	diags := check(fset, files, info)
+	res := make([]string, len(diags))
+	for i, d := range diags {
+		res[i] = fmt.Sprintf("%s: %s", fset.Position(d.pos), d.msg)
+	}
+	return res, nil
+}
+
+// loadPackage parses and type-checks the package in dir. Type errors are
+// ignored, so that the methods can still be checked if parts of the package
+// are broken.
+func loadPackage(fset *token.FileSet, dir string) ([]*ast.File, *types.Info, error) {
+	bpkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var files []*ast.File
+	for _, name := range bpkg.GoFiles {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, f)
+	}
+
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(error) {},
+	}
+	conf.Check(bpkg.ImportPath, fset, files, info)
+	return files, info, nil
+}
@@ -0,0 +1,324 @@
+package msgpack
+
+import (
+	"bytes"
+	"math"
+)
+
+// Compare compares the MessagePack encoded values a and b and returns -1 if
+// a is less than b, 0 if both are equal, and +1 if a is greater than b. The
+// values are compared without decoding them completely: the comparison
+// stops at the first difference.
+//
+// Compare defines a total order. Values of different types are ordered as
+// follows:
+//
+//	nil < bool < numbers < strings < binary data < arrays < maps < extensions
+//
+// false is less than true. Numbers are compared by their value, regardless
+// of whether they are encoded as signed, unsigned or floating-point values
+// of any width. NaN values are equal to each other and less than all other
+// numbers. Strings and binary data are compared byte-wise. Arrays are
+// compared lexicographically by their elements, maps lexicographically by
+// their keys and values in the encoded order. In both cases, a prefix is
+// less than the longer value. Extension values are ordered by their type
+// first, where time values (type -1) are compared chronologically and all
+// other extension values by their data.
+//
+// Values which are equal according to Equal compare as equal. Data following
+// the first value of a and b is ignored.
+func Compare(a, b []byte) (int, error) {
+	c := comparer{ra: NewReaderBytes(a), rb: NewReaderBytes(b)}
+	return c.compare()
+}
+
+// comparer compares two values item by item. Nested arrays and maps are
+// tracked on a stack instead of being compared recursively.
+type comparer struct {
+	ra, rb *Reader
+	stack  []compareFrame // open arrays and maps, innermost last
+}
+
+type compareFrame struct {
+	remaining int // number of items left to compare
+	na, nb    int // number of elements of both containers
+}
+
+func (c *comparer) compare() (int, error) {
+	for {
+		res, err := c.compareItems()
+		if err != nil {
+			if len(c.stack) != 0 {
+				err = unexpectedEOF(err)
+			}
+			return 0, err
+		} else if res != 0 {
+			return res, nil
+		}
+
+		// All compared items of the exhausted containers are equal, so a
+		// prefix is less than the longer container.
+		for n := len(c.stack); n > 0 && c.stack[n-1].remaining == 0; n-- {
+			f := c.stack[n-1]
+			c.stack = c.stack[:n-1]
+			if res := compareInt64(int64(f.na), int64(f.nb)); res != 0 {
+				return res, nil
+			}
+		}
+		if len(c.stack) == 0 {
+			return 0, nil
+		}
+		c.stack[len(c.stack)-1].remaining--
+	}
+}
+
+// compareItems compares the next items of both readers. For arrays and
+// maps, only the headers are read and a new frame is pushed.
+func (c *comparer) compareItems() (int, error) {
+	ra, rb := c.ra, c.rb
+	ta, err := ra.Peek()
+	if err != nil {
+		return 0, err
+	}
+	tb, err := rb.Peek()
+	if err != nil {
+		return 0, err
+	}
+	if res := compareInt64(int64(typeRank(ta)), int64(typeRank(tb))); res != 0 {
+		return res, nil
+	}
+
+	switch ta {
+	case Nil:
+		if err := ra.ReadNil(); err != nil {
+			return 0, err
+		}
+		return 0, rb.ReadNil()
+
+	case Bool:
+		ba, err := ra.ReadBool()
+		if err != nil {
+			return 0, err
+		}
+		bb, err := rb.ReadBool()
+		return compareBool(ba, bb), err
+
+	case Int, Uint, Float:
+		na, err := readNumber(ra)
+		if err != nil {
+			return 0, err
+		}
+		nb, err := readNumber(rb)
+		return compareNumbers(na, nb), err
+
+	case String:
+		sa, err := ra.readStringNoCopy()
+		if err != nil {
+			return 0, err
+		}
+		sb, err := rb.readStringNoCopy()
+		return bytes.Compare(sa, sb), err
+
+	case Bytes:
+		ba, err := ra.ReadBytesNoCopy()
+		if err != nil {
+			return 0, err
+		}
+		bb, err := rb.ReadBytesNoCopy()
+		return bytes.Compare(ba, bb), err
+
+	case Array, Map:
+		return 0, c.openContainers(ta == Map)
+
+	default:
+		return compareExtensions(ra, rb, ta, tb)
+	}
+}
+
+func (c *comparer) openContainers(isMap bool) error {
+	read := (*Reader).ReadArrayHeader
+	if isMap {
+		read = (*Reader).ReadMapHeader
+	}
+
+	na, err := read(c.ra)
+	if err != nil {
+		return err
+	}
+	nb, err := read(c.rb)
+	if err != nil {
+		return err
+	}
+
+	n := min(na, nb)
+	if isMap {
+		n *= 2
+	}
+	c.stack = append(c.stack, compareFrame{remaining: n, na: na, nb: nb})
+	return nil
+}
+
+func compareExtensions(ra, rb *Reader, ta, tb Type) (int, error) {
+	if ta == Time && tb == Time {
+		tma, err := ra.ReadTime()
+		if err != nil {
+			return 0, err
+		}
+		tmb, err := rb.ReadTime()
+		switch {
+		case err != nil:
+			return 0, err
+		case tma.Before(tmb):
+			return -1, nil
+		case tma.After(tmb):
+			return 1, nil
+		default:
+			return 0, nil
+		}
+	}
+
+	xa, da, err := ra.ReadExtAny()
+	if err != nil {
+		return 0, err
+	}
+	xb, db, err := rb.ReadExtAny()
+	if err != nil {
+		return 0, err
+	}
+	if c := compareInt64(int64(xa), int64(xb)); c != 0 {
+		return c, nil
+	}
+	return bytes.Compare(da, db), nil
+}
+
+// typeRank returns the position of typ within the order of types defined by
+// Compare.
+func typeRank(typ Type) int {
+	switch typ {
+	case Nil:
+		return 0
+	case Bool:
+		return 1
+	case Int, Uint, Float:
+		return 2
+	case String:
+		return 3
+	case Bytes:
+		return 4
+	case Array:
+		return 5
+	case Map:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// readNumber reads an integer or floating-point value into a Value.
+func readNumber(r *Reader) (Value, error) {
+	switch typ, _ := r.Peek(); typ {
+	case Int:
+		i, err := r.ReadInt64()
+		return IntValue(i), err
+	case Uint:
+		u, err := r.ReadUint64()
+		return UintValue(u), err
+	default:
+		f, err := r.ReadFloat64()
+		return FloatValue(f), err
+	}
+}
+
+func (v Value) isNumber() bool {
+	return v.typ == Int || v.typ == Uint || v.typ == Float
+}
+
+// compareNumbers compares the numeric values a and b by their value and
+// returns -1, 0 or +1. NaN values are considered equal to each other and
+// less than all other numbers.
+func compareNumbers(a, b Value) int {
+	switch {
+	case a.typ == Float || b.typ == Float:
+		return compareFloats(a, b)
+	case a.typ == b.typ && a.typ == Int:
+		return compareInt64(int64(a.num), int64(b.num))
+	case a.typ == b.typ:
+		return compareUint64(a.num, b.num)
+	case a.typ == Int && int64(a.num) < 0:
+		return -1
+	case b.typ == Int && int64(b.num) < 0:
+		return 1
+	default:
+		return compareUint64(a.num, b.num)
+	}
+}
+
+func compareFloats(a, b Value) int {
+	fa, fb := a.Float(), b.Float()
+	switch {
+	case math.IsNaN(fa) || math.IsNaN(fb):
+		return compareBool(!math.IsNaN(fa), !math.IsNaN(fb))
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+
+	// Both values are equal as floating-point numbers. If one of them is an
+	// integer, it might have lost precision during the conversion.
+	switch {
+	case a.typ != Float:
+		return -compareIntFloat(b.Float(), a)
+	case b.typ != Float:
+		return compareIntFloat(a.Float(), b)
+	default:
+		return 0
+	}
+}
+
+// compareIntFloat compares the floating-point value f with the integer value
+// v, where f equals v converted to a float64. Thus, f is integral and v is
+// not negative if f is not.
+func compareIntFloat(f float64, v Value) int {
+	switch {
+	case f >= 1<<64:
+		return 1
+	case f < 0:
+		return compareInt64(int64(f), int64(v.num))
+	default:
+		return compareUint64(uint64(f), v.num)
+	}
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareUint64(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
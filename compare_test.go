@@ -0,0 +1,83 @@
+package msgpack
+
+import (
+	"io"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	// groups of equal values in ascending order
+	groups := [][][]byte{
+		{{tagNil}},
+		{{tagFalse}},
+		{{tagTrue}},
+		{{tagFloat64, 0x7f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, {tagFloat32, 0xff, 0xc0, 0x00, 0x01}},
+		{{tagFloat64, 0xff, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}}, // -Inf
+		{{tagInt64, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, {tagFloat64, 0xc3, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{{negFixintTag(-1)}, {tagInt64, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, {tagFloat32, 0xbf, 0x80, 0x00, 0x00}},
+		{{posFixintTag(0)}, {tagInt8, 0x00}, {tagFloat64, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{{tagFloat32, 0x3f, 0x00, 0x00, 0x00}}, // 0.5
+		{{posFixintTag(1)}, {tagUint64, 0, 0, 0, 0, 0, 0, 0, 1}},
+		{{tagUint64, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, {tagInt64, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{{tagFloat64, 0x43, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}}, // 2^63
+		{{tagUint64, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{{tagFloat64, 0x7f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}}, // +Inf
+		{{fixstrTag(0)}, {tagStr8, 0x00}},
+		{{fixstrTag(1), 'a'}, {tagStr16, 0x00, 0x01, 'a'}},
+		{{fixstrTag(2), 'a', 'a'}},
+		{{fixstrTag(1), 'b'}},
+		{{tagBin8, 0x00}},
+		{{tagBin8, 0x01, 'a'}},
+		{{fixarrayTag(0)}, {tagArray16, 0x00, 0x00}},
+		{{fixarrayTag(1), tagNil}},
+		{{fixarrayTag(2), tagNil, posFixintTag(1)}, {tagArray32, 0, 0, 0, 2, tagNil, tagUint8, 0x01}},
+		{{fixarrayTag(1), posFixintTag(1)}},
+		{{fixmapTag(0)}},
+		{{fixmapTag(1), fixstrTag(1), 'a', tagNil}},
+		{{fixmapTag(1), fixstrTag(1), 'a', tagTrue}},
+		{{fixmapTag(1), fixstrTag(1), 'b', tagNil}},
+		{{tagFixExt1, 0xfe, 0x00}},
+		{{tagFixExt4, 0xff, 0x00, 0x00, 0x00, 0x01}, {tagFixExt8, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}},
+		{{tagFixExt4, 0xff, 0x00, 0x00, 0x00, 0x02}},
+		{{tagFixExt1, 0x01, 0x00}},
+		{{tagFixExt1, 0x01, 0x01}},
+		{{tagFixExt2, 0x01, 0x01, 0x00}},
+		{{tagFixExt1, 0x02, 0x00}},
+	}
+
+	for gi, ga := range groups {
+		for gj, gb := range groups {
+			expected := compareInt64(int64(gi), int64(gj))
+			for _, a := range ga {
+				for _, b := range gb {
+					if c, err := Compare(a, b); err != nil {
+						t.Errorf("unexpected error for %x and %x: %v", a, b, err)
+					} else if c != expected {
+						t.Errorf("unexpected result for %x and %x: %d (expected %d)", a, b, c, expected)
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := Compare([]byte{fixarrayTag(1)}, []byte{fixarrayTag(1), tagNil}); err == nil {
+		t.Errorf("expected error for truncated value")
+	}
+}
+
+func TestCompareDeepNesting(t *testing.T) {
+	limitStack(t)
+
+	const depth = 200000
+	a := nestedArrays(depth)
+	b := append(nestedArrays(depth)[:depth], tagTrue)
+	if c, err := Compare(a, b); err != nil || c != -1 {
+		t.Errorf("unexpected result: %d (error: %v)", c, err)
+	}
+	if c, err := Compare(a, a); err != nil || c != 0 {
+		t.Errorf("unexpected result: %d (error: %v)", c, err)
+	}
+	if _, err := Compare(a[:depth], a); err != io.ErrUnexpectedEOF {
+		t.Errorf("unexpected error for truncated value: %v", err)
+	}
+}
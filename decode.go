@@ -10,7 +10,9 @@ type Decoder interface {
 	DecodeMsgpack(r *Reader) error
 }
 
-// Decode decodes the MessagePack encoding provided by r into v.
+// Decode decodes the MessagePack encoding provided by r into v. Decode may
+// read more data from r than necessary for decoding v, which is discarded
+// afterwards. Use a StreamDecoder to decode a sequence of values.
 func Decode(r io.Reader, v Decoder) error {
 	reader := NewReader(r)
 	err := v.DecodeMsgpack(reader)
@@ -22,3 +24,20 @@ func Decode(r io.Reader, v Decoder) error {
 func Unmarshal(p []byte, v Decoder) error {
 	return v.DecodeMsgpack(NewReaderBytes(p))
 }
+
+// DecodeValue decodes the MessagePack encoding provided by r into the value
+// pointed to by v. Unlike Decode, v can be a pointer to any type. See
+// Reader.ReadValue for details.
+func DecodeValue(r io.Reader, v interface{}) error {
+	reader := NewReader(r)
+	err := reader.ReadValue(v)
+	releaseReader(reader)
+	return err
+}
+
+// UnmarshalValue unmarshals the MessagePack encoding provided by data into the
+// value pointed to by v. Unlike Unmarshal, v can be a pointer to any type. See
+// Reader.ReadValue for details.
+func UnmarshalValue(p []byte, v interface{}) error {
+	return NewReaderBytes(p).ReadValue(v)
+}
@@ -0,0 +1,189 @@
+package msgpack
+
+import (
+	"bytes"
+	"math"
+	"strings"
+)
+
+// ChangeKind specifies the kind of a change between two documents.
+type ChangeKind int
+
+// All change kinds.
+const (
+	ChangeAdded    ChangeKind = iota // value only exists in the new document
+	ChangeRemoved                    // value only exists in the old document
+	ChangeModified                   // value differs between both documents
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	default:
+		return "invalid"
+	}
+}
+
+// Change describes a single difference between two documents as reported
+// by Diff.
+type Change struct {
+	Kind ChangeKind
+	Path Path
+	Old  Value // nil for added values
+	New  Value // nil for removed values
+}
+
+// String returns a single line describing the change, e.g.
+// "~ /users/1/age: 27 -> 28".
+func (c Change) String() string {
+	switch c.Kind {
+	case ChangeAdded:
+		return "+ " + c.Path.String() + ": " + formatValue(c.New)
+	case ChangeRemoved:
+		return "- " + c.Path.String() + ": " + formatValue(c.Old)
+	default:
+		return "~ " + c.Path.String() + ": " + formatValue(c.Old) + " -> " + formatValue(c.New)
+	}
+}
+
+// FormatDiff returns a human-readable report of changes with one line per
+// change. See Change.String for details.
+func FormatDiff(changes []Change) string {
+	var sb strings.Builder
+	for _, c := range changes {
+		sb.WriteString(c.String())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// Diff compares the MessagePack encoded documents a and b and returns the
+// changes which turn a into b.
+//
+// Arrays are compared element by element, where surplus elements are
+// reported as added or removed. Maps are compared entry by entry, regardless
+// of their order. All other values are compared semantically, i.e. numbers
+// are equal if they represent the same value, no matter if they are encoded
+// as signed, unsigned or floating-point values of any width. If values of
+// different types are found at the same location, they are reported as
+// modified.
+//
+// Map keys become string or int path elements, if they are strings or
+// integers. All other keys are represented by their JSON rendering.
+//
+// If a document is nested deeper than 10000 levels, a LimitError is returned.
+func Diff(a, b []byte) ([]Change, error) {
+	var va, vb Value
+	if err := unmarshalValue(a, &va); err != nil {
+		return nil, err
+	}
+	if err := unmarshalValue(b, &vb); err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	diffValues(&changes, Path{}, va, vb)
+	return changes, nil
+}
+
+func diffValues(changes *[]Change, path Path, a, b Value) {
+	switch {
+	case a.typ == Array && b.typ == Array:
+		diffArrays(changes, path, a.arr, b.arr)
+	case a.typ == Map && b.typ == Map:
+		diffMaps(changes, path, a.kvs, b.kvs)
+	case !equalValues(a, b):
+		*changes = append(*changes, Change{Kind: ChangeModified, Path: path, Old: a, New: b})
+	}
+}
+
+func diffArrays(changes *[]Change, path Path, a, b []Value) {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		p := appendPath(path, i)
+		switch {
+		case i >= len(b):
+			*changes = append(*changes, Change{Kind: ChangeRemoved, Path: p, Old: a[i]})
+		case i >= len(a):
+			*changes = append(*changes, Change{Kind: ChangeAdded, Path: p, New: b[i]})
+		default:
+			diffValues(changes, p, a[i], b[i])
+		}
+	}
+}
+
+func diffMaps(changes *[]Change, path Path, a, b []KeyValue) {
+	matched := make([]bool, len(b))
+	for _, kv := range a {
+		p := appendPath(path, keyPathElem(kv.Key))
+		if j := findKey(b, kv.Key, matched); j >= 0 {
+			matched[j] = true
+			diffValues(changes, p, kv.Value, b[j].Value)
+		} else {
+			*changes = append(*changes, Change{Kind: ChangeRemoved, Path: p, Old: kv.Value})
+		}
+	}
+
+	for j, kv := range b {
+		if !matched[j] {
+			p := appendPath(path, keyPathElem(kv.Key))
+			*changes = append(*changes, Change{Kind: ChangeAdded, Path: p, New: kv.Value})
+		}
+	}
+}
+
+// findKey returns the index of the first entry in kvs which is not marked
+// in skip and whose key equals key, or -1 if there is no such entry.
+func findKey(kvs []KeyValue, key Value, skip []bool) int {
+	for i, kv := range kvs {
+		if !skip[i] && equalValues(kv.Key, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// appendPath returns a copy of path with elem appended.
+func appendPath(path Path, elem interface{}) Path {
+	p := make(Path, len(path)+1)
+	copy(p, path)
+	p[len(path)] = elem
+	return p
+}
+
+// keyPathElem returns the path element which selects the map entry with
+// the given key.
+func keyPathElem(key Value) interface{} {
+	switch key.typ {
+	case String:
+		return key.str
+	case Int:
+		if i := int64(key.num); i >= 0 && i <= math.MaxInt {
+			return int(i)
+		}
+	case Uint:
+		if key.num <= math.MaxInt {
+			return int(key.num)
+		}
+	}
+	return formatValue(key)
+}
+
+// formatValue returns a compact JSON rendering of v.
+func formatValue(v Value) string {
+	data, err := Marshal(v)
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+
+	var sb strings.Builder
+	opts := JSONOptions{ExtObjects: true, NonFinite: NonFiniteString}
+	if _, err := CopyToJSONWithOptions(&sb, bytes.NewReader(data), opts); err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
@@ -0,0 +1,46 @@
+package msgpack
+
+import (
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := marshalEditValue(t, MapValue(
+		KeyValue{Key: StringValue("version"), Value: UintValue(1)},
+		KeyValue{Key: StringValue("name"), Value: StringValue("foo")},
+		KeyValue{Key: StringValue("tags"), Value: ArrayValue(StringValue("a"), StringValue("b"), StringValue("c"))},
+		KeyValue{Key: StringValue("size"), Value: FloatValue(2)},
+		KeyValue{Key: IntValue(7), Value: MapValue(KeyValue{Key: StringValue("x"), Value: IntValue(-1)})},
+	))
+	b := marshalEditValue(t, MapValue(
+		KeyValue{Key: IntValue(7), Value: MapValue(KeyValue{Key: StringValue("x"), Value: BoolValue(true)})},
+		KeyValue{Key: StringValue("size"), Value: UintValue(2)},
+		KeyValue{Key: StringValue("tags"), Value: ArrayValue(StringValue("a"), StringValue("x"))},
+		KeyValue{Key: StringValue("version"), Value: IntValue(1)},
+		KeyValue{Key: BoolValue(true), Value: NilValue()},
+	))
+
+	changes, err := Diff(a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "- /name: \"foo\"\n" +
+		"~ /tags/1: \"b\" -> \"x\"\n" +
+		"- /tags/2: \"c\"\n" +
+		"~ /7/x: -1 -> true\n" +
+		"+ /true: null\n"
+	if s := FormatDiff(changes); s != expected {
+		t.Errorf("unexpected report:\n%s", s)
+	}
+	if len(changes) != 5 || changes[0].Kind != ChangeRemoved || changes[0].Old.Str() != "foo" || !changes[0].New.IsNil() {
+		t.Errorf("unexpected changes: %v", changes)
+	}
+
+	if changes, err := Diff(a, a); err != nil || len(changes) != 0 {
+		t.Errorf("unexpected changes for equal documents: %v (error: %v)", changes, err)
+	}
+	if _, err := Diff(a, b[:len(b)-1]); err == nil {
+		t.Errorf("expected error for truncated document")
+	}
+}
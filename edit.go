@@ -0,0 +1,235 @@
+package msgpack
+
+import "strconv"
+
+// Set replaces the value at path within the MessagePack encoded document raw
+// with the encoding of v and returns the modified document. If the last
+// path element selects a missing map entry, a new entry with a string key is
+// added at the end of the map. An int element becomes its decimal
+// representation, so that "/42" adds the key "42" like "/b" adds the key
+// "b". A missing array element or a missing parent yields ErrNotFound. An
+// empty path replaces the whole document.
+//
+// Only the bytes of the value and the header of the enclosing container are
+// rewritten, all other values are copied verbatim. The header is re-encoded
+// if the number of entries or its size class changes. raw is not modified.
+func Set(raw []byte, path Path, v Encoder) ([]byte, error) {
+	if err := path.validate(); err != nil {
+		return nil, err
+	}
+	enc, err := encodeElement(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(path) == 0 {
+		return enc, nil
+	}
+
+	c, err := locateContainer(raw, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	return c.set(raw, path[len(path)-1], enc)
+}
+
+// Delete removes the value at path from the MessagePack encoded document raw
+// and returns the modified document. For map entries, both key and value are
+// removed. Subsequent array elements move up by one index. If the path does
+// not exist, ErrNotFound is returned. See Set for details on how the
+// document is rewritten.
+func Delete(raw []byte, path Path) ([]byte, error) {
+	if err := path.validate(); err != nil {
+		return nil, err
+	}
+	if len(path) == 0 {
+		return nil, errorString("cannot delete the whole document")
+	}
+
+	c, err := locateContainer(raw, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	e, err := c.find(raw, path[len(path)-1])
+	switch {
+	case err != nil:
+		return nil, err
+	case !e.found():
+		return nil, ErrNotFound
+	}
+	return c.splice(raw, c.n-1, e.entry, nil), nil
+}
+
+// Append adds the encoding of v as a new element at the end of the array at
+// path within the MessagePack encoded document raw and returns the modified
+// document. If the path does not exist, ErrNotFound is returned. See Set for
+// details on how the document is rewritten.
+func Append(raw []byte, path Path, v Encoder) ([]byte, error) {
+	if err := path.validate(); err != nil {
+		return nil, err
+	}
+	enc, err := encodeElement(v)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := locateContainer(raw, path)
+	if err != nil {
+		return nil, err
+	}
+	if c.isMap {
+		return nil, errorf("cannot append to %s value", Map)
+	}
+	return c.insert(raw, c.n, enc)
+}
+
+// encodeElement returns the encoding of v, which needs to consist of exactly
+// one value.
+func encodeElement(v Encoder) ([]byte, error) {
+	enc, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewReaderBytes(enc)
+	if err := r.Skip(); err != nil || int(r.offset) != len(enc) {
+		return nil, errorString("encoder must write exactly one value")
+	}
+	return enc, nil
+}
+
+// container specifies the location of an encoded array or map within a
+// document.
+type container struct {
+	span
+	headerLen int
+	n         int
+	isMap     bool
+}
+
+// element specifies the location of a container element within a document.
+// For map entries, entry includes the key.
+type element struct {
+	entry span
+	value span
+}
+
+func (e element) found() bool {
+	return e.entry.found()
+}
+
+// locateContainer returns the location of the array or map at path. If the
+// path does not exist or selects another type, ErrNotFound is returned.
+func locateContainer(raw []byte, path Path) (container, error) {
+	spans, err := locate(raw, []Path{path})
+	if err != nil {
+		return container{}, err
+	} else if !spans[0].found() {
+		return container{}, ErrNotFound
+	}
+
+	c := container{span: spans[0]}
+	r := NewReaderBytes(raw[c.start:c.end])
+	switch typ, _ := r.Peek(); typ {
+	case Array:
+		c.n, err = r.ReadArrayHeader()
+	case Map:
+		c.n, err = r.ReadMapHeader()
+		c.isMap = true
+	default:
+		return container{}, ErrNotFound
+	}
+	if err != nil {
+		return container{}, err
+	}
+
+	c.headerLen = int(r.offset)
+	return c, nil
+}
+
+// find returns the location of the first element of c which is selected by
+// the path element elem. If there is no such element, the returned element
+// is empty.
+func (c container) find(raw []byte, elem interface{}) (element, error) {
+	r := NewReaderBytes(raw[c.start+c.headerLen : c.end])
+	for j := 0; j < c.n; j++ {
+		var e element
+		e.entry.start = c.start + c.headerLen + int(r.offset)
+
+		match := false
+		if c.isMap {
+			key, err := readPathKey(r)
+			if err != nil {
+				return element{}, err
+			}
+			match = key.matches(elem)
+		} else {
+			idx, ok := elem.(int)
+			match = ok && idx == j
+		}
+
+		e.value.start = c.start + c.headerLen + int(r.offset)
+		if err := r.Skip(); err != nil {
+			return element{}, err
+		}
+		if match {
+			e.value.end = c.start + c.headerLen + int(r.offset)
+			e.entry.end = e.value.end
+			return e, nil
+		}
+	}
+	return element{}, nil
+}
+
+// set replaces the value of the first element of c which is selected by the
+// path element elem with enc. A missing map entry is added at the end of c
+// with a string key, where an int element becomes its decimal
+// representation.
+func (c container) set(raw []byte, elem interface{}, enc []byte) ([]byte, error) {
+	e, err := c.find(raw, elem)
+	switch {
+	case err != nil:
+		return nil, err
+	case e.found():
+		return c.splice(raw, c.n, e.value, enc), nil
+	case !c.isMap:
+		return nil, ErrNotFound
+	}
+
+	w := newAppendWriter(nil)
+	switch elem := elem.(type) {
+	case string:
+		w.WriteString(elem)
+	case int:
+		w.WriteString(strconv.Itoa(elem))
+	}
+	entry := append(w.buf, enc...)
+	return c.splice(raw, c.n+1, span{start: c.end, end: c.end}, entry), nil
+}
+
+// insert inserts enc as the i-th element of the array c, where 0 <= i <= c.n.
+func (c container) insert(raw []byte, i int, enc []byte) ([]byte, error) {
+	pos := c.end
+	if i < c.n {
+		e, err := c.find(raw, i)
+		if err != nil {
+			return nil, err
+		}
+		pos = e.entry.start
+	}
+	return c.splice(raw, c.n+1, span{start: pos, end: pos}, enc), nil
+}
+
+// splice returns a copy of raw, where the bytes in sp are replaced with p
+// and the header of c is rewritten to hold n elements.
+func (c container) splice(raw []byte, n int, sp span, p []byte) []byte {
+	w := newAppendWriter(make([]byte, 0, len(raw)+len(p)-(sp.end-sp.start)+8))
+	w.buf = append(w.buf, raw[:c.start]...)
+	if c.isMap {
+		w.WriteMapHeader(n)
+	} else {
+		w.WriteArrayHeader(n)
+	}
+	w.buf = append(w.buf, raw[c.start+c.headerLen:sp.start]...)
+	w.buf = append(w.buf, p...)
+	return append(w.buf, raw[sp.end:]...)
+}
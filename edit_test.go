@@ -0,0 +1,227 @@
+package msgpack
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	doc := editDocument(t)
+
+	tests := []struct {
+		path     Path
+		value    Value
+		expected Value
+	}{
+		{
+			path:  Path{"version"},
+			value: UintValue(300),
+			expected: MapValue(
+				KeyValue{Key: StringValue("version"), Value: UintValue(300)},
+				KeyValue{Key: StringValue("tags"), Value: ArrayValue(StringValue("a"), StringValue("b"))},
+				KeyValue{Key: IntValue(7), Value: StringValue("seven")},
+			),
+		},
+		{
+			path:  Path{"tags", 1},
+			value: NilValue(),
+			expected: MapValue(
+				KeyValue{Key: StringValue("version"), Value: UintValue(1)},
+				KeyValue{Key: StringValue("tags"), Value: ArrayValue(StringValue("a"), NilValue())},
+				KeyValue{Key: IntValue(7), Value: StringValue("seven")},
+			),
+		},
+		{
+			path:  Path{7},
+			value: BoolValue(true),
+			expected: MapValue(
+				KeyValue{Key: StringValue("version"), Value: UintValue(1)},
+				KeyValue{Key: StringValue("tags"), Value: ArrayValue(StringValue("a"), StringValue("b"))},
+				KeyValue{Key: IntValue(7), Value: BoolValue(true)},
+			),
+		},
+		{
+			path:  Path{"name"},
+			value: StringValue("foo"),
+			expected: MapValue(
+				KeyValue{Key: StringValue("version"), Value: UintValue(1)},
+				KeyValue{Key: StringValue("tags"), Value: ArrayValue(StringValue("a"), StringValue("b"))},
+				KeyValue{Key: IntValue(7), Value: StringValue("seven")},
+				KeyValue{Key: StringValue("name"), Value: StringValue("foo")},
+			),
+		},
+		{
+			path:  Path{42},
+			value: NilValue(),
+			expected: MapValue(
+				KeyValue{Key: StringValue("version"), Value: UintValue(1)},
+				KeyValue{Key: StringValue("tags"), Value: ArrayValue(StringValue("a"), StringValue("b"))},
+				KeyValue{Key: IntValue(7), Value: StringValue("seven")},
+				KeyValue{Key: StringValue("42"), Value: NilValue()},
+			),
+		},
+		{
+			path:     Path{},
+			value:    UintValue(1),
+			expected: UintValue(1),
+		},
+	}
+
+	for _, test := range tests {
+		res, err := Set(doc, test.path, test.value)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", test.path, err)
+		} else if expected := marshalEditValue(t, test.expected); !bytes.Equal(res, expected) {
+			t.Errorf("unexpected document for %s: %x", test.path, res)
+		}
+	}
+
+	if _, err := Set(doc, Path{"tags", 2}, NilValue()); err != ErrNotFound {
+		t.Errorf("unexpected error for missing index: %v", err)
+	}
+	if _, err := Set(doc, Path{"missing", "x"}, NilValue()); err != ErrNotFound {
+		t.Errorf("unexpected error for missing parent: %v", err)
+	}
+	if path, err := ParsePath("/42"); err != nil {
+		t.Errorf("unexpected path error: %v", err)
+	} else if res, err := Set(doc, path, NilValue()); err != nil {
+		t.Errorf("unexpected error for %s: %v", path, err)
+	} else if expected, _ := Set(doc, Path{"42"}, NilValue()); !bytes.Equal(res, expected) {
+		t.Errorf("unexpected document for %s: %x", path, res)
+	}
+	if _, err := Set(doc, Path{"version"}, Raw{}); err == nil || err.Error() != "encoder must write exactly one value" {
+		t.Errorf("unexpected error for empty encoding: %v", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	doc := editDocument(t)
+
+	tests := []struct {
+		path     Path
+		expected Value
+	}{
+		{
+			path: Path{"version"},
+			expected: MapValue(
+				KeyValue{Key: StringValue("tags"), Value: ArrayValue(StringValue("a"), StringValue("b"))},
+				KeyValue{Key: IntValue(7), Value: StringValue("seven")},
+			),
+		},
+		{
+			path: Path{"tags", 0},
+			expected: MapValue(
+				KeyValue{Key: StringValue("version"), Value: UintValue(1)},
+				KeyValue{Key: StringValue("tags"), Value: ArrayValue(StringValue("b"))},
+				KeyValue{Key: IntValue(7), Value: StringValue("seven")},
+			),
+		},
+		{
+			path: Path{7},
+			expected: MapValue(
+				KeyValue{Key: StringValue("version"), Value: UintValue(1)},
+				KeyValue{Key: StringValue("tags"), Value: ArrayValue(StringValue("a"), StringValue("b"))},
+			),
+		},
+	}
+
+	for _, test := range tests {
+		res, err := Delete(doc, test.path)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", test.path, err)
+		} else if expected := marshalEditValue(t, test.expected); !bytes.Equal(res, expected) {
+			t.Errorf("unexpected document for %s: %x", test.path, res)
+		}
+	}
+
+	if _, err := Delete(doc, Path{"missing"}); err != ErrNotFound {
+		t.Errorf("unexpected error for missing key: %v", err)
+	}
+	if _, err := Delete(doc, Path{}); err == nil {
+		t.Errorf("expected error for empty path")
+	}
+}
+
+func TestAppend(t *testing.T) {
+	doc := editDocument(t)
+
+	res, err := Append(doc, Path{"tags"}, StringValue("c"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := marshalEditValue(t, MapValue(
+		KeyValue{Key: StringValue("version"), Value: UintValue(1)},
+		KeyValue{Key: StringValue("tags"), Value: ArrayValue(StringValue("a"), StringValue("b"), StringValue("c"))},
+		KeyValue{Key: IntValue(7), Value: StringValue("seven")},
+	))
+	if !bytes.Equal(res, expected) {
+		t.Errorf("unexpected document: %x", res)
+	}
+
+	if _, err := Append(doc, Path{}, NilValue()); err == nil || err.Error() != "cannot append to map value" {
+		t.Errorf("unexpected error for map: %v", err)
+	}
+	if _, err := Append(doc, Path{"version"}, NilValue()); err != ErrNotFound {
+		t.Errorf("unexpected error for uint: %v", err)
+	}
+}
+
+func TestEditHeaderSize(t *testing.T) {
+	elems := make([]Value, 15)
+	entries := make([]KeyValue, 15)
+	for i := range elems {
+		elems[i] = UintValue(uint64(i))
+		entries[i] = KeyValue{Key: StringValue(strconv.Itoa(i)), Value: NilValue()}
+	}
+	arr := marshalEditValue(t, ArrayValue(elems...))
+	m := marshalEditValue(t, MapValue(entries...))
+
+	// 15 -> 16 elements: fixarray becomes array16
+	grown, err := Append(arr, Path{}, UintValue(15))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := marshalEditValue(t, ArrayValue(append(elems, UintValue(15))...)); !bytes.Equal(grown, expected) {
+		t.Errorf("unexpected grown array: %x", grown)
+	} else if grown[0] != tagArray16 {
+		t.Errorf("unexpected array header: %x", grown[:3])
+	}
+
+	// 16 -> 15 elements: array16 becomes fixarray
+	shrunk, err := Delete(grown, Path{15})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(shrunk, arr) {
+		t.Errorf("unexpected shrunk array: %x", shrunk)
+	}
+
+	// 15 -> 16 entries: fixmap becomes map16
+	grown, err = Set(m, Path{"15"}, NilValue())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries = append(entries, KeyValue{Key: StringValue("15"), Value: NilValue()})
+	if expected := marshalEditValue(t, MapValue(entries...)); !bytes.Equal(grown, expected) {
+		t.Errorf("unexpected grown map: %x", grown)
+	} else if grown[0] != tagMap16 {
+		t.Errorf("unexpected map header: %x", grown[:3])
+	}
+}
+
+func editDocument(t *testing.T) []byte {
+	return marshalEditValue(t, MapValue(
+		KeyValue{Key: StringValue("version"), Value: UintValue(1)},
+		KeyValue{Key: StringValue("tags"), Value: ArrayValue(StringValue("a"), StringValue("b"))},
+		KeyValue{Key: IntValue(7), Value: StringValue("seven")},
+	))
+}
+
+func marshalEditValue(t *testing.T, v Value) []byte {
+	data, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return data
+}
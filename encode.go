@@ -12,7 +12,13 @@ type Encoder interface {
 
 // Encode encodes v into the MessagePack encoding and writes it to w.
 func Encode(w io.Writer, v Encoder) error {
-	return v.EncodeMsgpack(NewWriter(w))
+	writer := NewWriter(w)
+	err := v.EncodeMsgpack(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	releaseWriter(writer)
+	return err
 }
 
 // Marshal encodes v into the MessagePack encoding and returns its encoding.
@@ -22,16 +28,39 @@ func Marshal(v Encoder) ([]byte, error) {
 
 // AppendMarshal encodes v into the MessagePack encoding and appends it to buf.
 func AppendMarshal(v Encoder, buf []byte) ([]byte, error) {
-	appender := &byteAppender{buf: buf}
-	err := Encode(appender, v)
-	return appender.buf, err
+	writer := newAppendWriter(buf)
+	err := v.EncodeMsgpack(writer)
+	return writer.buf, err
 }
 
-type byteAppender struct {
-	buf []byte
+// EncodeValue encodes v into the MessagePack encoding and writes it to w.
+// Unlike Encode, v can be of any type. See Writer.WriteValue for details.
+func EncodeValue(w io.Writer, v interface{}) error {
+	writer := NewWriter(w)
+	err := writer.WriteValue(v)
+	if err == nil {
+		err = writer.Flush()
+	}
+	releaseWriter(writer)
+	return err
 }
 
-func (a *byteAppender) Write(p []byte) (int, error) {
-	a.buf = append(a.buf, p...)
-	return len(p), nil
+// MarshalValue encodes v into the MessagePack encoding and returns its encoding.
+// Unlike Marshal, v can be of any type. See Writer.WriteValue for details.
+func MarshalValue(v interface{}) ([]byte, error) {
+	return AppendMarshalValue(v, nil)
+}
+
+// AppendMarshalValue encodes v into the MessagePack encoding and appends it to buf.
+// Unlike AppendMarshal, v can be of any type. See Writer.WriteValue for details.
+func AppendMarshalValue(v interface{}, buf []byte) ([]byte, error) {
+	writer := newAppendWriter(buf)
+	err := writer.WriteValue(v)
+	return writer.buf, err
+}
+
+// newAppendWriter creates a writer which appends all data to buf. It never
+// needs to be flushed.
+func newAppendWriter(buf []byte) *Writer {
+	return &Writer{buf: buf}
 }
@@ -0,0 +1,247 @@
+package msgpack
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"math"
+	"sort"
+)
+
+// EqualOptions defines the options for comparing and hashing values with
+// EqualWithOptions and HashWithOptions.
+type EqualOptions struct {
+	// IgnoreMapOrder compares maps as sets of entries, regardless of the
+	// order of their entries.
+	IgnoreMapOrder bool
+}
+
+// Equal reports whether the MessagePack encoded values a and b are
+// semantically equal, i.e. whether they represent the same value regardless
+// of the encoding chosen by the encoder. Numbers are equal if they represent
+// the same value, no matter if they are encoded as signed, unsigned or
+// floating-point values of any width. NaN values are considered equal.
+// Strings, binary data, arrays and maps are equal regardless of the width of
+// their headers. The order of map entries matters, see EqualWithOptions to
+// change this. If a value is nested deeper than 10000 levels, a LimitError
+// is returned.
+func Equal(a, b []byte) (bool, error) {
+	return EqualWithOptions(a, b, EqualOptions{})
+}
+
+// EqualWithOptions reports whether the MessagePack encoded values a and b
+// are semantically equal with respect to opts. See Equal for details.
+func EqualWithOptions(a, b []byte, opts EqualOptions) (bool, error) {
+	var va, vb Value
+	if err := unmarshalValue(a, &va); err != nil {
+		return false, err
+	}
+	if err := unmarshalValue(b, &vb); err != nil {
+		return false, err
+	}
+	return opts.equal(va, vb), nil
+}
+
+// Hash writes a canonical representation of the MessagePack encoded value
+// raw to h. Values which are equal according to Equal produce the same
+// representation, so the sum of h can be used to deduplicate or cache
+// values by their content. Like Equal, it returns a LimitError if raw is
+// nested deeper than 10000 levels.
+func Hash(h hash.Hash, raw []byte) error {
+	return HashWithOptions(h, raw, EqualOptions{})
+}
+
+// HashWithOptions writes a canonical representation of the MessagePack
+// encoded value raw to h. Values which are equal according to
+// EqualWithOptions with the same options produce the same representation.
+func HashWithOptions(h hash.Hash, raw []byte, opts EqualOptions) error {
+	var v Value
+	if err := unmarshalValue(raw, &v); err != nil {
+		return err
+	}
+
+	vh := valueHasher{h: h, opts: opts}
+	vh.hash(v)
+	return nil
+}
+
+// equalValues reports whether a and b are semantically equal, where the
+// order of map entries matters.
+func equalValues(a, b Value) bool {
+	return EqualOptions{}.equal(a, b)
+}
+
+func (o EqualOptions) equal(a, b Value) bool {
+	if a.isNumber() && b.isNumber() {
+		return compareNumbers(a, b) == 0
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a.Type() {
+	case Nil:
+		return true
+	case Bool:
+		return a.num == b.num
+	case String:
+		return a.str == b.str
+	case Bytes:
+		return bytes.Equal(a.bytes, b.bytes)
+	case Time:
+		return a.tm.Equal(b.tm)
+	case Ext:
+		return a.ext == b.ext && bytes.Equal(a.bytes, b.bytes)
+	case Array:
+		if len(a.arr) != len(b.arr) {
+			return false
+		}
+		for i := range a.arr {
+			if !o.equal(a.arr[i], b.arr[i]) {
+				return false
+			}
+		}
+		return true
+	case Map:
+		if len(a.kvs) != len(b.kvs) {
+			return false
+		} else if o.IgnoreMapOrder {
+			return o.equalUnordered(a.kvs, b.kvs)
+		}
+		for i := range a.kvs {
+			if !o.equal(a.kvs[i].Key, b.kvs[i].Key) || !o.equal(a.kvs[i].Value, b.kvs[i].Value) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// equalUnordered reports whether every entry of a has an equal counterpart
+// in b, where a and b have the same length.
+func (o EqualOptions) equalUnordered(a, b []KeyValue) bool {
+	matched := make([]bool, len(b))
+next:
+	for _, kva := range a {
+		for j, kvb := range b {
+			if !matched[j] && o.equal(kva.Key, kvb.Key) && o.equal(kva.Value, kvb.Value) {
+				matched[j] = true
+				continue next
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// valueHasher writes the canonical representation of values to a hash.
+// Every value is written as a single type byte followed by its payload.
+type valueHasher struct {
+	h    hash.Hash
+	opts EqualOptions
+	buf  [9]byte
+}
+
+func (vh *valueHasher) hash(v Value) {
+	switch v.Type() {
+	case Nil:
+		vh.writeByte('n')
+	case Bool:
+		if v.num != 0 {
+			vh.writeByte('t')
+		} else {
+			vh.writeByte('f')
+		}
+	case Int, Uint, Float:
+		vh.hashNumber(v)
+	case String:
+		vh.writeUint('s', uint64(len(v.str)))
+		vh.h.Write([]byte(v.str))
+	case Bytes:
+		vh.writeUint('b', uint64(len(v.bytes)))
+		vh.h.Write(v.bytes)
+	case Time:
+		vh.writeUint('T', uint64(v.tm.Unix()))
+		vh.writeUint('N', uint64(v.tm.Nanosecond()))
+	case Ext:
+		vh.writeUint('e', uint64(uint8(v.ext)))
+		vh.writeUint('x', uint64(len(v.bytes)))
+		vh.h.Write(v.bytes)
+	case Array:
+		vh.writeUint('a', uint64(len(v.arr)))
+		for _, elem := range v.arr {
+			vh.hash(elem)
+		}
+	case Map:
+		vh.writeUint('m', uint64(len(v.kvs)))
+		if vh.opts.IgnoreMapOrder {
+			vh.hashUnordered(v.kvs)
+			return
+		}
+		for _, kv := range v.kvs {
+			vh.hash(kv.Key)
+			vh.hash(kv.Value)
+		}
+	}
+}
+
+// hashNumber writes integral numbers which fit into an int64 or uint64 as
+// integers and all other numbers as float64 values.
+func (vh *valueHasher) hashNumber(v Value) {
+	switch v.typ {
+	case Int:
+		if i := int64(v.num); i < 0 {
+			vh.writeUint('i', v.num)
+		} else {
+			vh.writeUint('u', v.num)
+		}
+		return
+	case Uint:
+		vh.writeUint('u', v.num)
+		return
+	}
+
+	switch f := v.Float(); {
+	case math.IsNaN(f):
+		vh.writeUint('d', math.Float64bits(math.NaN()))
+	case f != math.Trunc(f) || f < math.MinInt64 || f >= 1<<64:
+		vh.writeUint('d', math.Float64bits(f))
+	case f < 0:
+		vh.writeUint('i', uint64(int64(f)))
+	default:
+		vh.writeUint('u', uint64(f))
+	}
+}
+
+// hashUnordered writes the entries of a map in an order-independent way.
+// Each entry is hashed separately and the sorted digests are written.
+func (vh *valueHasher) hashUnordered(kvs []KeyValue) {
+	digests := make([][]byte, len(kvs))
+	for i, kv := range kvs {
+		sub := valueHasher{h: sha256.New(), opts: vh.opts}
+		sub.hash(kv.Key)
+		sub.hash(kv.Value)
+		digests[i] = sub.h.Sum(nil)
+	}
+
+	sort.Slice(digests, func(i, j int) bool {
+		return bytes.Compare(digests[i], digests[j]) < 0
+	})
+	for _, d := range digests {
+		vh.h.Write(d)
+	}
+}
+
+func (vh *valueHasher) writeByte(b byte) {
+	vh.buf[0] = b
+	vh.h.Write(vh.buf[:1])
+}
+
+func (vh *valueHasher) writeUint(b byte, u uint64) {
+	vh.buf[0] = b
+	binary.BigEndian.PutUint64(vh.buf[1:], u)
+	vh.h.Write(vh.buf[:])
+}
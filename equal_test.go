@@ -0,0 +1,144 @@
+package msgpack
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"math"
+	"testing"
+)
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b      []byte
+		equal     bool
+		unordered bool // equal if map order is ignored
+	}{
+		{
+			a:     []byte{posFixintTag(5)},
+			b:     []byte{tagInt64, 0, 0, 0, 0, 0, 0, 0, 5},
+			equal: true,
+		},
+		{
+			a:     []byte{tagFloat32, 0x40, 0xa0, 0x00, 0x00},
+			b:     []byte{tagUint16, 0x00, 0x05},
+			equal: true,
+		},
+		{
+			a:     []byte{fixstrTag(1), 'a'},
+			b:     []byte{tagStr8, 0x01, 'a'},
+			equal: true,
+		},
+		{
+			a: []byte{fixstrTag(1), 'a'},
+			b: []byte{tagBin8, 0x01, 'a'},
+		},
+		{
+			a:     []byte{fixarrayTag(2), tagNil, tagTrue},
+			b:     []byte{tagArray16, 0x00, 0x02, tagNil, tagTrue},
+			equal: true,
+		},
+		{
+			a: []byte{fixarrayTag(2), tagNil, tagTrue},
+			b: []byte{fixarrayTag(2), tagTrue, tagNil},
+		},
+		{
+			a:     []byte{fixmapTag(1), fixstrTag(1), 'a', posFixintTag(1)},
+			b:     []byte{tagMap32, 0x00, 0x00, 0x00, 0x01, tagStr8, 0x01, 'a', tagInt8, 0x01},
+			equal: true,
+		},
+		{
+			a:         []byte{fixmapTag(2), fixstrTag(1), 'a', posFixintTag(1), fixstrTag(1), 'b', posFixintTag(2)},
+			b:         []byte{fixmapTag(2), fixstrTag(1), 'b', posFixintTag(2), fixstrTag(1), 'a', posFixintTag(1)},
+			unordered: true,
+		},
+		{
+			a: []byte{fixmapTag(2), fixstrTag(1), 'a', posFixintTag(1), fixstrTag(1), 'b', posFixintTag(2)},
+			b: []byte{fixmapTag(2), fixstrTag(1), 'b', posFixintTag(1), fixstrTag(1), 'a', posFixintTag(2)},
+		},
+		{
+			a:     []byte{tagFixExt4, 0xff, 0x59, 0xca, 0x52, 0xa7},
+			b:     []byte{tagFixExt8, 0xff, 0x00, 0x00, 0x00, 0x00, 0x59, 0xca, 0x52, 0xa7},
+			equal: true,
+		},
+		{
+			a: []byte{tagFixExt1, 0x01, 0x00},
+			b: []byte{tagFixExt1, 0x02, 0x00},
+		},
+	}
+
+	for _, test := range tests {
+		for _, unordered := range []bool{false, true} {
+			opts := EqualOptions{IgnoreMapOrder: unordered}
+			expected := test.equal || (unordered && test.unordered)
+
+			eq, err := EqualWithOptions(test.a, test.b, opts)
+			if err != nil {
+				t.Errorf("unexpected error for %x and %x: %v", test.a, test.b, err)
+			} else if eq != expected {
+				t.Errorf("unexpected result for %x and %x (%+v): %t", test.a, test.b, opts, eq)
+			}
+
+			ha, hb := sha256.New(), sha256.New()
+			if err := HashWithOptions(ha, test.a, opts); err != nil {
+				t.Errorf("unexpected hash error for %x: %v", test.a, err)
+			}
+			if err := HashWithOptions(hb, test.b, opts); err != nil {
+				t.Errorf("unexpected hash error for %x: %v", test.b, err)
+			}
+			if hashEq := bytes.Equal(ha.Sum(nil), hb.Sum(nil)); hashEq != expected {
+				t.Errorf("unexpected hash equality for %x and %x (%+v): %t", test.a, test.b, opts, hashEq)
+			}
+		}
+	}
+
+	if _, err := Equal([]byte{fixarrayTag(1)}, []byte{tagNil}); err == nil {
+		t.Errorf("expected error for truncated value")
+	}
+	if err := Hash(sha256.New(), []byte{tagStr8}); err == nil {
+		t.Errorf("expected hash error for truncated value")
+	}
+}
+
+func TestEqualNumbers(t *testing.T) {
+	tests := []struct {
+		a, b  Value
+		equal bool
+	}{
+		{a: UintValue(5), b: IntValue(5), equal: true},
+		{a: UintValue(5), b: FloatValue(5), equal: true},
+		{a: Float32Value(1.5), b: FloatValue(1.5), equal: true},
+		{a: IntValue(-1), b: UintValue(math.MaxUint64), equal: false},
+		{a: UintValue(math.MaxUint64), b: FloatValue(math.MaxUint64), equal: false},
+		{a: IntValue(math.MaxInt64), b: UintValue(math.MaxInt64), equal: true},
+		{a: IntValue(math.MinInt64), b: FloatValue(math.MinInt64), equal: true},
+		{a: IntValue(1<<53 + 1), b: FloatValue(1 << 53), equal: false},
+		{a: FloatValue(math.NaN()), b: FloatValue(math.NaN()), equal: true},
+		{a: FloatValue(math.NaN()), b: UintValue(0), equal: false},
+		{a: FloatValue(math.Copysign(0, -1)), b: UintValue(0), equal: true},
+		{a: FloatValue(0.5), b: Float32Value(0.5), equal: true},
+		{a: FloatValue(math.Inf(1)), b: UintValue(math.MaxUint64), equal: false},
+		{a: UintValue(1), b: StringValue("1"), equal: false},
+	}
+
+	for _, test := range tests {
+		if eq := equalValues(test.a, test.b); eq != test.equal {
+			t.Errorf("unexpected result for %s == %s: %t", formatValue(test.a), formatValue(test.b), eq)
+		}
+		if eq := equalValues(test.b, test.a); eq != test.equal {
+			t.Errorf("unexpected result for %s == %s: %t", formatValue(test.b), formatValue(test.a), eq)
+		}
+
+		ha, hb := sha256.New(), sha256.New()
+		hashValue(ha, test.a)
+		hashValue(hb, test.b)
+		if eq := bytes.Equal(ha.Sum(nil), hb.Sum(nil)); eq != test.equal {
+			t.Errorf("unexpected hash equality for %s and %s: %t", formatValue(test.a), formatValue(test.b), eq)
+		}
+	}
+}
+
+func hashValue(h hash.Hash, v Value) {
+	vh := valueHasher{h: h}
+	vh.hash(v)
+}
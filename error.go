@@ -1,6 +1,9 @@
 package msgpack
 
-import "fmt"
+import (
+	"fmt"
+	"reflect"
+)
 
 const errLengthLimitExceeded = errorString("length limit exceeded")
 
@@ -25,6 +28,19 @@ func (e TypeError) Error() string {
 	return "unexpected type: " + string(e.Actual) + " (expected " + string(e.Expected) + ")"
 }
 
+// LimitError specifies an error for input which exceeds one of the limits
+// defined in ReaderOptions.
+type LimitError struct {
+	Limit string // name of the exceeded limit, e.g. "MaxDepth"
+	Value int    // actual value found in the input
+	Max   int    // configured maximum
+}
+
+// Error returns the error message of the error.
+func (e LimitError) Error() string {
+	return fmt.Sprintf("%s exceeded: %d > %d", e.Limit, e.Value, e.Max)
+}
+
 type intOverflowError struct{}
 
 func (e intOverflowError) Error() string {
@@ -48,3 +64,11 @@ func newInvalidExtensionError(typ int8) error {
 func (e invalidExtensionError) Error() string {
 	return fmt.Sprintf("invalid extension type %d", e.typ)
 }
+
+type unsupportedTypeError struct {
+	typ reflect.Type
+}
+
+func (e unsupportedTypeError) Error() string {
+	return "unsupported type " + e.typ.String()
+}
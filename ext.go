@@ -0,0 +1,89 @@
+package msgpack
+
+import (
+	"encoding"
+	"fmt"
+	"sync"
+)
+
+var extRegistry struct {
+	sync.RWMutex
+	factories map[int8]func() encoding.BinaryUnmarshaler
+}
+
+// RegisterExt registers a factory for the extension type id. Whenever an
+// extension value of this type is decoded dynamically (e.g. into an empty
+// interface value or by CopyToJSON), a new value is created by the factory
+// and its UnmarshalBinary method is called with the extension data.
+//
+// RegisterExt panics if factory is nil, if id is reserved for timestamps or
+// if id has already been registered. It is meant to be called from init
+// functions.
+func RegisterExt(id int8, factory func() encoding.BinaryUnmarshaler) {
+	if factory == nil {
+		panic("msgpack: nil extension factory")
+	}
+	if id == extTime {
+		panic("msgpack: extension type -1 is reserved for timestamps")
+	}
+
+	extRegistry.Lock()
+	defer extRegistry.Unlock()
+
+	if _, has := extRegistry.factories[id]; has {
+		panic(fmt.Sprintf("msgpack: extension type %d registered twice", id))
+	}
+	if extRegistry.factories == nil {
+		extRegistry.factories = make(map[int8]func() encoding.BinaryUnmarshaler)
+	}
+	extRegistry.factories[id] = factory
+}
+
+func extFactory(id int8) func() encoding.BinaryUnmarshaler {
+	extRegistry.RLock()
+	f := extRegistry.factories[id]
+	extRegistry.RUnlock()
+	return f
+}
+
+// RawExt holds an extension value whose type has not been registered with
+// RegisterExt.
+type RawExt struct {
+	Type int8
+	Data []byte
+}
+
+// EncodeMsgpack implements the Encoder interface.
+func (e RawExt) EncodeMsgpack(w *Writer) error {
+	if e.Type == extTime {
+		return newInvalidExtensionError(e.Type)
+	}
+	return w.writeExtension(e.Type, e.Data)
+}
+
+// DecodeMsgpack implements the Decoder interface.
+func (e *RawExt) DecodeMsgpack(r *Reader) (err error) {
+	e.Type, e.Data, err = r.ReadExtAny()
+	return err
+}
+
+// readExt reads the next extension value. If the extension type is
+// registered, the value created by its factory is returned. Otherwise, a
+// RawExt is returned.
+func (r *Reader) readExt() (interface{}, error) {
+	typ, data, err := r.ReadExtAny()
+	if err != nil {
+		return nil, err
+	}
+
+	f := extFactory(typ)
+	if f == nil {
+		return RawExt{Type: typ, Data: data}, nil
+	}
+
+	v := f()
+	if err := v.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
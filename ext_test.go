@@ -0,0 +1,110 @@
+package msgpack
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+const testExtType = 42
+
+func init() {
+	RegisterExt(testExtType, func() encoding.BinaryUnmarshaler { return new(testExt) })
+}
+
+type testExt struct {
+	X uint8
+	Y uint8
+}
+
+func (e *testExt) MarshalBinary() ([]byte, error) {
+	return []byte{e.X, e.Y}, nil
+}
+
+func (e *testExt) UnmarshalBinary(data []byte) error {
+	if len(data) != 2 {
+		return errorf("invalid test extension length %d", len(data))
+	}
+	e.X, e.Y = data[0], data[1]
+	return nil
+}
+
+func (e *testExt) String() string {
+	return fmt.Sprintf("(%d,%d)", e.X, e.Y)
+}
+
+func TestRegisterExtPanics(t *testing.T) {
+	factory := func() encoding.BinaryUnmarshaler { return new(testExt) }
+	tests := []struct {
+		id      int8
+		factory func() encoding.BinaryUnmarshaler
+		msg     string
+	}{
+		{id: 1, factory: nil, msg: "msgpack: nil extension factory"},
+		{id: -1, factory: factory, msg: "msgpack: extension type -1 is reserved for timestamps"},
+		{id: testExtType, factory: factory, msg: "msgpack: extension type 42 registered twice"},
+	}
+
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if msg := recover(); msg != test.msg {
+					t.Errorf("unexpected panic for id %d: %v", test.id, msg)
+				}
+			}()
+			RegisterExt(test.id, test.factory)
+		}()
+	}
+}
+
+func TestPeekExtType(t *testing.T) {
+	r := NewReaderBytes([]byte{tagFixExt1, 0xfe, ' '})
+	for i := 0; i < 2; i++ {
+		if typ, err := r.PeekExtType(); err != nil || typ != -2 {
+			t.Errorf("unexpected extension type: %d (error: %v)", typ, err)
+		}
+	}
+
+	r = NewReaderBytes([]byte{tagNil})
+	if _, err := r.PeekExtType(); err == nil || err.Error() != "unexpected type: nil (expected ext)" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestExtDynamicDecoding(t *testing.T) {
+	data, err := MarshalValue([]interface{}{
+		RawExt{Type: testExtType, Data: []byte{1, 2}},
+		RawExt{Type: -2, Data: []byte{3}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var v interface{}
+	if err := UnmarshalValue(data, &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []interface{}{
+		&testExt{X: 1, Y: 2},
+		RawExt{Type: -2, Data: []byte{3}},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("unexpected value: %#v", v)
+	}
+
+	var stringers []fmt.Stringer
+	data, _ = MarshalValue([]RawExt{{Type: testExtType, Data: []byte{1, 2}}})
+	if err := UnmarshalValue(data, &stringers); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(stringers) != 1 || stringers[0].String() != "(1,2)" {
+		t.Errorf("unexpected value: %v", stringers)
+	}
+
+	var raw RawExt
+	if err := UnmarshalValue([]byte{tagFixExt1, 0xfe, ' '}, &raw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if raw.Type != -2 || string(raw.Data) != " " {
+		t.Errorf("unexpected raw extension: %#v", raw)
+	}
+}
@@ -0,0 +1,203 @@
+package msgpack
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var fieldCache sync.Map // map[reflect.Type]*structFields
+
+// structField describes a single encodable field of a struct type.
+type structField struct {
+	name      string
+	index     []int
+	typ       reflect.Type
+	omitEmpty bool
+	depth     int
+}
+
+// structFields holds the encodable fields of a struct type in declaration
+// order together with a lookup table for decoding.
+type structFields struct {
+	list    []structField
+	byName  map[string]int
+	asArray bool
+}
+
+// cachedFields returns the encodable fields of the struct type t. The result
+// is computed once per type.
+func cachedFields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.(*structFields)
+}
+
+// typeFields collects the encodable fields of the struct type t. The fields of
+// inlined structs are collected recursively. If several fields share the same
+// name, the one with the shallowest nesting depth wins. Ties are resolved in
+// favor of the field declared first.
+func typeFields(t reflect.Type) *structFields {
+	fields := &structFields{byName: make(map[string]int)}
+
+	var candidates []structField
+	collectFields(t, nil, 0, map[reflect.Type]bool{t: true}, &candidates)
+
+	// find the dominant field for each name
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return candidates[order[i]].depth < candidates[order[j]].depth
+	})
+	dominant := make(map[string]int, len(candidates))
+	for _, i := range order {
+		if _, ok := dominant[candidates[i].name]; !ok {
+			dominant[candidates[i].name] = i
+		}
+	}
+
+	for i, f := range candidates {
+		if dominant[f.name] != i {
+			continue
+		}
+		fields.byName[f.name] = len(fields.list)
+		fields.list = append(fields.list, f)
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Name != "_" {
+			continue
+		}
+		if _, opts := parseTag(sf.Tag.Get("msgpack")); opts.contains("array") {
+			fields.asArray = true
+		}
+	}
+	return fields
+}
+
+func collectFields(t reflect.Type, index []int, depth int, visited map[reflect.Type]bool, fields *[]structField) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("msgpack")
+		if tag == "-" || sf.Name == "_" {
+			continue
+		}
+
+		name, opts := parseTag(tag)
+		if opts.contains("inline") {
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			// Pointers to unexported struct types cannot be allocated
+			// while decoding, so they are not inlined.
+			inlinable := ft.Kind() == reflect.Struct && (sf.IsExported() || sf.Type.Kind() != reflect.Pointer)
+			if inlinable && !visited[ft] {
+				visited[ft] = true
+				collectFields(ft, appendIndex(index, i), depth+1, visited, fields)
+				delete(visited, ft)
+				continue
+			}
+		}
+
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		*fields = append(*fields, structField{
+			name:      name,
+			index:     appendIndex(index, i),
+			typ:       sf.Type,
+			omitEmpty: opts.contains("omitempty"),
+			depth:     depth,
+		})
+	}
+}
+
+func appendIndex(index []int, i int) []int {
+	res := make([]int, len(index)+1)
+	copy(res, index)
+	res[len(index)] = i
+	return res
+}
+
+// fieldByIndex returns the nested field of v specified by index. If a nil
+// pointer to an inlined struct is encountered, false is returned.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// fieldByIndexAlloc returns the nested field of v specified by index. Nil
+// pointers to inlined structs are allocated on the way.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.IsZero()
+		}
+	}
+	return false
+}
+
+// tagOptions holds the comma-separated options of a struct tag.
+type tagOptions string
+
+// parseTag splits a struct tag into its name and its options.
+func parseTag(tag string) (string, tagOptions) {
+	name, opts, _ := strings.Cut(tag, ",")
+	return name, tagOptions(opts)
+}
+
+func (o tagOptions) contains(name string) bool {
+	s := string(o)
+	for s != "" {
+		var opt string
+		opt, s, _ = strings.Cut(s, ",")
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package msgpack
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type fieldsBase struct {
+	ID   int
+	Name string
+}
+
+type fieldsEmbedded struct {
+	fieldsBase   `msgpack:",inline"`
+	*FieldsExtra `msgpack:",inline"`
+
+	Name    string `msgpack:"name"`
+	Skipped int    `msgpack:"-"`
+	Dash    int    `msgpack:"-,"`
+	Opt     []int  `msgpack:",omitempty"`
+}
+
+type FieldsExtra struct {
+	Extra bool
+}
+
+type fieldsPoint struct {
+	_ struct{} `msgpack:",array"`
+	X int
+	Y int
+}
+
+type fieldsPointV2 struct {
+	_     struct{} `msgpack:",array"`
+	X     int
+	Y     int
+	Label string
+}
+
+func TestTypeFields(t *testing.T) {
+	fields := typeFields(reflect.TypeOf(fieldsEmbedded{}))
+
+	var names []string
+	for _, f := range fields.list {
+		names = append(names, f.name)
+	}
+	expected := []string{"ID", "Name", "Extra", "name", "-", "Opt"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("unexpected field names: %v", names)
+	}
+	if fields.asArray {
+		t.Error("unexpected array layout")
+	}
+	if !typeFields(reflect.TypeOf(fieldsPoint{})).asArray {
+		t.Error("expected array layout")
+	}
+}
+
+func TestParseTag(t *testing.T) {
+	name, opts := parseTag("name,omitempty,inline")
+	if name != "name" {
+		t.Errorf("unexpected name: %q", name)
+	}
+	if !opts.contains("omitempty") || !opts.contains("inline") || opts.contains("array") {
+		t.Errorf("unexpected options: %q", opts)
+	}
+}
+
+func TestStructTags(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		data  []byte
+	}{
+		{
+			value: fieldsEmbedded{fieldsBase: fieldsBase{ID: 1}, Name: "n", Skipped: 7},
+			data: []byte{
+				fixmapTag(4),
+				fixstrTag(2), 'I', 'D', posFixintTag(1),
+				fixstrTag(4), 'N', 'a', 'm', 'e', fixstrTag(0),
+				fixstrTag(4), 'n', 'a', 'm', 'e', fixstrTag(1), 'n',
+				fixstrTag(1), '-', posFixintTag(0),
+			},
+		},
+		{
+			value: fieldsEmbedded{FieldsExtra: &FieldsExtra{Extra: true}, Opt: []int{1}},
+			data: []byte{
+				fixmapTag(6),
+				fixstrTag(2), 'I', 'D', posFixintTag(0),
+				fixstrTag(4), 'N', 'a', 'm', 'e', fixstrTag(0),
+				fixstrTag(5), 'E', 'x', 't', 'r', 'a', tagTrue,
+				fixstrTag(4), 'n', 'a', 'm', 'e', fixstrTag(0),
+				fixstrTag(1), '-', posFixintTag(0),
+				fixstrTag(3), 'O', 'p', 't', fixarrayTag(1), posFixintTag(1),
+			},
+		},
+		{
+			value: fieldsPoint{X: 1, Y: 2},
+			data:  []byte{fixarrayTag(2), posFixintTag(1), posFixintTag(2)},
+		},
+	}
+
+	for _, test := range tests {
+		data, err := MarshalValue(test.value)
+		if err != nil {
+			t.Errorf("unexpected error for %#v: %v", test.value, err)
+			continue
+		} else if !bytes.Equal(data, test.data) {
+			t.Errorf("unexpected encoding for %#v: %x", test.value, data)
+		}
+
+		decoded := reflect.New(reflect.TypeOf(test.value))
+		if err := UnmarshalValue(data, decoded.Interface()); err != nil {
+			t.Errorf("unexpected decode error for %#v: %v", test.value, err)
+		}
+		expected := reflect.ValueOf(test.value)
+		if e, ok := test.value.(fieldsEmbedded); ok {
+			e.Skipped = 0
+			expected = reflect.ValueOf(e)
+		}
+		if !reflect.DeepEqual(decoded.Elem().Interface(), expected.Interface()) {
+			t.Errorf("unexpected decoded value: %#v", decoded.Elem().Interface())
+		}
+	}
+}
+
+func TestStructArrayEvolution(t *testing.T) {
+	// decode old data with a new type
+	data, err := MarshalValue(fieldsPoint{X: 1, Y: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v2 := fieldsPointV2{Label: "keep"}
+	if err := UnmarshalValue(data, &v2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v2 != (fieldsPointV2{X: 1, Y: 2, Label: "keep"}) {
+		t.Errorf("unexpected value: %#v", v2)
+	}
+
+	// decode new data with an old type
+	data, err = MarshalValue(fieldsPointV2{X: 3, Y: 4, Label: "new"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var v1 fieldsPoint
+	if err := UnmarshalValue(data, &v1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v1 != (fieldsPoint{X: 3, Y: 4}) {
+		t.Errorf("unexpected value: %#v", v1)
+	}
+
+	// decode the map layout into an array struct
+	data, err = MarshalValue(map[string]int{"Y": 6})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := UnmarshalValue(data, &v1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v1 != (fieldsPoint{X: 3, Y: 6}) {
+		t.Errorf("unexpected value: %#v", v1)
+	}
+}
@@ -0,0 +1,445 @@
+package msgpack
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// GoOptions defines the output of GenerateGo.
+type GoOptions struct {
+	// Package is the name of the package of the generated file. If empty,
+	// the package main is used.
+	Package string
+	// TypeName is the name of the type which is generated for the schema
+	// itself. If empty, the name Root is used. The names of nested types are
+	// derived from it.
+	TypeName string
+}
+
+// GenerateGo writes the Go source of a struct type for the object schema s,
+// and of all nested object schemas, to w. If s describes arrays, the struct
+// type is generated for their items. Every struct type comes with
+// EncodeMsgpack and DecodeMsgpack methods, which encode its value as a map.
+//
+// Properties are mapped to fields in the order of their keys:
+//   - integers use the smallest Go type which holds the range between
+//     minimum and maximum, e.g. uint16 for a range of 0 to 1000
+//   - numbers use float64, strings string, binary data []byte, time values
+//     time.Time and other extension values RawExt
+//   - arrays use slices, objects with properties struct types
+//   - unions and all other schemas use Value
+//
+// Nullable and optional properties use pointers, unless their Go type can
+// already represent nil. Nil values of optional fields are omitted when
+// encoding.
+func GenerateGo(w io.Writer, s *Schema, opts GoOptions) error {
+	if opts.Package == "" {
+		opts.Package = "main"
+	}
+	if opts.TypeName == "" {
+		opts.TypeName = "Root"
+	}
+
+	for s.Items != nil && !s.isObject() {
+		s = s.Items
+	}
+	if !s.isObject() {
+		return errorString("schema does not describe objects")
+	}
+
+	g := goGenerator{names: make(map[string]bool)}
+	g.declare(opts.TypeName, s)
+	for i := 0; i < len(g.decls); i++ {
+		g.generateStruct(g.decls[i])
+	}
+
+	var src bytes.Buffer
+	src.WriteString("// Code generated from an inferred MessagePack schema. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&src, "package %s\n\nimport (\n", opts.Package)
+	if g.usesTime {
+		src.WriteString("\t\"time\"\n\n")
+	}
+	src.WriteString("\tmsgpack \"github.com/mprot/msgpack-go\"\n)\n")
+	g.buf.WriteTo(&src)
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(formatted)
+	return err
+}
+
+// isObject reports whether s describes maps with properties only.
+func (s *Schema) isObject() bool {
+	return len(s.Type) == 1 && s.Type[0] == SchemaObject && s.hasPropertiesOnly()
+}
+
+// hasPropertiesOnly reports whether the entries of maps described by s are
+// all listed in its properties.
+func (s *Schema) hasPropertiesOnly() bool {
+	return len(s.Properties) != 0 && s.AdditionalProperties == nil
+}
+
+type goKind int
+
+const (
+	goBasic   goKind = iota // written and read with a method of Writer and Reader
+	goNumber                // float64 read from any number
+	goBytes                 // []byte
+	goSlice                 // slice of elem
+	goPointer               // pointer to elem
+	goCodec                 // type with EncodeMsgpack and DecodeMsgpack methods
+)
+
+// goType describes the Go type of a value.
+type goType struct {
+	kind     goKind
+	expr     string  // Go type expression
+	method   string  // method suffix of goBasic types, e.g. "Uint16"
+	elem     *goType // element type of goSlice and goPointer
+	nullable bool    // nil slices are encoded as nil
+}
+
+var (
+	goValueType  = &goType{kind: goCodec, expr: "msgpack.Value"}
+	goRawExtType = &goType{kind: goCodec, expr: "msgpack.RawExt"}
+)
+
+type goDecl struct {
+	name   string
+	schema *Schema
+}
+
+type goGenerator struct {
+	buf      bytes.Buffer
+	names    map[string]bool
+	decls    []goDecl
+	usesTime bool
+}
+
+// declare reserves a unique type name for the struct type of s.
+func (g *goGenerator) declare(name string, s *Schema) string {
+	unique := name
+	for i := 2; g.names[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	g.names[unique] = true
+	g.decls = append(g.decls, goDecl{name: unique, schema: s})
+	return unique
+}
+
+// typeOf returns the Go type for values of s. If nilable is set, the type
+// needs to be able to represent nil. Struct types are named after name.
+func (g *goGenerator) typeOf(s *Schema, name string, nilable bool) *goType {
+	if s == nil {
+		return goValueType
+	}
+
+	var types []SchemaType
+	for _, t := range s.Type {
+		if t == SchemaNull {
+			nilable = true
+		} else {
+			types = append(types, t)
+		}
+	}
+	if len(types) != 1 {
+		return goValueType
+	}
+
+	var t *goType
+	switch types[0] {
+	case SchemaBoolean:
+		t = &goType{kind: goBasic, expr: "bool", method: "Bool"}
+	case SchemaInteger:
+		expr := goIntType(s.Minimum, s.Maximum)
+		if expr == "" {
+			return goValueType
+		}
+		t = &goType{kind: goBasic, expr: expr, method: strings.ToUpper(expr[:1]) + expr[1:]}
+	case SchemaNumber:
+		t = &goType{kind: goNumber, expr: "float64"}
+	case SchemaString:
+		t = &goType{kind: goBasic, expr: "string", method: "String"}
+	case SchemaTime:
+		g.usesTime = true
+		t = &goType{kind: goBasic, expr: "time.Time", method: "Time"}
+	case SchemaExt:
+		t = goRawExtType
+	case SchemaBin:
+		return &goType{kind: goBytes, expr: "[]byte", nullable: nilable}
+	case SchemaArray:
+		elem := g.typeOf(s.Items, name+"Item", false)
+		return &goType{kind: goSlice, expr: "[]" + elem.expr, elem: elem, nullable: nilable}
+	case SchemaObject:
+		if !s.hasPropertiesOnly() {
+			return goValueType
+		}
+		t = &goType{kind: goCodec, expr: g.declare(name, s)}
+	default:
+		return goValueType
+	}
+
+	if nilable {
+		return &goType{kind: goPointer, expr: "*" + t.expr, elem: t}
+	}
+	return t
+}
+
+// goIntType returns the smallest integer type which holds all integers
+// between min and max. If there is no such type, i.e. if min is negative and
+// max exceeds the range of int64, an empty string is returned.
+func goIntType(min, max Value) string {
+	if !min.isNumber() || !max.isNumber() {
+		return "int64"
+	}
+
+	if compareNumbers(min, IntValue(0)) >= 0 {
+		for _, bits := range []uint{8, 16, 32} {
+			if compareNumbers(max, UintValue(1<<bits-1)) <= 0 {
+				return fmt.Sprintf("uint%d", bits)
+			}
+		}
+		if max.typ == Uint && max.num > 1<<63-1 {
+			return "uint64"
+		}
+	}
+
+	for _, bits := range []uint{8, 16, 32} {
+		if compareNumbers(min, IntValue(-1<<(bits-1))) >= 0 && compareNumbers(max, IntValue(1<<(bits-1)-1)) <= 0 {
+			return fmt.Sprintf("int%d", bits)
+		}
+	}
+	if compareNumbers(min, IntValue(0)) < 0 && compareNumbers(max, IntValue(1<<63-1)) > 0 {
+		return ""
+	}
+	return "int64"
+}
+
+type goField struct {
+	key      string
+	name     string
+	typ      *goType
+	optional bool
+}
+
+func (g *goGenerator) generateStruct(d goDecl) {
+	keys := make([]string, 0, len(d.schema.Properties))
+	for key := range d.schema.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	required := make(map[string]bool, len(d.schema.Required))
+	for _, key := range d.schema.Required {
+		required[key] = true
+	}
+
+	// fields must not clash with the generated methods
+	names := map[string]bool{"EncodeMsgpack": true, "DecodeMsgpack": true}
+	fields := make([]goField, len(keys))
+	for i, key := range keys {
+		name := goName(key)
+		for j := 2; names[name]; j++ {
+			name = fmt.Sprintf("%s%d", goName(key), j)
+		}
+		names[name] = true
+
+		optional := !required[key]
+		fields[i] = goField{
+			key:      key,
+			name:     name,
+			typ:      g.typeOf(d.schema.Properties[key], d.name+name, optional),
+			optional: optional,
+		}
+	}
+
+	fmt.Fprintf(&g.buf, "\ntype %s struct {\n", d.name)
+	for _, f := range fields {
+		fmt.Fprintf(&g.buf, "%s %s `msgpack:%q`\n", f.name, f.typ.expr, f.key)
+	}
+	g.buf.WriteString("}\n")
+
+	// encoding
+	fmt.Fprintf(&g.buf, "\n// EncodeMsgpack implements the msgpack.Encoder interface.\n")
+	fmt.Fprintf(&g.buf, "func (v *%s) EncodeMsgpack(w *msgpack.Writer) error {\n", d.name)
+	n := 0
+	for _, f := range fields {
+		if !f.optional {
+			n++
+		}
+	}
+	if n == len(fields) {
+		fmt.Fprintf(&g.buf, "if err := w.WriteMapHeader(%d); err != nil {\nreturn err\n}\n", n)
+	} else {
+		fmt.Fprintf(&g.buf, "n := %d\n", n)
+		for _, f := range fields {
+			if f.optional {
+				fmt.Fprintf(&g.buf, "if %s {\nn++\n}\n", f.present("v."+f.name))
+			}
+		}
+		g.buf.WriteString("if err := w.WriteMapHeader(n); err != nil {\nreturn err\n}\n")
+	}
+	for _, f := range fields {
+		if f.optional {
+			fmt.Fprintf(&g.buf, "if %s {\n", f.present("v."+f.name))
+		}
+		fmt.Fprintf(&g.buf, "if err := w.WriteString(%q); err != nil {\nreturn err\n}\n", f.key)
+		expr, t := "v."+f.name, f.typ
+		if f.optional {
+			// Nil values of optional fields are never encoded.
+			expr, t = t.nonNil(expr)
+		}
+		g.encode(expr, t, 1)
+		if f.optional {
+			g.buf.WriteString("}\n")
+		}
+	}
+	g.buf.WriteString("return nil\n}\n")
+
+	// decoding
+	fmt.Fprintf(&g.buf, "\n// DecodeMsgpack implements the msgpack.Decoder interface.\n")
+	fmt.Fprintf(&g.buf, "func (v *%s) DecodeMsgpack(r *msgpack.Reader) error {\n", d.name)
+	g.buf.WriteString("n, err := r.ReadMapHeader()\nif err != nil {\nreturn err\n}\n")
+	g.buf.WriteString("for i := 0; i < n; i++ {\nkey, err := r.ReadString()\nif err != nil {\nreturn err\n}\nswitch key {\n")
+	for _, f := range fields {
+		fmt.Fprintf(&g.buf, "case %q:\n", f.key)
+		g.decode("v."+f.name, f.typ, 1)
+	}
+	g.buf.WriteString("default:\nif err := r.Skip(); err != nil {\nreturn err\n}\n}\n}\nreturn nil\n}\n")
+}
+
+// nonNil returns the expression and type for the value of expr of type t,
+// which is known to be non-nil.
+func (t *goType) nonNil(expr string) (string, *goType) {
+	switch {
+	case t.kind == goPointer && t.elem.kind == goCodec:
+		return expr, t.elem
+	case t.kind == goPointer:
+		return "*" + expr, t.elem
+	case t.nullable:
+		nonNil := *t
+		nonNil.nullable = false
+		return expr, &nonNil
+	default:
+		return expr, t
+	}
+}
+
+// present returns the condition under which an optional field is encoded.
+func (f goField) present(expr string) string {
+	if f.typ == goValueType {
+		return "!" + expr + ".IsNil()"
+	}
+	return expr + " != nil"
+}
+
+// encode writes the statements which encode the value of expr.
+func (g *goGenerator) encode(expr string, t *goType, depth int) {
+	if t.kind == goPointer || t.nullable {
+		fmt.Fprintf(&g.buf, "if %s == nil {\nif err := w.WriteNil(); err != nil {\nreturn err\n}\n} else {\n", expr)
+		defer g.buf.WriteString("}\n")
+	}
+
+	switch t.kind {
+	case goBasic:
+		fmt.Fprintf(&g.buf, "if err := w.Write%s(%s); err != nil {\nreturn err\n}\n", t.method, expr)
+	case goNumber:
+		fmt.Fprintf(&g.buf, "if err := w.WriteFloat64(%s); err != nil {\nreturn err\n}\n", expr)
+	case goBytes:
+		fmt.Fprintf(&g.buf, "if err := w.WriteBytes(%s); err != nil {\nreturn err\n}\n", expr)
+	case goSlice:
+		fmt.Fprintf(&g.buf, "if err := w.WriteArrayHeader(len(%s)); err != nil {\nreturn err\n}\n", expr)
+		elem := fmt.Sprintf("e%d", depth)
+		fmt.Fprintf(&g.buf, "for _, %s := range %s {\n", elem, expr)
+		g.encode(elem, t.elem, depth+1)
+		g.buf.WriteString("}\n")
+	case goPointer:
+		expr, elem := t.nonNil(expr)
+		g.encode(expr, elem, depth)
+	case goCodec:
+		fmt.Fprintf(&g.buf, "if err := %s.EncodeMsgpack(w); err != nil {\nreturn err\n}\n", expr)
+	}
+}
+
+// decode writes the statements which decode the next value into lvalue.
+func (g *goGenerator) decode(lvalue string, t *goType, depth int) {
+	if t.kind == goPointer || t.nullable {
+		g.buf.WriteString("if typ, err := r.Peek(); err != nil {\nreturn err\n} else if typ == msgpack.Nil {\n")
+		fmt.Fprintf(&g.buf, "if err := r.ReadNil(); err != nil {\nreturn err\n}\n%s = nil\n} else {\n", lvalue)
+		defer g.buf.WriteString("}\n")
+	}
+
+	x := fmt.Sprintf("x%d", depth)
+	switch t.kind {
+	case goBasic:
+		fmt.Fprintf(&g.buf, "%s, err := r.Read%s()\nif err != nil {\nreturn err\n}\n%s = %s\n", x, t.method, lvalue, x)
+	case goNumber:
+		fmt.Fprintf(&g.buf, "var %s msgpack.Value\nif err := %s.DecodeMsgpack(r); err != nil {\nreturn err\n}\n", x, x)
+		fmt.Fprintf(&g.buf, "switch typ := %s.Type(); typ {\ncase msgpack.Int, msgpack.Uint, msgpack.Float:\n", x)
+		g.buf.WriteString("default:\nreturn msgpack.TypeError{Actual: typ, Expected: msgpack.Float}\n}\n")
+		fmt.Fprintf(&g.buf, "%s = %s.Float()\n", lvalue, x)
+	case goBytes:
+		fmt.Fprintf(&g.buf, "%s, err := r.ReadBytes(nil)\nif err != nil {\nreturn err\n}\n", x)
+		if t.nullable {
+			fmt.Fprintf(&g.buf, "if %s == nil {\n%s = []byte{}\n}\n", x, x)
+		}
+		fmt.Fprintf(&g.buf, "%s = %s\n", lvalue, x)
+	case goSlice:
+		// The elements are appended one by one, so that a forged header
+		// cannot trigger a huge allocation.
+		n, i, e := fmt.Sprintf("n%d", depth), fmt.Sprintf("i%d", depth), fmt.Sprintf("e%d", depth)
+		fmt.Fprintf(&g.buf, "%s, err := r.ReadArrayHeader()\nif err != nil {\nreturn err\n}\n", n)
+		fmt.Fprintf(&g.buf, "%s := %s{}\nfor %s := 0; %s < %s; %s++ {\nvar %s %s\n", x, t.expr, i, i, n, i, e, t.elem.expr)
+		g.decode(e, t.elem, depth+1)
+		fmt.Fprintf(&g.buf, "%s = append(%s, %s)\n}\n%s = %s\n", x, x, e, lvalue, x)
+	case goPointer:
+		p := fmt.Sprintf("p%d", depth)
+		fmt.Fprintf(&g.buf, "%s := new(%s)\n", p, t.elem.expr)
+		g.decode("*"+p, t.elem, depth+1)
+		fmt.Fprintf(&g.buf, "%s = %s\n", lvalue, p)
+	case goCodec:
+		if strings.HasPrefix(lvalue, "*") {
+			lvalue = "(" + lvalue + ")"
+		}
+		fmt.Fprintf(&g.buf, "if err := %s.DecodeMsgpack(r); err != nil {\nreturn err\n}\n", lvalue)
+	}
+}
+
+// goInitialisms are the words which are written in upper case in Go names.
+var goInitialisms = map[string]bool{
+	"API": true, "HTTP": true, "ID": true, "IP": true, "JSON": true,
+	"URI": true, "URL": true, "UUID": true,
+}
+
+// goName returns an exported Go identifier for the map key.
+func goName(key string) string {
+	words := strings.FieldsFunc(key, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var sb strings.Builder
+	for _, w := range words {
+		if goInitialisms[strings.ToUpper(w)] {
+			sb.WriteString(strings.ToUpper(w))
+			continue
+		}
+		r := []rune(w)
+		r[0] = unicode.ToUpper(r[0])
+		sb.WriteString(string(r))
+	}
+
+	name := sb.String()
+	if name == "" {
+		return "Field"
+	} else if r := []rune(name)[0]; !unicode.IsUpper(r) {
+		return "X" + name
+	}
+	return name
+}
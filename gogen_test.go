@@ -0,0 +1,195 @@
+package msgpack
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateGo(t *testing.T) {
+	var samples bytes.Buffer
+	w := NewWriter(&samples)
+	w.WriteValue(map[string]interface{}{
+		"id":       1,
+		"user_id":  300,
+		"temp":     -3,
+		"name":     "a",
+		"tags":     []string{"x"},
+		"pos":      map[string]interface{}{"x": 1.5, "y": 2},
+		"taken":    time.Unix(1, 0),
+		"raw":      []byte{1},
+		"note":     nil,
+		"mixed":    1,
+		"children": []interface{}{map[string]interface{}{"a": 1, "encode_msgpack": 2, "DecodeMsgpack": true}, nil},
+		"ext":      RawExt{Type: 3, Data: []byte{1}},
+	})
+	w.WriteValue(map[string]interface{}{
+		"id":       2,
+		"user_id":  3,
+		"temp":     100,
+		"name":     "b",
+		"tags":     []string{},
+		"pos":      map[string]interface{}{"x": 1, "y": 2},
+		"taken":    time.Unix(2, 5),
+		"note":     "x",
+		"mixed":    "s",
+		"children": []interface{}{},
+		"ext":      RawExt{Type: 3, Data: []byte{}},
+	})
+	w.Flush()
+
+	s, err := InferSchema(bytes.NewReader(samples.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var src bytes.Buffer
+	if err := GenerateGo(&src, s, GoOptions{TypeName: "Reading"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, field := range []string{
+		"Children []*ReadingChildrenItem `msgpack:\"children\"`",
+		"DecodeMsgpack2 bool",
+		"EncodeMsgpack2 uint8",
+		"Ext      msgpack.RawExt",
+		"ID       uint8",
+		"Mixed    msgpack.Value",
+		"Note     *string",
+		"Pos      ReadingPos",
+		"Raw      []byte",
+		"Taken    time.Time",
+		"Temp     int8",
+		"UserID   uint16",
+		"X float64",
+	} {
+		if !strings.Contains(src.String(), field) {
+			t.Errorf("missing field %q in generated code:\n%s", field, src.String())
+		}
+	}
+
+	if testing.Short() {
+		t.Skip("skipping compilation of generated code in short mode")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":      "module gentest\n\ngo 1.21\n\nrequire github.com/mprot/msgpack-go v0.0.0\n\nreplace github.com/mprot/msgpack-go => " + wd + "\n",
+		"types.go":    src.String(),
+		"samples.bin": samples.String(),
+		"main.go": `package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+
+	msgpack "github.com/mprot/msgpack-go"
+)
+
+func main() {
+	samples, err := os.ReadFile("samples.bin")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// a forged array header must not trigger a huge allocation
+	forged := []byte{0x81, 0xa4, 't', 'a', 'g', 's', 0xdd, 0xff, 0xff, 0xff, 0xff}
+	if err := msgpack.Unmarshal(forged, new(Reading)); err == nil {
+		log.Fatal("expected error for forged array header")
+	}
+
+	dec := msgpack.NewStreamDecoder(bytes.NewReader(samples))
+	for {
+		var raw msgpack.Raw
+		if err := dec.Decode(&raw); err == io.EOF {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+
+		var r Reading
+		if err := msgpack.Unmarshal(raw, &r); err != nil {
+			log.Fatal(err)
+		}
+		encoded, err := msgpack.Marshal(&r)
+		if err != nil {
+			log.Fatal(err)
+		}
+		eq, err := msgpack.EqualWithOptions(raw, encoded, msgpack.EqualOptions{IgnoreMapOrder: true})
+		if err != nil || !eq {
+			log.Fatalf("round trip mismatch: %x != %x (error: %v)", raw, encoded, err)
+		}
+	}
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(gobin, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod=mod")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("generated code failed: %v\n%s", err, out)
+	}
+}
+
+func TestGoIntType(t *testing.T) {
+	tests := []struct {
+		min, max Value
+		expected string
+	}{
+		{UintValue(0), UintValue(255), "uint8"},
+		{UintValue(0), UintValue(256), "uint16"},
+		{IntValue(0), IntValue(1 << 20), "uint32"},
+		{UintValue(1), UintValue(1 << 63), "uint64"},
+		{IntValue(-128), UintValue(127), "int8"},
+		{IntValue(-1), UintValue(128), "int16"},
+		{IntValue(-1 << 31), IntValue(0), "int32"},
+		{IntValue(-1), UintValue(1 << 40), "int64"},
+		{IntValue(-1), UintValue(1 << 63), ""},
+		{IntValue(-1), FloatValue(1e19), ""},
+		{FloatValue(-0.5), IntValue(1 << 62), "int64"},
+		{Value{}, Value{}, "int64"},
+	}
+
+	for _, test := range tests {
+		if typ := goIntType(test.min, test.max); typ != test.expected {
+			t.Errorf("unexpected type for [%s, %s]: %s", formatValue(test.min), formatValue(test.max), typ)
+		}
+	}
+}
+
+func TestGoName(t *testing.T) {
+	tests := map[string]string{
+		"name":       "Name",
+		"user_id":    "UserID",
+		"createdAt":  "CreatedAt",
+		"api-url":    "APIURL",
+		"2fa":        "X2fa",
+		"":           "Field",
+		"grüße welt": "GrüßeWelt",
+	}
+
+	for key, expected := range tests {
+		if name := goName(key); name != expected {
+			t.Errorf("unexpected name for %q: %s", key, name)
+		}
+	}
+}
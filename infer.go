@@ -0,0 +1,197 @@
+package msgpack
+
+import (
+	"io"
+	"sort"
+)
+
+// schemaTypeOrder defines the order of the types of an inferred schema.
+var schemaTypeOrder = []SchemaType{
+	SchemaNull, SchemaBoolean, SchemaInteger, SchemaNumber, SchemaString,
+	SchemaBin, SchemaTime, SchemaExt, SchemaArray, SchemaObject,
+}
+
+// InferSchema reads a sequence of sample values from r until EOF and infers
+// a schema which matches all of them:
+//   - values of different types result in a union of all observed types,
+//     where nil values make the schema nullable
+//   - integers result in the integer type with the observed range as minimum
+//     and maximum, which reveals their width and signedness
+//   - a mix of integers and floating-point numbers results in the number type
+//   - the elements of all arrays are unified into a single items schema
+//   - maps with string keys result in properties, where only the keys present
+//     in every map are required. The values of all entries with other keys
+//     are unified into the schema of additional properties.
+//   - extension values result in the ext type, which is restricted to the
+//     observed extension type if only a single one occurred
+//
+// A LimitError is returned if the nesting depth of a sample exceeds 10000.
+func InferSchema(r io.Reader) (*Schema, error) {
+	reader := NewReader(r)
+	defer releaseReader(reader)
+	reader.SetOptions(ReaderOptions{MaxDepth: maxValueDepth})
+
+	var inf schemaInference
+	for {
+		if _, err := reader.Peek(); err == io.EOF {
+			return inf.schema(), nil
+		} else if err != nil {
+			return nil, err
+		}
+		if err := inf.add(reader); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+}
+
+// schemaInference collects the observations of all values at the same
+// position of the samples.
+type schemaInference struct {
+	types    map[SchemaType]bool
+	min, max Value
+	extTypes map[int8]bool
+
+	items *schemaInference
+
+	objects int // number of observed maps
+	props   map[string]*schemaInference
+	counts  map[string]int // number of maps holding a key
+	others  *schemaInference
+}
+
+func (inf *schemaInference) add(r *Reader) error {
+	typ, err := r.Peek()
+	if err != nil {
+		return err
+	}
+	if inf.types == nil {
+		inf.types = make(map[SchemaType]bool)
+	}
+	inf.types[schemaType(typ)] = true
+
+	switch typ {
+	case Int, Uint, Float:
+		n, err := readNumber(r)
+		if err != nil {
+			return err
+		}
+		if n.Float() == n.Float() { // skip NaN
+			if !inf.min.isNumber() || compareNumbers(n, inf.min) < 0 {
+				inf.min = n
+			}
+			if !inf.max.isNumber() || compareNumbers(n, inf.max) > 0 {
+				inf.max = n
+			}
+		}
+		return nil
+
+	case Ext:
+		typ, _, err := r.ReadExtAny()
+		if err != nil {
+			return err
+		}
+		if inf.extTypes == nil {
+			inf.extTypes = make(map[int8]bool)
+		}
+		inf.extTypes[typ] = true
+		return nil
+
+	case Array:
+		n, err := r.ReadArrayHeader()
+		if err != nil {
+			return err
+		}
+		if inf.items == nil {
+			inf.items = new(schemaInference)
+		}
+		for i := 0; i < n; i++ {
+			if err := inf.items.add(r); err != nil {
+				return unexpectedEOF(err)
+			}
+		}
+		return nil
+
+	case Map:
+		return inf.addMap(r)
+
+	default:
+		return r.Skip()
+	}
+}
+
+func (inf *schemaInference) addMap(r *Reader) error {
+	n, err := r.ReadMapHeader()
+	if err != nil {
+		return err
+	}
+	if inf.props == nil {
+		inf.props = make(map[string]*schemaInference)
+		inf.counts = make(map[string]int)
+	}
+	inf.objects++
+
+	seen := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		var key Value
+		if err := key.DecodeMsgpack(r); err != nil {
+			return unexpectedEOF(err)
+		}
+
+		var prop *schemaInference
+		if key.typ == String {
+			prop = inf.props[key.str]
+			if prop == nil {
+				prop = new(schemaInference)
+				inf.props[key.str] = prop
+			}
+			if !seen[key.str] {
+				seen[key.str] = true
+				inf.counts[key.str]++
+			}
+		} else {
+			if inf.others == nil {
+				inf.others = new(schemaInference)
+			}
+			prop = inf.others
+		}
+
+		if err := prop.add(r); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+	return nil
+}
+
+func (inf *schemaInference) schema() *Schema {
+	s := new(Schema)
+	for _, t := range schemaTypeOrder {
+		if inf.types[t] && !(t == SchemaInteger && inf.types[SchemaNumber]) {
+			s.Type = append(s.Type, t)
+		}
+	}
+
+	s.Minimum, s.Maximum = inf.min, inf.max
+	if len(inf.extTypes) == 1 {
+		for typ := range inf.extTypes {
+			s.ExtType = &typ
+		}
+	}
+	if inf.items != nil {
+		s.Items = inf.items.schema()
+	}
+
+	if len(inf.props) != 0 {
+		s.Properties = make(map[string]*Schema, len(inf.props))
+		for key, prop := range inf.props {
+			s.Properties[key] = prop.schema()
+			if inf.counts[key] == inf.objects {
+				s.Required = append(s.Required, key)
+			}
+		}
+		sort.Strings(s.Required)
+	}
+	if inf.others != nil {
+		s.AdditionalProperties = inf.others.schema()
+	}
+	return s
+}
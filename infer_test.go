@@ -0,0 +1,78 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestInferSchema(t *testing.T) {
+	docs := `{"id": 1, "name": "a", "tags": ["x"], "score": 1, "meta": {"k": true}}
+		{"id": 70000, "name": null, "tags": [], "score": 2.5, "extra": -3}
+		{"id": 5, "name": "b", "tags": [1], "score": -1, "meta": {"k": false, "v": "x"}}`
+	samples := jsonToMsgpack(t, docs)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteMapHeader(2)
+	w.WriteInt(1)
+	w.WriteNil()
+	w.WriteString("bin")
+	w.WriteBytes([]byte{0xff})
+	w.Flush()
+	samples = append(samples, buf.Bytes()...)
+
+	s, err := InferSchema(bytes.NewReader(samples))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `{"type":"object","properties":{` +
+		`"bin":{"type":"bin"},` +
+		`"extra":{"type":"integer","minimum":-3,"maximum":-3},` +
+		`"id":{"type":"integer","minimum":1,"maximum":70000},` +
+		`"meta":{"type":"object","properties":{"k":{"type":"boolean"},"v":{"type":"string"}},"required":["k"]},` +
+		`"name":{"type":["null","string"]},` +
+		`"score":{"type":"number","minimum":-1,"maximum":2.5},` +
+		`"tags":{"type":"array","items":{"type":["integer","string"],"minimum":1,"maximum":1}}},` +
+		`"additionalProperties":{"type":"null"}}`
+	if string(data) != expected {
+		t.Errorf("unexpected schema: %s", data)
+	}
+
+	r := NewReaderBytes(samples)
+	for i := 0; i < 4; i++ {
+		if err := s.Validate(r); err != nil {
+			t.Errorf("sample %d does not match the inferred schema: %v", i, err)
+		}
+	}
+
+	if _, err := InferSchema(bytes.NewReader(samples[:len(samples)-1])); err == nil {
+		t.Errorf("expected error for truncated samples")
+	}
+}
+
+func TestInferSchemaDeepNesting(t *testing.T) {
+	limitStack(t)
+
+	for _, raw := range [][]byte{nestedArrays(200000), nestedMaps(200000)} {
+		if _, err := InferSchema(bytes.NewReader(raw)); err == nil || err.Error() != "MaxDepth exceeded: 10001 > 10000" {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	s, err := InferSchema(bytes.NewReader(nestedArrays(maxValueDepth)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < maxValueDepth; i++ {
+		s = s.Items
+	}
+	if len(s.Type) != 1 || s.Type[0] != SchemaNull {
+		t.Errorf("unexpected innermost schema: %v", s.Type)
+	}
+}
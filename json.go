@@ -3,18 +3,103 @@ package msgpack
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"io"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+)
+
+// JSONOptions specifies how MessagePack data is transformed to JSON. The
+// zero value renders times as RFC 3339 strings, binary data as base64
+// strings and non-finite floating-point values as null, renders map keys
+// which are not scalars as strings and writes compact JSON.
+type JSONOptions struct {
+	// TimeLayout is the layout which is used to render time values as
+	// strings. If empty, time.RFC3339Nano is used.
+	TimeLayout string
+
+	// Bytes specifies how binary data is rendered.
+	Bytes BytesFormat
+
+	// NonFinite specifies how NaN and infinite floating-point values are
+	// rendered.
+	NonFinite NonFiniteFormat
+
+	// ExtObjects renders all extension values as {"$ext":id,"data":...},
+	// where data is the extension data rendered like binary data. If not
+	// set, only extension values of types registered with RegisterExt are
+	// supported, which are rendered with encoding/json.
+	ExtObjects bool
+
+	// Indent is used to indent nested elements of arrays and maps. If
+	// empty, compact JSON is written.
+	Indent string
+
+	// SortKeys sorts the entries of every map by their keys.
+	SortKeys bool
+
+	// StrictKeys rejects map keys which are arrays, maps or extension values
+	// without a string representation. If not set, such keys are rendered
+	// as strings holding their compact JSON representation.
+	StrictKeys bool
+
+	// Limits are enforced while reading the MessagePack data.
+	Limits ReaderOptions
+}
+
+// BytesFormat specifies how binary data is rendered in JSON.
+type BytesFormat int
+
+// Supported binary data formats.
+const (
+	BytesBase64 BytesFormat = iota // standard base64 encoding with padding
+	BytesHex                       // lower-case hexadecimal encoding
+)
+
+// NonFiniteFormat specifies how NaN and infinite floating-point values are
+// rendered in JSON.
+type NonFiniteFormat int
+
+// Supported formats for non-finite floating-point values.
+const (
+	NonFiniteNull   NonFiniteFormat = iota // null
+	NonFiniteString                        // "NaN", "+Inf" or "-Inf"
 )
 
 // CopyToJSON is a helper function for reading MessagePack encoded data from r,
-// transforming it to JSON, and writing it to w.
+// transforming it to JSON, and writing it to w. It is equivalent to
+// CopyToJSONWithOptions with zero options.
 func CopyToJSON(w io.Writer, r io.Reader) (written int, err error) {
+	return CopyToJSONWithOptions(w, r, JSONOptions{})
+}
+
+// CopyToJSONWithOptions is a helper function for reading MessagePack encoded
+// data from r, transforming it to JSON according to opts, and writing it to
+// w. Each top-level value is followed by a newline.
+func CopyToJSONWithOptions(w io.Writer, r io.Reader, opts JSONOptions) (written int, err error) {
 	reader := NewReader(r)
-	writer := jsonWriter{w}
+	reader.SetOptions(opts.Limits)
+	written, err = copyToJSON(w, reader, &opts)
+	releaseReader(reader)
+	return written, err
+}
+
+// CopyReaderToJSON is a helper function for reading MessagePack encoded data
+// from r until EOF, transforming it to JSON, and writing it to w. The limits
+// configured for r are enforced.
+func CopyReaderToJSON(w io.Writer, r *Reader) (written int, err error) {
+	return copyToJSON(w, r, &JSONOptions{})
+}
+
+func copyToJSON(w io.Writer, r *Reader, opts *JSONOptions) (written int, err error) {
+	writer := jsonWriter{Writer: w, opts: opts}
 	newline := [1]byte{'\n'}
 	for {
-		n, err := writer.WriteVal(reader, false)
+		n, err := writer.WriteVal(r, false)
 		written += n
 		if err != nil {
 			if err == io.EOF {
@@ -30,8 +115,144 @@ func CopyToJSON(w io.Writer, r io.Reader) (written int, err error) {
 	}
 }
 
+// CopyFromJSON is a helper function for reading a sequence of JSON documents
+// from r, transforming them to MessagePack, and writing them to w. Integers
+// without fraction or exponent are written as integers, all other numbers as
+// floating-point values. Every value is written with its smallest encoding.
+//
+// Since the number of elements of a JSON array or object is not known in
+// advance, the tokens of each document are held in memory until the
+// document is complete.
+func CopyFromJSON(w io.Writer, r io.Reader) (written int, err error) {
+	cw := &countingWriter{Writer: w}
+	writer := NewWriter(cw)
+	defer releaseWriter(writer)
+
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var toks []interface{}
+	for {
+		toks, err = readJSONDocument(dec, toks[:0])
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return cw.n, err
+		}
+
+		for _, tok := range toks {
+			if err = writeJSONToken(writer, tok); err != nil {
+				return cw.n, err
+			}
+		}
+		if err = writer.Flush(); err != nil {
+			return cw.n, err
+		}
+	}
+}
+
+// jsonContainer represents the beginning of a JSON array or object within a
+// document's token list.
+type jsonContainer struct {
+	isMap bool
+	n     int // number of elements, or keys and values for objects
+}
+
+// readJSONDocument reads the tokens of the next JSON document and appends
+// them to toks. The closing delimiters of arrays and objects are dropped and
+// the number of elements is stored in the container token instead.
+func readJSONDocument(dec *json.Decoder, toks []interface{}) ([]interface{}, error) {
+	var stack []*jsonContainer
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF && (len(stack) != 0 || len(toks) != 0) {
+				err = io.ErrUnexpectedEOF
+			}
+			return toks, err
+		}
+
+		if d, ok := tok.(json.Delim); ok && (d == ']' || d == '}') {
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return toks, nil
+			}
+			continue
+		}
+
+		if len(stack) != 0 {
+			stack[len(stack)-1].n++
+		}
+		if d, ok := tok.(json.Delim); ok {
+			c := &jsonContainer{isMap: d == '{'}
+			stack = append(stack, c)
+			tok = c
+		}
+		toks = append(toks, tok)
+
+		if len(stack) == 0 {
+			return toks, nil
+		}
+	}
+}
+
+func writeJSONToken(w *Writer, tok interface{}) error {
+	switch tok := tok.(type) {
+	case nil:
+		return w.WriteNil()
+	case bool:
+		return w.WriteBool(tok)
+	case string:
+		return w.WriteString(tok)
+	case json.Number:
+		return writeJSONNumber(w, string(tok))
+	case *jsonContainer:
+		if tok.isMap {
+			return w.WriteMapHeader(tok.n / 2)
+		}
+		return w.WriteArrayHeader(tok.n)
+	default:
+		return errorf("unexpected json token %v", tok)
+	}
+}
+
+func writeJSONNumber(w *Writer, s string) error {
+	if !strings.ContainsAny(s, ".eE") {
+		if s[0] == '-' {
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return w.WriteInt64(i)
+			}
+		} else if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return w.WriteUint64(u)
+		}
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	if f32 := float32(f); float64(f32) == f {
+		return w.WriteFloat32(f32)
+	}
+	return w.WriteFloat64(f)
+}
+
+type countingWriter struct {
+	io.Writer
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += n
+	return n, err
+}
+
 type jsonWriter struct {
 	io.Writer
+	opts  *JSONOptions
+	depth int
 }
 
 func (w *jsonWriter) WriteVal(r *Reader, quoted bool) (int, error) {
@@ -55,6 +276,10 @@ func (w *jsonWriter) WriteVal(r *Reader, quoted bool) (int, error) {
 		return w.writeString(r)
 	case Bytes:
 		return w.writeBytes(r)
+	case Time:
+		return w.writeTime(r)
+	case Ext:
+		return w.writeExt(r, quoted)
 	case Array:
 		return w.writeArray(r, quoted)
 	case Map:
@@ -105,7 +330,24 @@ func (w *jsonWriter) writeFloat(r *Reader, quoted bool) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	return w.puts(strconv.FormatFloat(f, 'f', -1, 64), quoted)
+
+	switch {
+	case math.IsNaN(f):
+		return w.writeNonFinite("NaN", quoted)
+	case math.IsInf(f, 1):
+		return w.writeNonFinite("+Inf", quoted)
+	case math.IsInf(f, -1):
+		return w.writeNonFinite("-Inf", quoted)
+	default:
+		return w.puts(strconv.FormatFloat(f, 'f', -1, 64), quoted)
+	}
+}
+
+func (w *jsonWriter) writeNonFinite(s string, quoted bool) (int, error) {
+	if !quoted && w.opts.NonFinite == NonFiniteNull {
+		return w.puts("null", false)
+	}
+	return w.puts(s, true)
 }
 
 func (w *jsonWriter) writeString(r *Reader) (int, error) {
@@ -117,25 +359,104 @@ func (w *jsonWriter) writeString(r *Reader) (int, error) {
 }
 
 func (w *jsonWriter) writeBytes(r *Reader) (int, error) {
-	b, err := r.ReadBytes(nil)
+	b, err := r.ReadBytesNoCopy()
 	if err != nil {
 		return 0, err
 	}
+	return w.puts(w.formatBytes(b), true)
+}
 
-	var buf bytes.Buffer
-	b64 := base64.NewEncoder(base64.StdEncoding, &buf)
-	if _, err = b64.Write(b); err == nil {
-		err = b64.Close()
+func (w *jsonWriter) formatBytes(b []byte) string {
+	if w.opts.Bytes == BytesHex {
+		return hex.EncodeToString(b)
 	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func (w *jsonWriter) writeTime(r *Reader) (int, error) {
+	tm, err := r.ReadTime()
 	if err != nil {
 		return 0, err
 	}
+
+	layout := w.opts.TimeLayout
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	return w.puts(tm.Format(layout), true)
+}
+
+func (w *jsonWriter) writeExt(r *Reader, quoted bool) (int, error) {
+	if w.opts.ExtObjects {
+		if quoted {
+			return w.writeStringified(r, Ext)
+		}
+		return w.writeExtObject(r)
+	}
+
+	typ, err := r.PeekExtType()
+	if err != nil {
+		return 0, err
+	}
+	if extFactory(typ) == nil {
+		return 0, errorf("unsupported json type: unregistered extension type %d", typ)
+	}
+
+	// Extension values of registered types are rendered with
+	// encoding/json.
+	v, err := r.readExt()
+	if err != nil {
+		return 0, err
+	}
+	p, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	if quoted && p[0] != '"' {
+		if w.opts.StrictKeys {
+			return 0, errorString("cannot use non-string extension value as map key")
+		}
+		return w.puts(string(p), true)
+	}
+	return w.Write(p)
+}
+
+// writeExtObject writes an extension value as {"$ext":id,"data":...}.
+func (w *jsonWriter) writeExtObject(r *Reader) (int, error) {
+	typ, data, err := r.ReadExtAny()
+	if err != nil {
+		return 0, err
+	}
+
+	inner := w.newline(w.depth + 1)
+	s := "{" + inner + `"$ext"` + w.keySeparator() + strconv.Itoa(int(typ)) +
+		"," + inner + `"data"` + w.keySeparator() + strconv.Quote(w.formatBytes(data)) +
+		w.newline(w.depth) + "}"
+	return io.WriteString(w, s)
+}
+
+// writeStringified writes the next value, which is of type typ, as a string
+// holding its compact JSON representation. It is used for map keys which
+// are not scalars.
+func (w *jsonWriter) writeStringified(r *Reader, typ Type) (int, error) {
+	if w.opts.StrictKeys {
+		return 0, errorf("cannot use %s type as map key", typ)
+	}
+
+	opts := *w.opts
+	opts.Indent = ""
+
+	var buf bytes.Buffer
+	sub := jsonWriter{Writer: &buf, opts: &opts}
+	if _, err := sub.WriteVal(r, false); err != nil {
+		return 0, err
+	}
 	return w.puts(buf.String(), true)
 }
 
 func (w *jsonWriter) writeArray(r *Reader, quoted bool) (int, error) {
 	if quoted {
-		return 0, errorString("cannot use array type as map key")
+		return w.writeStringified(r, Array)
 	}
 
 	count, err := r.ReadArrayHeader()
@@ -150,22 +471,35 @@ func (w *jsonWriter) writeArray(r *Reader, quoted bool) (int, error) {
 	}
 	n++
 
-	comma := false
+	w.depth++
 	for i := 0; i < count; i++ {
-		if comma {
+		if i > 0 {
 			if err = w.put(','); err != nil {
 				return n, err
 			}
 			n++
 		}
 
-		m, err := w.WriteVal(r, false)
+		m, err := w.writeNewline()
+		n += m
+		if err != nil {
+			return n, err
+		}
+
+		m, err = w.WriteVal(r, false)
 		n += m
 		if err != nil {
 			return n, unexpectedEOF(err)
 		}
+	}
+	w.depth--
 
-		comma = true
+	if count > 0 {
+		m, err := w.writeNewline()
+		n += m
+		if err != nil {
+			return n, err
+		}
 	}
 
 	if err = w.put(']'); err != nil {
@@ -177,13 +511,16 @@ func (w *jsonWriter) writeArray(r *Reader, quoted bool) (int, error) {
 
 func (w *jsonWriter) writeMap(r *Reader, quoted bool) (int, error) {
 	if quoted {
-		return 0, errorString("cannot use map type as map key")
+		return w.writeStringified(r, Map)
 	}
 
 	count, err := r.ReadMapHeader()
 	if err != nil {
 		return 0, err
 	}
+	if w.opts.SortKeys {
+		return w.writeSortedMap(r, count)
+	}
 
 	var n int
 
@@ -192,33 +529,35 @@ func (w *jsonWriter) writeMap(r *Reader, quoted bool) (int, error) {
 	}
 	n++
 
-	comma := false
+	w.depth++
 	for i := 0; i < count; i++ {
-		if comma {
+		if i > 0 {
 			if err = w.put(','); err != nil {
 				return n, err
 			}
 			n++
 		}
 
-		m, err := w.WriteVal(r, true)
+		m, err := w.writeNewline()
 		n += m
 		if err != nil {
-			return n, unexpectedEOF(err)
+			return n, err
 		}
 
-		if err = w.put(':'); err != nil {
+		m, err = w.writeMapEntry(r)
+		n += m
+		if err != nil {
 			return n, err
 		}
-		n++
+	}
+	w.depth--
 
-		m, err = w.WriteVal(r, false)
+	if count > 0 {
+		m, err := w.writeNewline()
 		n += m
 		if err != nil {
-			return n, unexpectedEOF(err)
+			return n, err
 		}
-
-		comma = true
 	}
 
 	if err = w.put('}'); err != nil {
@@ -228,6 +567,129 @@ func (w *jsonWriter) writeMap(r *Reader, quoted bool) (int, error) {
 	return n, nil
 }
 
+// writeSortedMap writes the count entries of a map, whose header has already
+// been read, sorted by their keys.
+func (w *jsonWriter) writeSortedMap(r *Reader, count int) (int, error) {
+	type entry struct {
+		key  string
+		data []byte
+	}
+
+	var (
+		buf     bytes.Buffer
+		entries = make([]entry, 0, min(count, maxPrealloc))
+	)
+	sub := jsonWriter{Writer: &buf, opts: w.opts, depth: w.depth + 1}
+	for i := 0; i < count; i++ {
+		buf.Reset()
+		if _, err := sub.WriteVal(r, true); err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		key, err := strconv.Unquote(buf.String())
+		if err != nil {
+			key = buf.String()
+		}
+
+		if _, err := sub.writeMapValue(r); err != nil {
+			return 0, err
+		}
+		entries = append(entries, entry{key: key, data: append([]byte{}, buf.Bytes()...)})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	var n int
+
+	if err := w.put('{'); err != nil {
+		return n, err
+	}
+	n++
+
+	w.depth++
+	for i, e := range entries {
+		if i > 0 {
+			if err := w.put(','); err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		m, err := w.writeNewline()
+		n += m
+		if err != nil {
+			return n, err
+		}
+
+		m, err = w.Write(e.data)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	w.depth--
+
+	if count > 0 {
+		m, err := w.writeNewline()
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+
+	if err := w.put('}'); err != nil {
+		return n, err
+	}
+	n++
+	return n, nil
+}
+
+func (w *jsonWriter) writeMapEntry(r *Reader) (int, error) {
+	n, err := w.WriteVal(r, true)
+	if err != nil {
+		return n, unexpectedEOF(err)
+	}
+
+	m, err := w.writeMapValue(r)
+	return n + m, err
+}
+
+// writeMapValue writes the separator between a map key and its value
+// followed by the value.
+func (w *jsonWriter) writeMapValue(r *Reader) (int, error) {
+	n, err := io.WriteString(w, w.keySeparator())
+	if err != nil {
+		return n, err
+	}
+
+	m, err := w.WriteVal(r, false)
+	return n + m, unexpectedEOF(err)
+}
+
+func (w *jsonWriter) keySeparator() string {
+	if w.opts.Indent != "" {
+		return ": "
+	}
+	return ":"
+}
+
+// writeNewline starts a new line indented according to the current depth,
+// if indentation is enabled.
+func (w *jsonWriter) writeNewline() (int, error) {
+	if w.opts.Indent == "" {
+		return 0, nil
+	}
+	return io.WriteString(w, w.newline(w.depth))
+}
+
+func (w *jsonWriter) newline(depth int) string {
+	if w.opts.Indent == "" {
+		return ""
+	}
+	return "\n" + strings.Repeat(w.opts.Indent, depth)
+}
+
 func (w *jsonWriter) put(c byte) error {
 	if bw, ok := w.Writer.(io.ByteWriter); ok {
 		return bw.WriteByte(c)
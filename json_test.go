@@ -2,8 +2,11 @@ package msgpack
 
 import (
 	"bytes"
+	"io"
+	"math"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestCopyToJSON(t *testing.T) {
@@ -25,6 +28,8 @@ func TestCopyToJSON(t *testing.T) {
 		{`"YmxvYg=="`, func(w *Writer) { fatalErr(w.WriteBytes([]byte("blob"))) }},
 		{`"two\nlines"`, func(w *Writer) { fatalErr(w.WriteString("two\nlines")) }},
 
+		{`{"X":1,"Y":2}`, func(w *Writer) { fatalErr(w.WriteExt(testExtType, &testExt{X: 1, Y: 2})) }},
+
 		{`[1,2,3]`, func(w *Writer) {
 			fatalErr(w.WriteArrayHeader(3))
 			fatalErr(w.WriteInt(1))
@@ -43,7 +48,9 @@ func TestCopyToJSON(t *testing.T) {
 	for _, d := range data {
 		encoded.Reset()
 		json.Reset()
-		d.write(NewWriter(&encoded))
+		w := NewWriter(&encoded)
+		d.write(w)
+		fatalErr(w.Flush())
 
 		n, err := CopyToJSON(&json, &encoded)
 		if err != nil {
@@ -55,3 +62,216 @@ func TestCopyToJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestCopyFromJSON(t *testing.T) {
+	tests := []struct {
+		json string
+		data []byte
+	}{
+		{`null`, []byte{tagNil}},
+		{`true`, []byte{tagTrue}},
+		{`7`, []byte{posFixintTag(7)}},
+		{`-7`, []byte{negFixintTag(-7)}},
+		{`300`, []byte{tagUint16, 0x01, 0x2c}},
+		{`-300`, []byte{tagInt16, 0xfe, 0xd4}},
+		{`18446744073709551615`, []byte{tagUint64, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{`18446744073709551616`, []byte{tagFloat32, 0x5f, 0x80, 0x00, 0x00}},
+		{`1.5`, []byte{tagFloat32, 0x3f, 0xc0, 0x00, 0x00}},
+		{`0.1`, []byte{tagFloat64, 0x3f, 0xb9, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}},
+		{`1e3`, []byte{tagFloat32, 0x44, 0x7a, 0x00, 0x00}},
+		{`"foo"`, []byte{fixstrTag(3), 'f', 'o', 'o'}},
+		{`[]`, []byte{fixarrayTag(0)}},
+		{`[1,[2,3],{}]`, []byte{fixarrayTag(3), posFixintTag(1), fixarrayTag(2), posFixintTag(2), posFixintTag(3), fixmapTag(0)}},
+		{`{"a":{"b":null},"c":[]}`, []byte{fixmapTag(2), fixstrTag(1), 'a', fixmapTag(1), fixstrTag(1), 'b', tagNil, fixstrTag(1), 'c', fixarrayTag(0)}},
+		{`1 "a" [] `, []byte{posFixintTag(1), fixstrTag(1), 'a', fixarrayTag(0)}},
+		{``, nil},
+	}
+
+	var buf bytes.Buffer
+	for _, test := range tests {
+		buf.Reset()
+		n, err := CopyFromJSON(&buf, strings.NewReader(test.json))
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", test.json, err)
+		} else if !bytes.Equal(buf.Bytes(), test.data) {
+			t.Errorf("unexpected data for %s: %x", test.json, buf.Bytes())
+		} else if n != buf.Len() {
+			t.Errorf("unexpected number of bytes for %s: %d", test.json, n)
+		}
+	}
+}
+
+func TestCopyFromJSONError(t *testing.T) {
+	tests := []struct {
+		json string
+		err  string
+	}{
+		{`[1,2`, "unexpected EOF"},
+		{`]`, "invalid character ']' looking for beginning of value"},
+		{`1e400`, `strconv.ParseFloat: parsing "1e400": value out of range`},
+	}
+
+	for _, test := range tests {
+		_, err := CopyFromJSON(io.Discard, strings.NewReader(test.json))
+		if err == nil {
+			t.Errorf("expected error for %s", test.json)
+		} else if err.Error() != test.err {
+			t.Errorf("unexpected error for %s: %v", test.json, err)
+		}
+	}
+}
+
+func TestJSONRoundtrip(t *testing.T) {
+	const doc = `{"id":-13,"name":"foo","tags":["a","b"],"ratio":0.25,"nested":{"ok":true,"none":null}}`
+
+	var encoded, decoded bytes.Buffer
+	if _, err := CopyFromJSON(&encoded, strings.NewReader(doc)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := CopyToJSON(&decoded, &encoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res := strings.TrimSuffix(decoded.String(), "\n"); res != doc {
+		t.Errorf("unexpected json: %s", res)
+	}
+}
+
+func TestCopyToJSONWithOptions(t *testing.T) {
+	fatalErr := func(err error) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	tm := time.Date(2017, time.September, 26, 13, 14, 15, 16, time.UTC)
+	nested := func(w *Writer) {
+		fatalErr(w.WriteMapHeader(2))
+		fatalErr(w.WriteString("b"))
+		fatalErr(w.WriteArrayHeader(2))
+		fatalErr(w.WriteInt(1))
+		fatalErr(w.WriteArrayHeader(0))
+		fatalErr(w.WriteString("a"))
+		fatalErr(w.WriteMapHeader(1))
+		fatalErr(w.WriteString("c"))
+		fatalErr(w.WriteNil())
+	}
+
+	tests := []struct {
+		opts  JSONOptions
+		json  string
+		write func(*Writer)
+	}{
+		// time
+		{JSONOptions{}, `"2017-09-26T13:14:15.000000016Z"`, func(w *Writer) { fatalErr(w.WriteTime(tm)) }},
+		{JSONOptions{TimeLayout: time.RFC3339}, `"2017-09-26T13:14:15Z"`, func(w *Writer) { fatalErr(w.WriteTime(tm)) }},
+
+		// bytes
+		{JSONOptions{}, `"AQL/"`, func(w *Writer) { fatalErr(w.WriteBytes([]byte{1, 2, 255})) }},
+		{JSONOptions{Bytes: BytesHex}, `"0102ff"`, func(w *Writer) { fatalErr(w.WriteBytes([]byte{1, 2, 255})) }},
+
+		// non-finite floats
+		{JSONOptions{}, `[null,null,null]`, func(w *Writer) {
+			fatalErr(w.WriteArrayHeader(3))
+			fatalErr(w.WriteFloat64(math.NaN()))
+			fatalErr(w.WriteFloat64(math.Inf(1)))
+			fatalErr(w.WriteFloat32(float32(math.Inf(-1))))
+		}},
+		{JSONOptions{NonFinite: NonFiniteString}, `["NaN","+Inf","-Inf"]`, func(w *Writer) {
+			fatalErr(w.WriteArrayHeader(3))
+			fatalErr(w.WriteFloat64(math.NaN()))
+			fatalErr(w.WriteFloat64(math.Inf(1)))
+			fatalErr(w.WriteFloat32(float32(math.Inf(-1))))
+		}},
+		{JSONOptions{}, `{"NaN":1}`, func(w *Writer) {
+			fatalErr(w.WriteMapHeader(1))
+			fatalErr(w.WriteFloat64(math.NaN()))
+			fatalErr(w.WriteInt(1))
+		}},
+
+		// extensions
+		{JSONOptions{ExtObjects: true}, `{"$ext":-2,"data":"AQI="}`, func(w *Writer) {
+			fatalErr(w.WriteExt(-2, bytesMarshaler{1, 2}))
+		}},
+		{JSONOptions{ExtObjects: true, Bytes: BytesHex}, `{"$ext":42,"data":"0102"}`, func(w *Writer) {
+			fatalErr(w.WriteExt(testExtType, &testExt{X: 1, Y: 2}))
+		}},
+		{JSONOptions{ExtObjects: true, Indent: "  "}, "[\n  {\n    \"$ext\": 5,\n    \"data\": \"\"\n  }\n]", func(w *Writer) {
+			fatalErr(w.WriteArrayHeader(1))
+			fatalErr(w.WriteExt(5, bytesMarshaler{}))
+		}},
+
+		// indentation and sorting
+		{JSONOptions{Indent: "\t"}, "{\n\t\"b\": [\n\t\t1,\n\t\t[]\n\t],\n\t\"a\": {\n\t\t\"c\": null\n\t}\n}", nested},
+		{JSONOptions{SortKeys: true}, `{"a":{"c":null},"b":[1,[]]}`, nested},
+		{JSONOptions{SortKeys: true, Indent: "  "}, "{\n  \"a\": {\n    \"c\": null\n  },\n  \"b\": [\n    1,\n    []\n  ]\n}", nested},
+		{JSONOptions{SortKeys: true}, `{"1":"b","10":"c","2":"a"}`, func(w *Writer) {
+			fatalErr(w.WriteMapHeader(3))
+			fatalErr(w.WriteInt(2))
+			fatalErr(w.WriteString("a"))
+			fatalErr(w.WriteInt(1))
+			fatalErr(w.WriteString("b"))
+			fatalErr(w.WriteInt(10))
+			fatalErr(w.WriteString("c"))
+		}},
+
+		// non-scalar keys
+		{JSONOptions{}, `{"[1,{\"a\":true}]":null,"{\"X\":1,\"Y\":2}":null}`, func(w *Writer) {
+			fatalErr(w.WriteMapHeader(2))
+			fatalErr(w.WriteArrayHeader(2))
+			fatalErr(w.WriteInt(1))
+			fatalErr(w.WriteMapHeader(1))
+			fatalErr(w.WriteString("a"))
+			fatalErr(w.WriteBool(true))
+			fatalErr(w.WriteNil())
+			fatalErr(w.WriteExt(testExtType, &testExt{X: 1, Y: 2}))
+			fatalErr(w.WriteNil())
+		}},
+		{JSONOptions{ExtObjects: true, Indent: " "}, "{\n \"{\\\"$ext\\\":3,\\\"data\\\":\\\"\\\"}\": 1\n}", func(w *Writer) {
+			fatalErr(w.WriteMapHeader(1))
+			fatalErr(w.WriteExt(3, bytesMarshaler{}))
+			fatalErr(w.WriteInt(1))
+		}},
+	}
+
+	var encoded, json bytes.Buffer
+	for _, test := range tests {
+		encoded.Reset()
+		json.Reset()
+		w := NewWriter(&encoded)
+		test.write(w)
+		fatalErr(w.Flush())
+
+		n, err := CopyToJSONWithOptions(&json, &encoded, test.opts)
+		if err != nil {
+			t.Errorf("unexpected copy error for %s: %v", test.json, err)
+		} else if res := strings.TrimSuffix(json.String(), "\n"); res != test.json {
+			t.Errorf("unexpected json result for %s: %s", test.json, res)
+		} else if n != json.Len() {
+			t.Errorf("unexpected number of bytes for %s: %d", test.json, n)
+		}
+	}
+}
+
+func TestCopyToJSONError(t *testing.T) {
+	strict := JSONOptions{StrictKeys: true}
+	tests := []struct {
+		opts JSONOptions
+		data []byte
+		err  string
+	}{
+		{strict, []byte{fixmapTag(1), fixarrayTag(0), tagNil}, "cannot use array type as map key"},
+		{strict, []byte{fixmapTag(1), fixmapTag(0), tagNil}, "cannot use map type as map key"},
+		{strict, []byte{fixmapTag(1), tagFixExt2, testExtType, 1, 2, tagNil}, "cannot use non-string extension value as map key"},
+		{JSONOptions{}, []byte{tagFixExt1, 0x05, 0x00}, "unsupported json type: unregistered extension type 5"},
+		{JSONOptions{}, []byte{fixarrayTag(2), tagNil}, "unexpected EOF"},
+	}
+
+	for _, test := range tests {
+		_, err := CopyToJSONWithOptions(io.Discard, bytes.NewReader(test.data), test.opts)
+		if err == nil {
+			t.Errorf("expected error for %x", test.data)
+		} else if err.Error() != test.err {
+			t.Errorf("unexpected error for %x: %v", test.data, err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package msgpack
+
+// MergePatch applies the MessagePack encoded merge patch to the MessagePack
+// encoded document target and returns the modified document. It follows the
+// semantics of JSON Merge Patch (RFC 7386): if patch is a map, each of its
+// entries replaces the target's entry with an equal key, a nil value removes
+// the entry, and map values are merged recursively. If patch is not a map,
+// it replaces the target as a whole.
+//
+// Map keys can be of any type and are compared semantically, like Diff does.
+// The entries of the target keep their order, new entries are added at the
+// end in the order of the patch. All values which are not modified are
+// copied verbatim. An empty target is treated like a missing value. target
+// and patch are not modified. If target or patch is a map which is nested
+// deeper than 10000 levels, a LimitError is returned.
+func MergePatch(target, patch []byte) ([]byte, error) {
+	p, isMap, err := readMapEntries(patch)
+	if err != nil {
+		return nil, err
+	} else if !isMap {
+		return copyValue(patch)
+	}
+
+	t, _, err := readMapEntries(target)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]rawEntry, 0, len(t)+len(p))
+	matched := make([]bool, len(p))
+	for _, et := range t {
+		i := findEntry(p, et.key, matched)
+		switch {
+		case i < 0:
+			res = append(res, et)
+		case p[i].value[0] == tagNil:
+			matched[i] = true
+		default:
+			matched[i] = true
+			value, err := MergePatch(et.value, p[i].value)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, rawEntry{key: et.key, rawKey: et.rawKey, value: value})
+		}
+	}
+
+	for i, ep := range p {
+		if matched[i] || ep.value[0] == tagNil {
+			continue
+		}
+		value, err := MergePatch(nil, ep.value)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rawEntry{key: ep.key, rawKey: ep.rawKey, value: value})
+	}
+	return appendMapEntries(nil, res), nil
+}
+
+// CreateMergePatch returns a minimal merge patch, which turns the MessagePack
+// encoded document original into modified when applied with MergePatch.
+// Unchanged map entries are omitted and nested maps are diffed recursively.
+// Maps which only differ in the order of their entries are unchanged.
+// As nil values denote removals in merge patches, an error is returned if
+// modified holds a map entry with a nil value, which is not part of
+// original.
+func CreateMergePatch(original, modified []byte) ([]byte, error) {
+	a, aIsMap, err := readMapEntries(original)
+	if err != nil {
+		return nil, err
+	}
+	b, bIsMap, err := readMapEntries(modified)
+	if err != nil {
+		return nil, err
+	}
+
+	if !aIsMap || !bIsMap {
+		if err := checkMergeValue(modified); err != nil {
+			return nil, err
+		}
+		return copyValue(modified)
+	}
+
+	var patch []rawEntry
+	matched := make([]bool, len(a))
+	for _, eb := range b {
+		i := findEntry(a, eb.key, matched)
+		if i < 0 {
+			if err := checkMergeValue(eb.value); err != nil {
+				return nil, err
+			}
+			patch = append(patch, eb)
+			continue
+		}
+
+		matched[i] = true
+		eq, err := EqualWithOptions(a[i].value, eb.value, EqualOptions{IgnoreMapOrder: true})
+		switch {
+		case err != nil:
+			return nil, err
+		case eq:
+			continue
+		case eb.value[0] == tagNil:
+			return nil, errorf("cannot represent nil value of map key %s in merge patch", formatValue(eb.key))
+		}
+
+		value, err := CreateMergePatch(a[i].value, eb.value)
+		if err != nil {
+			return nil, err
+		}
+		patch = append(patch, rawEntry{key: eb.key, rawKey: eb.rawKey, value: value})
+	}
+
+	for i, ea := range a {
+		if !matched[i] {
+			patch = append(patch, rawEntry{key: ea.key, rawKey: ea.rawKey, value: []byte{tagNil}})
+		}
+	}
+	return appendMapEntries(nil, patch), nil
+}
+
+// rawEntry holds the encoding of a single map entry together with its
+// decoded key.
+type rawEntry struct {
+	key    Value
+	rawKey []byte
+	value  []byte
+}
+
+// readMapEntries reads the entries of the encoded map raw. If raw is empty or
+// holds another type, no entries are returned and isMap is false.
+func readMapEntries(raw []byte) (entries []rawEntry, isMap bool, err error) {
+	if len(raw) == 0 {
+		return nil, false, nil
+	}
+
+	r := newValueReader(raw)
+	if typ, err := r.Peek(); err != nil {
+		return nil, false, err
+	} else if typ != Map {
+		return nil, false, nil
+	}
+
+	n, err := r.ReadMapHeader()
+	if err != nil {
+		return nil, false, err
+	}
+
+	entries = make([]rawEntry, 0, min(n, maxPrealloc))
+	for i := 0; i < n; i++ {
+		var e rawEntry
+		start := int(r.offset)
+		if err := e.key.DecodeMsgpack(r); err != nil {
+			return nil, false, unexpectedEOF(err)
+		}
+
+		mid := int(r.offset)
+		if err := r.Skip(); err != nil {
+			return nil, false, unexpectedEOF(err)
+		}
+
+		e.rawKey = raw[start:mid]
+		e.value = raw[mid:r.offset]
+		entries = append(entries, e)
+	}
+	return entries, true, nil
+}
+
+// appendMapEntries appends the encoding of a map holding entries to buf.
+func appendMapEntries(buf []byte, entries []rawEntry) []byte {
+	w := newAppendWriter(buf)
+	w.WriteMapHeader(len(entries))
+	for _, e := range entries {
+		w.buf = append(w.buf, e.rawKey...)
+		w.buf = append(w.buf, e.value...)
+	}
+	return w.buf
+}
+
+// findEntry returns the index of the first entry in entries which is not
+// marked in skip and whose key equals key, or -1 if there is no such entry.
+func findEntry(entries []rawEntry, key Value, skip []bool) int {
+	for i, e := range entries {
+		if !skip[i] && equalValues(e.key, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// copyValue returns a copy of the first encoded value in raw.
+func copyValue(raw []byte) ([]byte, error) {
+	return NewReaderBytes(raw).ReadRaw(nil)
+}
+
+// checkMergeValue checks whether the encoded value raw can be represented in
+// a merge patch, i.e. whether it holds no maps with nil values.
+func checkMergeValue(raw []byte) error {
+	entries, _, err := readMapEntries(raw)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.value[0] == tagNil {
+			return errorf("cannot represent nil value of map key %s in merge patch", formatValue(e.key))
+		}
+		if err := checkMergeValue(e.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package msgpack
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		target   string
+		patch    string
+		expected string
+	}{
+		// examples from RFC 7386, appendix A
+		{target: `{"a":"b"}`, patch: `{"a":"c"}`, expected: `{"a":"c"}`},
+		{target: `{"a":"b"}`, patch: `{"b":"c"}`, expected: `{"a":"b","b":"c"}`},
+		{target: `{"a":"b"}`, patch: `{"a":null}`, expected: `{}`},
+		{target: `{"a":"b","b":"c"}`, patch: `{"a":null}`, expected: `{"b":"c"}`},
+		{target: `{"a":["b"]}`, patch: `{"a":"c"}`, expected: `{"a":"c"}`},
+		{target: `{"a":"c"}`, patch: `{"a":["b"]}`, expected: `{"a":["b"]}`},
+		{target: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, expected: `{"a":{"b":"d"}}`},
+		{target: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, expected: `{"a":[1]}`},
+		{target: `["a","b"]`, patch: `["c","d"]`, expected: `["c","d"]`},
+		{target: `{"a":"b"}`, patch: `["c"]`, expected: `["c"]`},
+		{target: `{"a":"foo"}`, patch: `null`, expected: `null`},
+		{target: `{"a":"foo"}`, patch: `"bar"`, expected: `"bar"`},
+		{target: `{"e":null}`, patch: `{"a":1}`, expected: `{"e":null,"a":1}`},
+		{target: `[1,2]`, patch: `{"a":"b","c":null}`, expected: `{"a":"b"}`},
+		{target: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, expected: `{"a":{"bb":{}}}`},
+
+		// key order of the target is preserved
+		{target: `{"z":1,"y":2,"x":3}`, patch: `{"w":0,"x":4,"z":null}`, expected: `{"y":2,"x":4,"w":0}`},
+	}
+
+	for _, test := range tests {
+		res, err := MergePatch(jsonToMsgpack(t, test.target), jsonToMsgpack(t, test.patch))
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", test.patch, err)
+		} else if s := msgpackToJSON(t, res); s != test.expected {
+			t.Errorf("unexpected result for %s: %s", test.patch, s)
+		}
+	}
+}
+
+func TestMergePatchVerbatim(t *testing.T) {
+	// integer keys match regardless of their encoding, unmodified values keep
+	// their encoding
+	target := []byte{fixmapTag(2), tagInt64, 0, 0, 0, 0, 0, 0, 0, 1, tagUint16, 0x00, 0x02, fixstrTag(1), 'a', tagNil}
+	patch := []byte{fixmapTag(1), posFixintTag(1), posFixintTag(3)}
+
+	res, err := MergePatch(target, patch)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []byte{fixmapTag(2), tagInt64, 0, 0, 0, 0, 0, 0, 0, 1, posFixintTag(3), fixstrTag(1), 'a', tagNil}
+	if !bytes.Equal(res, expected) {
+		t.Errorf("unexpected result: %x", res)
+	}
+}
+
+func TestCreateMergePatch(t *testing.T) {
+	tests := []struct {
+		original string
+		modified string
+		expected string
+		err      string
+	}{
+		{original: `{"a":"b"}`, modified: `{"a":"b"}`, expected: `{}`},
+		{original: `{"a":"b","c":1}`, modified: `{"a":"x","c":1.0,"d":[1]}`, expected: `{"a":"x","d":[1]}`},
+		{original: `{"a":{"b":1,"c":2},"d":3}`, modified: `{"a":{"b":1}}`, expected: `{"a":{"c":null},"d":null}`},
+		{original: `{"a":1}`, modified: `[1]`, expected: `[1]`},
+		{original: `[1]`, modified: `{"a":1}`, expected: `{"a":1}`},
+		{original: `{"a":null}`, modified: `{"a":null,"b":2}`, expected: `{"b":2}`},
+		{original: `{"a":{"x":1,"y":2}}`, modified: `{"a":{"y":2,"x":1}}`, expected: `{}`},
+		{original: `{"a":1}`, modified: `{"a":null}`, err: `cannot represent nil value of map key "a" in merge patch`},
+		{original: `{}`, modified: `{"a":{"b":null}}`, err: `cannot represent nil value of map key "b" in merge patch`},
+	}
+
+	for _, test := range tests {
+		original := jsonToMsgpack(t, test.original)
+		modified := jsonToMsgpack(t, test.modified)
+
+		patch, err := CreateMergePatch(original, modified)
+		switch {
+		case test.err != "":
+			if err == nil || err.Error() != test.err {
+				t.Errorf("unexpected error for %s: %v", test.modified, err)
+			}
+			continue
+		case err != nil:
+			t.Errorf("unexpected error for %s: %v", test.modified, err)
+			continue
+		}
+
+		if s := msgpackToJSON(t, patch); s != test.expected {
+			t.Errorf("unexpected patch for %s: %s", test.modified, s)
+		}
+		if res, err := MergePatch(original, patch); err != nil {
+			t.Errorf("unexpected merge error for %s: %v", test.modified, err)
+		} else if eq, _ := EqualWithOptions(res, modified, EqualOptions{IgnoreMapOrder: true}); !eq {
+			t.Errorf("unexpected merge result for %s: %s", test.modified, msgpackToJSON(t, res))
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package msgpack
+
+import "fmt"
+
+// Patch holds a list of operations which modify a document. It follows the
+// JSON Patch format (RFC 6902): a patch is encoded as an array of maps with
+// the string keys "op", "path", "from" and "value", where paths are JSON
+// Pointers (RFC 6901). Hence, a JSON Patch document converted with
+// CopyFromJSON can be applied to MessagePack documents directly.
+//
+// The "test" operation compares values semantically like Equal does, but
+// regardless of the order of map entries.
+//
+// Patch implements the Encoder and Decoder interfaces.
+type Patch []PatchOp
+
+// PatchOp holds a single operation of a patch.
+type PatchOp struct {
+	Op    string // "add", "remove", "replace", "move", "copy" or "test"
+	Path  string // JSON Pointer to the target location
+	From  string // JSON Pointer to the source location of "move" and "copy"
+	Value Raw    // encoded value of "add", "replace" and "test"
+}
+
+// PatchError is returned if an operation of a patch cannot be applied.
+type PatchError struct {
+	Index int    // index of the failed operation within the patch
+	Op    string // name of the failed operation
+	Err   error
+}
+
+// Error returns the error message of the error.
+func (e PatchError) Error() string {
+	return fmt.Sprintf("patch operation %d (%s): %v", e.Index, e.Op, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e PatchError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyPatch applies the MessagePack encoded patch to the MessagePack encoded
+// document doc and returns the modified document. See Patch for the format.
+func ApplyPatch(doc, patch []byte) ([]byte, error) {
+	var p Patch
+	if err := Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	return p.Apply(doc)
+}
+
+// Apply applies all operations of p in order to the MessagePack encoded
+// document doc and returns the modified document. If an operation fails, a
+// PatchError is returned and none of the operations take effect. doc is
+// never modified.
+func (p Patch) Apply(doc []byte) ([]byte, error) {
+	for i, op := range p {
+		res, err := op.apply(doc)
+		if err != nil {
+			return nil, PatchError{Index: i, Op: op.Op, Err: err}
+		}
+		doc = res
+	}
+	return doc, nil
+}
+
+// EncodeMsgpack implements the Encoder interface.
+func (p Patch) EncodeMsgpack(w *Writer) error {
+	if err := w.WriteArrayHeader(len(p)); err != nil {
+		return err
+	}
+	for _, op := range p {
+		if err := op.encode(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecodeMsgpack implements the Decoder interface.
+func (p *Patch) DecodeMsgpack(r *Reader) error {
+	n, err := r.ReadArrayHeader()
+	if err != nil {
+		return err
+	}
+
+	*p = make(Patch, 0, min(n, maxPrealloc))
+	for i := 0; i < n; i++ {
+		var op PatchOp
+		if err := op.decode(r); err != nil {
+			return unexpectedEOF(err)
+		}
+		*p = append(*p, op)
+	}
+	return nil
+}
+
+func (op PatchOp) encode(w *Writer) error {
+	hasFrom := op.Op == "move" || op.Op == "copy"
+	hasValue := op.Value != nil
+
+	n := 2
+	if hasFrom {
+		n++
+	}
+	if hasValue {
+		n++
+	}
+
+	if err := w.WriteMapHeader(n); err != nil {
+		return err
+	}
+	fields := []string{"op", op.Op, "path", op.Path}
+	if hasFrom {
+		fields = append(fields, "from", op.From)
+	}
+	for _, s := range fields {
+		if err := w.WriteString(s); err != nil {
+			return err
+		}
+	}
+	if hasValue {
+		if err := w.WriteString("value"); err != nil {
+			return err
+		}
+		return w.WriteRaw(op.Value)
+	}
+	return nil
+}
+
+func (op *PatchOp) decode(r *Reader) error {
+	n, err := r.ReadMapHeader()
+	if err != nil {
+		return err
+	}
+
+	var hasOp, hasPath bool
+	for i := 0; i < n; i++ {
+		key, err := r.ReadString()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		switch key {
+		case "op":
+			op.Op, err = r.ReadString()
+			hasOp = true
+		case "path":
+			op.Path, err = r.ReadString()
+			hasPath = true
+		case "from":
+			op.From, err = r.ReadString()
+		case "value":
+			op.Value, err = r.ReadRaw(nil)
+		default:
+			err = r.Skip()
+		}
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+
+	if !hasOp || !hasPath {
+		return errorString("patch operation requires op and path")
+	}
+	return nil
+}
+
+func (op PatchOp) apply(doc []byte) ([]byte, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		if op.Value == nil {
+			return nil, errorString("missing value")
+		}
+		return patchAdd(doc, path, op.Value)
+
+	case "remove":
+		return Delete(doc, path)
+
+	case "replace":
+		if op.Value == nil {
+			return nil, errorString("missing value")
+		}
+		if _, err := Get(doc, path...); err != nil {
+			return nil, err
+		}
+		return Set(doc, path, op.Value)
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		v, err := Get(doc, from...)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if op.From == op.Path {
+				return doc, nil
+			}
+			if isPathPrefix(from, path) {
+				return nil, errorString("cannot move a value into itself")
+			}
+			if doc, err = Delete(doc, from); err != nil {
+				return nil, err
+			}
+		}
+		return patchAdd(doc, path, v)
+
+	case "test":
+		if op.Value == nil {
+			return nil, errorString("missing value")
+		}
+		v, err := Get(doc, path...)
+		if err != nil {
+			return nil, err
+		}
+		var actual, expected Value
+		if err := unmarshalValue(v, &actual); err != nil {
+			return nil, err
+		}
+		if err := unmarshalValue(op.Value, &expected); err != nil {
+			return nil, err
+		}
+		if !(EqualOptions{IgnoreMapOrder: true}).equal(actual, expected) {
+			return nil, errorf("test failed: %s != %s", formatValue(actual), formatValue(expected))
+		}
+		return doc, nil
+
+	default:
+		return nil, errorf("invalid operation %q", op.Op)
+	}
+}
+
+// patchAdd adds the value v at path. If path selects an array element, v is
+// inserted before this element, where "-" selects the end of the array. If
+// path selects a map entry, its value is replaced or a new entry with a
+// string key is added.
+func patchAdd(doc []byte, path Path, v Raw) ([]byte, error) {
+	enc, err := encodeElement(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(path) == 0 {
+		return enc, nil
+	}
+
+	c, err := locateContainer(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	elem := path[len(path)-1]
+	if c.isMap {
+		return c.set(doc, elem, enc)
+	}
+
+	switch idx := elem.(type) {
+	case int:
+		if idx > c.n {
+			return nil, errorf("array index %d out of range", idx)
+		}
+		return c.insert(doc, idx, enc)
+	case string:
+		if idx == "-" {
+			return c.insert(doc, c.n, enc)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// parsePointer parses the JSON Pointer s.
+func parsePointer(s string) (Path, error) {
+	if s != "" && s[0] != '/' {
+		return nil, errorf("invalid JSON pointer %q", s)
+	}
+	return ParsePath(s)
+}
+
+// isPathPrefix reports whether prefix is a proper prefix of path.
+func isPathPrefix(prefix, path Path) bool {
+	if len(prefix) >= len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,202 @@
+package msgpack
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		doc      string
+		patch    string
+		expected string
+		err      string
+	}{
+		// examples from RFC 6902, appendix A
+		{
+			doc:      `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/baz","value":"qux"}]`,
+			expected: `{"foo":"bar","baz":"qux"}`,
+		},
+		{
+			doc:      `{"foo":["bar","baz"]}`,
+			patch:    `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			expected: `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			doc:      `{"baz":"qux","foo":"bar"}`,
+			patch:    `[{"op":"remove","path":"/baz"}]`,
+			expected: `{"foo":"bar"}`,
+		},
+		{
+			doc:      `{"foo":["bar","qux","baz"]}`,
+			patch:    `[{"op":"remove","path":"/foo/1"}]`,
+			expected: `{"foo":["bar","baz"]}`,
+		},
+		{
+			doc:      `{"baz":"qux","foo":"bar"}`,
+			patch:    `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			expected: `{"baz":"boo","foo":"bar"}`,
+		},
+		{
+			doc:      `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch:    `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			expected: `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{
+			doc:      `{"foo":["all","grass","cows","eat"]}`,
+			patch:    `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			expected: `{"foo":["all","cows","eat","grass"]}`,
+		},
+		{
+			doc:      `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch:    `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2.0}]`,
+			expected: `{"baz":"qux","foo":["a",2,"c"]}`,
+		},
+		{
+			doc:   `{"baz":"qux"}`,
+			patch: `[{"op":"test","path":"/baz","value":"bar"}]`,
+			err:   `patch operation 0 (test): test failed: "qux" != "bar"`,
+		},
+		{
+			doc:      `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			expected: `{"foo":"bar","child":{"grandchild":{}}}`,
+		},
+		{
+			doc:      `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			expected: `{"foo":"bar","baz":"qux"}`,
+		},
+		{
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			err:   `patch operation 0 (add): path not found`,
+		},
+		{
+			doc:      `{"/":9,"~1":10}`,
+			patch:    `[{"op":"test","path":"/~01","value":10}]`,
+			expected: `{"/":9,"~1":10}`,
+		},
+		{
+			doc:      `{"foo":["bar"]}`,
+			patch:    `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			expected: `{"foo":["bar",["abc","def"]]}`,
+		},
+
+		// additional cases
+		{
+			doc:      `{"foo":{"bar":1}}`,
+			patch:    `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"replace","path":"/baz/bar","value":2}]`,
+			expected: `{"foo":{"bar":1},"baz":{"bar":2}}`,
+		},
+		{
+			doc:      `{"foo":1}`,
+			patch:    `[{"op":"add","path":"/0","value":2},{"op":"replace","path":"","value":[]},{"op":"add","path":"/0","value":3}]`,
+			expected: `[3]`,
+		},
+		{
+			doc:      `{"foo":{"a":1,"b":[{"c":2,"d":3}]}}`,
+			patch:    `[{"op":"test","path":"/foo","value":{"b":[{"d":3,"c":2}],"a":1}}]`,
+			expected: `{"foo":{"a":1,"b":[{"c":2,"d":3}]}}`,
+		},
+		{
+			doc:   `{"foo":{"bar":1}}`,
+			patch: `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`,
+			err:   `patch operation 0 (move): cannot move a value into itself`,
+		},
+		{
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"remove","path":"/foo"},{"op":"replace","path":"/foo","value":1}]`,
+			err:   `patch operation 1 (replace): path not found`,
+		},
+		{
+			doc:   `["a"]`,
+			patch: `[{"op":"add","path":"/2","value":1}]`,
+			err:   `patch operation 0 (add): array index 2 out of range`,
+		},
+		{
+			doc:   `{}`,
+			patch: `[{"op":"add","path":"foo","value":1}]`,
+			err:   `patch operation 0 (add): invalid JSON pointer "foo"`,
+		},
+		{
+			doc:   `{}`,
+			patch: `[{"op":"rename","path":"/foo"}]`,
+			err:   `patch operation 0 (rename): invalid operation "rename"`,
+		},
+		{
+			doc:   `{}`,
+			patch: `[{"path":"/foo","value":1}]`,
+			err:   `patch operation requires op and path`,
+		},
+	}
+
+	for _, test := range tests {
+		doc := jsonToMsgpack(t, test.doc)
+		patch := jsonToMsgpack(t, test.patch)
+
+		res, err := ApplyPatch(doc, patch)
+		switch {
+		case test.err != "":
+			if err == nil || err.Error() != test.err {
+				t.Errorf("unexpected error for %s: %v", test.patch, err)
+			}
+		case err != nil:
+			t.Errorf("unexpected error for %s: %v", test.patch, err)
+		default:
+			if s := msgpackToJSON(t, res); s != test.expected {
+				t.Errorf("unexpected result for %s: %s", test.patch, s)
+			}
+		}
+	}
+}
+
+func TestPatchRoundtrip(t *testing.T) {
+	patch := Patch{
+		{Op: "add", Path: "/foo", Value: Raw{posFixintTag(1)}},
+		{Op: "remove", Path: "/bar"},
+		{Op: "copy", Path: "/baz", From: "/foo"},
+	}
+
+	data, err := Marshal(patch)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `[{"op":"add","path":"/foo","value":1},{"op":"remove","path":"/bar"},{"op":"copy","path":"/baz","from":"/foo"}]`
+	if s := msgpackToJSON(t, data); s != expected {
+		t.Errorf("unexpected encoding: %s", s)
+	}
+
+	var decoded Patch
+	if err := Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(decoded, patch) {
+		t.Errorf("unexpected patch: %#v", decoded)
+	}
+
+	_, err = patch.Apply(jsonToMsgpack(t, `{}`))
+	var perr PatchError
+	if !errors.As(err, &perr) || perr.Index != 1 || !errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func jsonToMsgpack(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	if _, err := CopyFromJSON(&buf, strings.NewReader(s)); err != nil {
+		t.Fatalf("unexpected error for %s: %v", s, err)
+	}
+	return buf.Bytes()
+}
+
+func msgpackToJSON(t *testing.T, p []byte) string {
+	var sb strings.Builder
+	if _, err := CopyToJSON(&sb, bytes.NewReader(p)); err != nil {
+		t.Fatalf("unexpected error for %x: %v", p, err)
+	}
+	return strings.TrimSpace(sb.String())
+}
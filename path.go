@@ -0,0 +1,383 @@
+package msgpack
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is returned if a path does not exist in a document.
+var ErrNotFound error = errorString("path not found")
+
+// Path specifies the location of a value within a document. Each element
+// is either a string, which selects the value of a map entry by its key, or
+// an int, which selects an array element by its index. An int also selects
+// the value of a map entry whose key is this integer or its decimal string
+// representation.
+type Path []interface{}
+
+// ParsePath parses a path in dot notation (e.g. "users.3.name") or as a JSON
+// Pointer (e.g. "/users/3/name"). Elements which consist of decimal digits
+// only are parsed as int. An empty string denotes the whole document.
+func ParsePath(s string) (Path, error) {
+	if s == "" {
+		return Path{}, nil
+	}
+
+	var elems []string
+	if s[0] == '/' {
+		elems = strings.Split(s[1:], "/")
+		for i, e := range elems {
+			if strings.Contains(e, "~") {
+				e = strings.ReplaceAll(e, "~1", "/")
+				e = strings.ReplaceAll(e, "~0", "~")
+				elems[i] = e
+			}
+		}
+	} else {
+		elems = strings.Split(s, ".")
+		for _, e := range elems {
+			if e == "" {
+				return nil, errorf("invalid path %q", s)
+			}
+		}
+	}
+
+	path := make(Path, len(elems))
+	for i, e := range elems {
+		if idx, ok := parseIndex(e); ok {
+			path[i] = idx
+		} else {
+			path[i] = e
+		}
+	}
+	return path, nil
+}
+
+// String returns the path as a JSON Pointer.
+func (p Path) String() string {
+	var sb strings.Builder
+	for _, e := range p {
+		sb.WriteByte('/')
+		switch e := e.(type) {
+		case string:
+			e = strings.ReplaceAll(e, "~", "~0")
+			sb.WriteString(strings.ReplaceAll(e, "/", "~1"))
+		case int:
+			sb.WriteString(strconv.Itoa(e))
+		}
+	}
+	return sb.String()
+}
+
+func (p Path) validate() error {
+	for _, e := range p {
+		switch e := e.(type) {
+		case string:
+		case int:
+			if e < 0 {
+				return errorf("invalid path index %d", e)
+			}
+		default:
+			return errorf("invalid path element of type %T", e)
+		}
+	}
+	return nil
+}
+
+// parseIndex parses s as an index, if it consists of decimal digits only.
+func parseIndex(s string) (int, bool) {
+	if s == "" || (len(s) > 1 && s[0] == '0') {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+	}
+	idx, err := strconv.Atoi(s)
+	return idx, err == nil
+}
+
+// Get returns the encoding of the value at path within the MessagePack
+// encoded document raw, without decoding any unrelated values. The
+// returned slice refers to raw. If the path does not exist, ErrNotFound is
+// returned.
+func Get(raw []byte, path ...interface{}) (Raw, error) {
+	res, err := GetMany(raw, Path(path))
+	if err != nil {
+		return nil, err
+	} else if res[0] == nil {
+		return nil, ErrNotFound
+	}
+	return res[0], nil
+}
+
+// GetMany returns the encodings of the values at the given paths within the
+// MessagePack encoded document raw. All paths are resolved in a single
+// scan of the document. For paths which do not exist, the result is nil.
+// The returned slices refer to raw.
+func GetMany(raw []byte, paths ...Path) ([]Raw, error) {
+	for _, p := range paths {
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	spans, err := locate(raw, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Raw, len(paths))
+	for i, sp := range spans {
+		if sp.found() {
+			res[i] = raw[sp.start:sp.end]
+		}
+	}
+	return res, nil
+}
+
+// GetInt returns the integer value at path. See Get for details.
+func GetInt(raw []byte, path ...interface{}) (int64, error) {
+	v, err := Get(raw, path...)
+	if err != nil {
+		return 0, err
+	}
+	return NewReaderBytes(v).ReadInt64()
+}
+
+// GetUint returns the unsigned integer value at path. See Get for details.
+func GetUint(raw []byte, path ...interface{}) (uint64, error) {
+	v, err := Get(raw, path...)
+	if err != nil {
+		return 0, err
+	}
+	return NewReaderBytes(v).ReadUint64()
+}
+
+// GetFloat returns the floating-point value at path. See Get for details.
+func GetFloat(raw []byte, path ...interface{}) (float64, error) {
+	v, err := Get(raw, path...)
+	if err != nil {
+		return 0, err
+	}
+	return NewReaderBytes(v).ReadFloat64()
+}
+
+// GetBool returns the boolean value at path. See Get for details.
+func GetBool(raw []byte, path ...interface{}) (bool, error) {
+	v, err := Get(raw, path...)
+	if err != nil {
+		return false, err
+	}
+	return NewReaderBytes(v).ReadBool()
+}
+
+// GetString returns the string value at path. See Get for details.
+func GetString(raw []byte, path ...interface{}) (string, error) {
+	v, err := Get(raw, path...)
+	if err != nil {
+		return "", err
+	}
+	return NewReaderBytes(v).ReadString()
+}
+
+// span specifies the location of an encoded value within a document.
+type span struct {
+	start int
+	end   int
+}
+
+func (sp span) found() bool {
+	return sp.end > sp.start
+}
+
+// locate returns the locations of the values at the given paths, which need
+// to be valid.
+func locate(raw []byte, paths []Path) ([]span, error) {
+	active := make([]int, len(paths))
+	for i := range paths {
+		active[i] = i
+	}
+
+	s := pathScanner{
+		r:     NewReaderBytes(raw),
+		paths: paths,
+		spans: make([]span, len(paths)),
+	}
+	if err := s.scan(active, 0, false); err != nil {
+		return nil, err
+	}
+	return s.spans, nil
+}
+
+// pathScanner resolves several paths in a single scan of a document.
+type pathScanner struct {
+	r     *Reader
+	paths []Path
+	spans []span
+}
+
+// scan resolves the paths with the indexes in active, which all match the
+// location of the next value up to depth. If needEnd is set, the next value
+// is read completely, even if all paths have been resolved.
+func (s *pathScanner) scan(active []int, depth int, needEnd bool) error {
+	start := int(s.r.offset)
+
+	var ending, deeper []int
+	for _, i := range active {
+		if len(s.paths[i]) == depth {
+			ending = append(ending, i)
+		} else {
+			deeper = append(deeper, i)
+		}
+	}
+	needEnd = needEnd || len(ending) != 0
+
+	var err error
+	switch typ, _ := s.r.Peek(); {
+	case len(deeper) == 0:
+		err = s.r.Skip()
+	case typ == Array:
+		err = s.scanArray(deeper, depth, needEnd)
+	case typ == Map:
+		err = s.scanMap(deeper, depth, needEnd)
+	default:
+		err = s.r.Skip()
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, i := range ending {
+		s.spans[i] = span{start: start, end: int(s.r.offset)}
+	}
+	return nil
+}
+
+func (s *pathScanner) scanArray(active []int, depth int, needEnd bool) error {
+	n, err := s.r.ReadArrayHeader()
+	if err != nil {
+		return err
+	}
+
+	var sub []int
+	for j := 0; j < n; j++ {
+		if !needEnd && s.resolved() {
+			return nil
+		}
+
+		sub = sub[:0]
+		for _, i := range active {
+			if idx, ok := s.paths[i][depth].(int); ok && idx == j && !s.spans[i].found() {
+				sub = append(sub, i)
+			}
+		}
+		if len(sub) == 0 {
+			err = s.r.Skip()
+		} else {
+			err = s.scan(sub, depth+1, needEnd)
+		}
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+	return nil
+}
+
+func (s *pathScanner) scanMap(active []int, depth int, needEnd bool) error {
+	n, err := s.r.ReadMapHeader()
+	if err != nil {
+		return err
+	}
+
+	var sub []int
+	for j := 0; j < n; j++ {
+		if !needEnd && s.resolved() {
+			return nil
+		}
+
+		key, err := readPathKey(s.r)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		sub = sub[:0]
+		for _, i := range active {
+			if !s.spans[i].found() && key.matches(s.paths[i][depth]) {
+				sub = append(sub, i)
+			}
+		}
+		if len(sub) == 0 {
+			err = s.r.Skip()
+		} else {
+			err = s.scan(sub, depth+1, needEnd)
+		}
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+	return nil
+}
+
+// resolved reports whether all paths have been resolved. Only then can the
+// scan stop early, because the position within enclosing containers would
+// otherwise be lost.
+func (s *pathScanner) resolved() bool {
+	for _, sp := range s.spans {
+		if !sp.found() {
+			return false
+		}
+	}
+	return true
+}
+
+// pathKey holds a map key which can be matched against path elements.
+type pathKey struct {
+	str   []byte
+	isStr bool
+	num   int64
+	isNum bool
+}
+
+func (k pathKey) matches(elem interface{}) bool {
+	switch elem := elem.(type) {
+	case string:
+		return k.isStr && string(k.str) == elem
+	case int:
+		if k.isNum {
+			return k.num == int64(elem)
+		}
+		return k.isStr && string(k.str) == strconv.Itoa(elem)
+	default:
+		return false
+	}
+}
+
+// readPathKey reads a map key. Keys which are neither strings nor integers
+// are skipped and never match any path element.
+func readPathKey(r *Reader) (pathKey, error) {
+	typ, err := r.Peek()
+	if err != nil {
+		return pathKey{}, err
+	}
+
+	var k pathKey
+	switch typ {
+	case String:
+		k.str, err = r.readStringNoCopy()
+		k.isStr = true
+	case Int:
+		k.num, err = r.ReadInt64()
+		k.isNum = true
+	case Uint:
+		var u uint64
+		if u, err = r.ReadUint64(); err == nil && u <= math.MaxInt64 {
+			k.num, k.isNum = int64(u), true
+		}
+	default:
+		err = r.Skip()
+	}
+	return k, err
+}
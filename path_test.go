@@ -0,0 +1,159 @@
+package msgpack
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected Path
+		str      string
+	}{
+		{path: "", expected: Path{}, str: ""},
+		{path: "users.3.name", expected: Path{"users", 3, "name"}, str: "/users/3/name"},
+		{path: "/users/3/name", expected: Path{"users", 3, "name"}, str: "/users/3/name"},
+		{path: "/a~1b/~0c/03/", expected: Path{"a/b", "~c", "03", ""}, str: "/a~1b/~0c/03/"},
+		{path: "0", expected: Path{0}, str: "/0"},
+	}
+
+	for _, test := range tests {
+		p, err := ParsePath(test.path)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", test.path, err)
+		} else if !reflect.DeepEqual(p, test.expected) {
+			t.Errorf("unexpected path for %q: %#v", test.path, p)
+		} else if s := p.String(); s != test.str {
+			t.Errorf("unexpected string for %q: %q", test.path, s)
+		}
+	}
+
+	if _, err := ParsePath("a..b"); err == nil || err.Error() != `invalid path "a..b"` {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGet(t *testing.T) {
+	doc := testDocument(t)
+
+	tests := []struct {
+		path     string
+		expected []byte
+	}{
+		{path: "", expected: doc},
+		{path: "name", expected: []byte{fixstrTag(3), 'f', 'o', 'o'}},
+		{path: "users.1.name", expected: []byte{fixstrTag(3), 'b', 'o', 'b'}},
+		{path: "/users/1/tags", expected: []byte{fixarrayTag(2), fixstrTag(1), 'x', fixstrTag(1), 'y'}},
+		{path: "users.1.tags.1", expected: []byte{fixstrTag(1), 'y'}},
+		{path: "ids.7", expected: []byte{fixstrTag(5), 's', 'e', 'v', 'e', 'n'}},
+		{path: "ids.8", expected: []byte{fixstrTag(5), 'e', 'i', 'g', 'h', 't'}},
+		{path: "users.2"},
+		{path: "users.name"},
+		{path: "name.0"},
+		{path: "missing"},
+	}
+
+	for _, test := range tests {
+		p, err := ParsePath(test.path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		raw, err := Get(doc, p...)
+		switch {
+		case test.expected == nil && err != ErrNotFound:
+			t.Errorf("unexpected error for %q: %v", test.path, err)
+		case test.expected != nil && err != nil:
+			t.Errorf("unexpected error for %q: %v", test.path, err)
+		case !bytes.Equal(raw, test.expected):
+			t.Errorf("unexpected value for %q: %x", test.path, raw)
+		}
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	doc := testDocument(t)
+	res, err := GetMany(doc, Path{"users", 0, "name"}, Path{"missing"}, Path{"users", 1}, Path{"users", 1, "name"}, Path{"name"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := make([]string, len(res))
+	for i, raw := range res {
+		if raw != nil {
+			var v Value
+			if err := UnmarshalValue(raw, &v); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			names[i] = v.Str() + v.Get("name").Str()
+		}
+	}
+	if expected := []string{"alice", "", "bob", "bob", "foo"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("unexpected results: %q", names)
+	}
+
+	// Resolving a path must not stop the scan of the enclosing containers,
+	// which contain the values of other paths.
+	doc, err = Marshal(MapValue(
+		KeyValue{Key: StringValue("a"), Value: ArrayValue(UintValue(1), UintValue(2))},
+		KeyValue{Key: StringValue("b"), Value: UintValue(7)},
+	))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, err = GetMany(doc, Path{"a", 0}, Path{"b"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(res) != 2 || !bytes.Equal(res[0], []byte{0x01}) || !bytes.Equal(res[1], []byte{0x07}) {
+		t.Errorf("unexpected results for paths in different subtrees: %x", res)
+	}
+
+	if _, err := GetMany(doc, Path{1.5}); err == nil || err.Error() != "invalid path element of type float64" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGetTyped(t *testing.T) {
+	doc := testDocument(t)
+
+	if s, err := GetString(doc, "users", 0, "name"); err != nil || s != "alice" {
+		t.Errorf("unexpected string: %q (error: %v)", s, err)
+	}
+	if i, err := GetInt(doc, "users", 0, "age"); err != nil || i != 31 {
+		t.Errorf("unexpected int: %d (error: %v)", i, err)
+	}
+	if u, err := GetUint(doc, "users", 1, "age"); err != nil || u != 27 {
+		t.Errorf("unexpected uint: %d (error: %v)", u, err)
+	}
+	if f, err := GetFloat(doc, "score"); err != nil || f != 1.5 {
+		t.Errorf("unexpected float: %v (error: %v)", f, err)
+	}
+	if b, err := GetBool(doc, "active"); err != nil || !b {
+		t.Errorf("unexpected bool: %v (error: %v)", b, err)
+	}
+	if _, err := GetInt(doc, "name"); err == nil {
+		t.Errorf("expected type error")
+	}
+	if _, err := Get(doc[:len(doc)-3], "missing"); err == nil || err.Error() != "unexpected EOF" {
+		t.Errorf("unexpected error for truncated document: %v", err)
+	}
+}
+
+func testDocument(t *testing.T) []byte {
+	doc, err := MarshalValue(map[string]interface{}{
+		"name": "foo",
+		"users": []interface{}{
+			map[string]interface{}{"name": "alice", "age": 31},
+			map[string]interface{}{"name": "bob", "age": 27, "tags": []string{"x", "y"}},
+		},
+		"ids":    map[interface{}]interface{}{7: "seven", "8": "eight"},
+		"score":  1.5,
+		"active": true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return doc
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"io"
 	"math"
+	"reflect"
 	"sync"
 	"time"
 )
@@ -17,23 +18,57 @@ const (
 
 var readerPool sync.Pool
 
+// ReaderOptions defines limits which are enforced by a Reader. They protect
+// against excessive memory and stack usage when reading data from untrusted
+// sources. A zero value means that the respective limit is not enforced.
+// Whenever a limit is exceeded, a LimitError is returned.
+type ReaderOptions struct {
+	// MaxStringLength is the maximum number of bytes of a string value.
+	MaxStringLength int
+	// MaxBinaryLength is the maximum number of bytes of a binary value.
+	MaxBinaryLength int
+	// MaxExtLength is the maximum number of data bytes of an extension value.
+	MaxExtLength int
+	// MaxCollectionLength is the maximum number of elements of an array
+	// or the maximum number of key-value pairs of a map.
+	MaxCollectionLength int
+	// MaxDepth is the maximum nesting depth of arrays and maps. A top-level
+	// array or map has a depth of 1.
+	MaxDepth int
+	// MaxMessageSize is the maximum number of bytes of a top-level value
+	// including all of its nested values.
+	MaxMessageSize int
+}
+
 // Reader defines a reader for MessagePack encoded data.
 type Reader struct {
-	r     io.Reader
-	buf   []byte
-	first int
-	last  int
-	err   error
+	r      io.Reader
+	buf    []byte
+	first  int
+	last   int
+	err    error
+	offset int64 // number of bytes consumed
+	opts   ReaderOptions
+
+	// The currently open arrays and maps, innermost last. Exhausted
+	// containers are closed lazily when the next value is read.
+	frames   []frame
+	msgStart int64 // offset of the current top-level value
+
+	walkStack []frame // scratch space for walk
+}
+
+// frame describes an open array or map.
+type frame struct {
+	remaining int // number of values remaining, keys and values counted separately
+	isMap     bool
 }
 
 // NewReader creates a reader for MessagePack encoded data read from r.
 func NewReader(r io.Reader) *Reader {
 	if v := readerPool.Get(); v != nil {
 		reader := v.(*Reader)
-		reader.r = r
-		reader.first = 0
-		reader.last = 0
-		reader.err = nil
+		reader.Reset(r)
 		return reader
 	}
 
@@ -54,8 +89,36 @@ func NewReaderBytes(p []byte) *Reader {
 	}
 }
 
+// Reset discards any buffered data and resets the reader to read from rd.
+func (r *Reader) Reset(rd io.Reader) {
+	// A reader created with NewReaderBytes uses the caller's slice as
+	// its buffer, which must not be overwritten.
+	if _, ok := r.r.(eofReader); ok {
+		r.buf = make([]byte, 1024)
+	}
+
+	r.r = rd
+	r.first = 0
+	r.last = 0
+	r.err = nil
+	r.offset = 0
+	r.frames = r.frames[:0]
+	r.msgStart = 0
+}
+
+// SetOptions sets the limits which are enforced by the reader.
+func (r *Reader) SetOptions(opts ReaderOptions) {
+	r.opts = opts
+}
+
+// Options returns the limits which are enforced by the reader.
+func (r *Reader) Options() ReaderOptions {
+	return r.opts
+}
+
 func releaseReader(r *Reader) {
 	r.r = nil
+	r.opts = ReaderOptions{}
 	readerPool.Put(r)
 }
 
@@ -71,6 +134,7 @@ func (r *Reader) Peek() (Type, error) {
 
 // ReadNil reads a nil value from the MessagePack stream.
 func (r *Reader) ReadNil() error {
+	r.beginValue()
 	tag, err := r.peek()
 	switch {
 	case err != nil:
@@ -79,12 +143,14 @@ func (r *Reader) ReadNil() error {
 		return r.typeErr(tag, Nil)
 	default:
 		r.advance(1)
+		r.endValue()
 		return nil
 	}
 }
 
 // ReadBool reads a boolean value from the MessagePack stream.
 func (r *Reader) ReadBool() (bool, error) {
+	r.beginValue()
 	tag, err := r.peek()
 	if err != nil {
 		return false, err
@@ -93,9 +159,11 @@ func (r *Reader) ReadBool() (bool, error) {
 	switch tag {
 	case tagNil, tagFalse:
 		r.advance(1)
+		r.endValue()
 		return false, nil
 	case tagTrue:
 		r.advance(1)
+		r.endValue()
 		return true, nil
 	default:
 		return false, r.typeErr(tag, Bool)
@@ -143,6 +211,15 @@ func (r *Reader) ReadInt32() (int32, error) {
 
 // ReadInt64 reads a 64-bit integer value from the MessagePack stream.
 func (r *Reader) ReadInt64() (int64, error) {
+	r.beginValue()
+	i, err := r.readInt64()
+	if err == nil {
+		r.endValue()
+	}
+	return i, err
+}
+
+func (r *Reader) readInt64() (int64, error) {
 	tag, err := r.peek()
 	if err != nil {
 		return 0, err
@@ -286,6 +363,15 @@ func (r *Reader) ReadUint32() (uint32, error) {
 
 // ReadUint64 reads a 64-bit unsigned integer value from the MessagePack stream.
 func (r *Reader) ReadUint64() (uint64, error) {
+	r.beginValue()
+	ui, err := r.readUint64()
+	if err == nil {
+		r.endValue()
+	}
+	return ui, err
+}
+
+func (r *Reader) readUint64() (uint64, error) {
 	tag, err := r.peek()
 	if err != nil {
 		return 0, err
@@ -409,6 +495,15 @@ func (r *Reader) ReadFloat32() (float32, error) {
 
 // ReadFloat64 reads a 64-bit floating-point value from the MessagePack stream.
 func (r *Reader) ReadFloat64() (float64, error) {
+	r.beginValue()
+	f, err := r.readFloat64()
+	if err == nil {
+		r.endValue()
+	}
+	return f, err
+}
+
+func (r *Reader) readFloat64() (float64, error) {
 	tag, err := r.peek()
 	if err != nil {
 		return 0, err
@@ -517,7 +612,7 @@ func (r *Reader) ReadMapHeader() (int, error) {
 // ReadRaw reads the next value from the MessagePack stream into raw.
 func (r *Reader) ReadRaw(raw Raw) (Raw, error) {
 	raw = raw[:0]
-	err := r.readRaw(func(p []byte) { raw = append(raw, p...) })
+	err := r.readValueRaw(func(p []byte) { raw = append(raw, p...) })
 	return raw, err
 }
 
@@ -531,6 +626,31 @@ func (r *Reader) ReadExt(typ int8, v encoding.BinaryUnmarshaler) error {
 	return v.UnmarshalBinary(data)
 }
 
+// ReadExtAny reads an extension value of any type from the MessagePack
+// stream. It returns the extension type together with a copy of the
+// extension data.
+func (r *Reader) ReadExtAny() (int8, []byte, error) {
+	r.beginValue()
+	typ, headerLen, n, err := r.peekExtension()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	data, err := r.readExtensionData(headerLen, n)
+	if err != nil {
+		return 0, nil, err
+	}
+	return typ, append([]byte{}, data...), nil
+}
+
+// PeekExtType returns the extension type of the next value without moving
+// the read pointer. If the next value is not an extension, a TypeError is
+// returned.
+func (r *Reader) PeekExtType() (int8, error) {
+	typ, _, _, err := r.peekExtension()
+	return typ, err
+}
+
 // ReadTime reads a time value from the MessagePack stream.
 func (r *Reader) ReadTime() (time.Time, error) {
 	data, err := r.readExtension(extTime)
@@ -538,6 +658,12 @@ func (r *Reader) ReadTime() (time.Time, error) {
 		return time.Time{}, err
 	}
 
+	return parseTimestamp(data)
+}
+
+// parseTimestamp parses the data of a timestamp extension value in any of
+// the three timestamp layouts.
+func parseTimestamp(data []byte) (time.Time, error) {
 	switch len(data) {
 	case 4: // 32-bit seconds
 		seconds := binary.BigEndian.Uint32(data)
@@ -554,17 +680,64 @@ func (r *Reader) ReadTime() (time.Time, error) {
 	}
 }
 
+// ReadValue reads the next value from the MessagePack stream into the value
+// pointed to by v. If v implements Decoder, its DecodeMsgpack method is used.
+// Otherwise the value is decoded using reflection, where every nested type
+// which implements Decoder is decoded with its own method.
+//
+// Structs are decoded from maps with string keys which match the field names
+// (see Writer.WriteValue for the supported struct tags). Unknown keys are
+// skipped. Regardless of their layout, structs can also be decoded from
+// arrays, where the elements are assigned to the fields in declaration order.
+// Missing trailing elements leave their fields untouched and surplus elements
+// are skipped. When decoding into an empty interface value, the
+// natural Go representation of the next value is stored: nil, bool, int64,
+// uint64, float64, string, []byte, time.Time, []interface{},
+// map[string]interface{} or map[interface{}]interface{}. Extension values are
+// decoded with the factory registered by RegisterExt, or into a RawExt if
+// their type is not registered. A registered extension value can also be
+// decoded into a non-empty interface type which it implements.
+func (r *Reader) ReadValue(v interface{}) error {
+	if d, ok := v.(Decoder); ok {
+		return d.DecodeMsgpack(r)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errorf("cannot decode into non-pointer type %T", v)
+	}
+	return typeDecoder(rv.Type().Elem())(r, rv.Elem())
+}
+
 // Skip skips the next value in the MessagePack stream.
 func (r *Reader) Skip() error {
-	return r.readRaw(func([]byte) {})
+	r.beginValue()
+	if err := r.walk(nil, nil); err != nil {
+		return err
+	}
+	r.endValue()
+	return nil
 }
 
 func (r *Reader) readBlobHeader(expectedType Type) (int, error) {
+	r.beginValue()
 	tag, err := r.peek()
 	if err != nil {
 		return 0, err
 	}
 
+	n, err := r.readBlobLength(tag, expectedType)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.checkBlobLength(tag, n); err != nil {
+		return 0, err
+	}
+	r.endValue()
+	return n, nil
+}
+
+func (r *Reader) readBlobLength(tag byte, expectedType Type) (int, error) {
 	switch tag {
 	case tagNil:
 		r.advance(1)
@@ -601,54 +774,73 @@ func (r *Reader) readBlobHeader(expectedType Type) (int, error) {
 }
 
 func (r *Reader) readCollectionHeader(tagBase byte, expectedTyp Type, readFix func(byte) (uint8, bool)) (int, error) {
+	r.beginValue()
 	tag, err := r.peek()
 	if err != nil {
 		return 0, err
 	}
 
-	if n, ok := readFix(tag); ok {
-		r.advance(1)
-		return int(n), nil
-	}
-
-	switch tag {
-	case tagNil:
+	var n int
+	if fix, ok := readFix(tag); ok {
 		r.advance(1)
-		return 0, nil
+		n = int(fix)
+	} else {
+		switch tag {
+		case tagNil:
+			r.advance(1)
+			r.endValue()
+			return 0, nil
+
+		case tagBase: // 16 bit
+			buf, err := r.read(3)
+			if err != nil {
+				return 0, err
+			}
+			n = int(binary.BigEndian.Uint16(buf[1:]))
 
-	case tagBase: // 16 bit
-		buf, err := r.read(3)
-		if err != nil {
-			return 0, err
-		}
-		return int(binary.BigEndian.Uint16(buf[1:])), nil
+		case tagBase + 1: // 32 bit
+			buf, err := r.read(5)
+			if err != nil {
+				return 0, err
+			}
+			u := binary.BigEndian.Uint32(buf[1:])
+			if uint(u) > uint(maxInt) {
+				return 0, intOverflowError{}
+			}
+			n = int(u)
 
-	case tagBase + 1: // 32 bit
-		buf, err := r.read(5)
-		if err != nil {
-			return 0, err
+		default:
+			return 0, r.typeErr(tag, expectedTyp)
 		}
-		n := binary.BigEndian.Uint32(buf[1:])
-		if uint(n) > uint(maxInt) {
-			return 0, intOverflowError{}
-		}
-		return int(n), nil
+	}
 
-	default:
-		return 0, r.typeErr(tag, expectedTyp)
+	r.endValue()
+	if err := r.openContainer(n, tagBase == tagMap16); err != nil {
+		return 0, err
 	}
+	return n, nil
 }
 
 func (r *Reader) readExtension(typ int8) ([]byte, error) {
-	tag, err := r.peek()
+	r.beginValue()
+	t, headerLen, n, err := r.peekExtension()
 	if err != nil {
 		return nil, err
+	} else if t != typ {
+		return nil, newInvalidExtensionError(t)
+	}
+	return r.readExtensionData(headerLen, n)
+}
+
+// peekExtension returns the extension type, the header length and the data
+// length of the next extension value without consuming it.
+func (r *Reader) peekExtension() (typ int8, headerLen int, n int, err error) {
+	tag, err := r.peek()
+	if err != nil {
+		return 0, 0, 0, err
 	}
 
-	var (
-		header []byte
-		n      int
-	)
+	var header []byte
 	switch tag {
 	case tagFixExt1:
 		header, err = r.peekn(2)
@@ -678,20 +870,94 @@ func (r *Reader) readExtension(typ int8) ([]byte, error) {
 			n = int(binary.BigEndian.Uint32(header[1:]))
 		}
 	default:
-		return nil, r.typeErr(tag, Ext)
+		return 0, 0, 0, r.typeErr(tag, Ext)
 	}
 
 	if err != nil {
+		return 0, 0, 0, err
+	}
+	return int8(header[len(header)-1]), len(header), n, nil
+}
+
+func (r *Reader) readExtensionData(headerLen, n int) ([]byte, error) {
+	if err := checkLimit("MaxExtLength", n, r.opts.MaxExtLength); err != nil {
 		return nil, err
-	} else if t := int8(header[len(header)-1]); typ != t {
-		return nil, newInvalidExtensionError(t)
 	}
 
-	data, err := r.read(len(header) + n)
+	data, err := r.read(headerLen + n)
 	if err != nil {
 		return nil, err
 	}
-	return data[len(header):], nil
+	r.endValue()
+	return data[headerLen:], nil
+}
+
+// beginValue is called before a new value is read. It closes all exhausted
+// containers and marks the start of a new top-level value if no container
+// is open.
+func (r *Reader) beginValue() {
+	n := len(r.frames)
+	for n > 0 && r.frames[n-1].remaining == 0 {
+		n--
+	}
+	r.frames = r.frames[:n]
+	if n == 0 {
+		r.msgStart = r.offset
+	}
+}
+
+// endValue is called after a value (or the header of an array or map) was
+// consumed.
+func (r *Reader) endValue() {
+	if n := len(r.frames); n > 0 {
+		r.frames[n-1].remaining--
+	}
+}
+
+// openContainer is called after the header of an array or map with n
+// elements was consumed.
+func (r *Reader) openContainer(n int, isMap bool) error {
+	if err := checkLimit("MaxCollectionLength", n, r.opts.MaxCollectionLength); err != nil {
+		return err
+	}
+	if err := checkLimit("MaxDepth", len(r.frames)+1, r.opts.MaxDepth); err != nil {
+		return err
+	}
+
+	if isMap {
+		n *= 2
+	}
+	r.frames = append(r.frames, frame{remaining: n, isMap: isMap})
+	return nil
+}
+
+func (r *Reader) checkBlobLength(tag byte, n int) error {
+	switch tag {
+	case tagBin8, tagBin16, tagBin32:
+		return checkLimit("MaxBinaryLength", n, r.opts.MaxBinaryLength)
+	default:
+		return checkLimit("MaxStringLength", n, r.opts.MaxStringLength)
+	}
+}
+
+// checkMessageSize checks whether n more bytes can be consumed without
+// exceeding the maximum message size.
+func (r *Reader) checkMessageSize(n int) error {
+	if r.opts.MaxMessageSize <= 0 {
+		return nil
+	}
+	size := r.offset - r.msgStart + int64(n)
+	if size > int64(r.opts.MaxMessageSize) {
+		return LimitError{Limit: "MaxMessageSize", Value: int(size), Max: r.opts.MaxMessageSize}
+	}
+	return nil
+}
+
+func checkLimit(name string, n, max int) error {
+	if max > 0 && n > max {
+		return LimitError{Limit: name, Value: n, Max: max}
+	}
+	return nil
 }
 
 func (r *Reader) typeErr(tag byte, expected Type) error {
@@ -737,6 +1003,11 @@ func (r *Reader) peekType(tag byte) (Type, error) {
 	return tagType(tag), nil
 }
 
+func (r *Reader) peekNil() bool {
+	tag, err := r.peek()
+	return err == nil && tag == tagNil
+}
+
 func (r *Reader) peek() (byte, error) {
 	if r.first == r.last {
 		if err := r.fillBuf(1); err != nil {
@@ -756,6 +1027,9 @@ func (r *Reader) peekn(n int) ([]byte, error) {
 }
 
 func (r *Reader) read(n int) ([]byte, error) {
+	if err := r.checkMessageSize(n); err != nil {
+		return nil, err
+	}
 	p, err := r.peekn(n)
 	if err == nil {
 		r.advance(n)
@@ -764,6 +1038,10 @@ func (r *Reader) read(n int) ([]byte, error) {
 }
 
 func (r *Reader) readFull(p []byte) error {
+	if err := r.checkMessageSize(len(p)); err != nil {
+		return err
+	}
+
 	var n int
 	if r.first != r.last {
 		n = copy(p, r.buf[r.first:r.last])
@@ -774,192 +1052,53 @@ func (r *Reader) readFull(p []byte) error {
 	}
 
 	if r.err == nil {
-		if _, r.err = io.ReadFull(r.r, p[n:]); r.err == io.EOF {
+		var m int
+		m, r.err = io.ReadFull(r.r, p[n:])
+		r.offset += int64(m)
+		if r.err == io.EOF {
 			r.err = io.ErrUnexpectedEOF
 		}
 	}
 	return r.err
 }
 
-func (r *Reader) readRaw(f func([]byte)) error {
-	tag, err := r.peek()
+// readValueRaw reads the next value from the MessagePack stream and passes
+// its encoding piece by piece to f.
+func (r *Reader) readValueRaw(f func([]byte)) error {
+	r.beginValue()
+	err := r.walk(func(_ item, p []byte) error {
+		f(p)
+		return nil
+	}, nil)
 	if err != nil {
 		return err
 	}
+	r.endValue()
+	return nil
+}
 
-	switch tag {
-	case tagNil, tagFalse, tagTrue:
-		p, err := r.read(1)
-		f(p)
-		return err
-	case tagInt8, tagUint8:
-		p, err := r.read(2)
-		f(p)
-		return err
-	case tagInt16, tagUint16:
-		p, err := r.read(3)
-		f(p)
-		return err
-	case tagInt32, tagUint32, tagFloat32:
-		p, err := r.read(5)
-		f(p)
-		return err
-	case tagInt64, tagUint64, tagFloat64:
-		p, err := r.read(9)
-		f(p)
-		return err
-	case tagStr8, tagBin8:
-		p, err := r.peekn(2)
-		if err == nil {
-			p, err = r.read(2 + int(p[1]))
-			f(p)
-		}
-		return err
-	case tagStr16, tagBin16:
-		p, err := r.peekn(3)
-		if err == nil {
-			p, err = r.read(3 + int(binary.BigEndian.Uint16(p[1:])))
-			f(p)
-		}
-		return err
-	case tagStr32, tagBin32:
-		p, err := r.peekn(5)
-		if err == nil {
-			p, err = r.read(5 + int(binary.BigEndian.Uint32(p[1:])))
-			f(p)
-		}
-		return err
-	case tagArray16:
-		p, err := r.read(3)
-		if err != nil {
-			return err
-		}
-		f(p)
-		for i, n := uint16(0), binary.BigEndian.Uint16(p[1:]); i < n; i++ {
-			if err := r.readRaw(f); err != nil {
-				return err
-			}
-		}
-		return nil
-	case tagArray32:
-		p, err := r.read(5)
-		if err != nil {
-			return err
-		}
-		f(p)
-		for i, n := uint32(0), binary.BigEndian.Uint32(p[1:]); i < n; i++ {
-			if err := r.readRaw(f); err != nil {
-				return err
-			}
-		}
-		return nil
-	case tagMap16:
-		p, err := r.read(3)
-		if err != nil {
-			return err
-		}
-		f(p)
-		for i, n := uint16(0), 2*binary.BigEndian.Uint16(p[1:]); i < n; i++ {
-			if err := r.readRaw(f); err != nil {
-				return err
-			}
-		}
-		return nil
-	case tagMap32:
-		p, err := r.read(5)
-		if err != nil {
-			return err
-		}
-		f(p)
-		for i, n := uint32(0), 2*binary.BigEndian.Uint32(p[1:]); i < n; i++ {
-			if err := r.readRaw(f); err != nil {
-				return err
-			}
-		}
-		return nil
-	case tagFixExt1:
-		p, err := r.read(3)
-		f(p)
-		return err
-	case tagFixExt2:
-		p, err := r.read(4)
-		f(p)
-		return err
-	case tagFixExt4:
-		p, err := r.read(6)
-		f(p)
-		return err
-	case tagFixExt8:
-		p, err := r.read(10)
-		f(p)
-		return err
-	case tagFixExt16:
-		p, err := r.read(18)
-		f(p)
-		return err
-	case tagExt8:
-		p, err := r.peekn(3)
-		if err == nil {
-			p, err = r.read(3 + int(p[1]))
-			f(p)
-		}
-		return err
-	case tagExt16:
-		p, err := r.peekn(4)
-		if err == nil {
-			p, err = r.read(4 + int(binary.BigEndian.Uint16(p[1:])))
-			f(p)
-		}
-		return err
-	case tagExt32:
-		p, err := r.peekn(6)
-		if err == nil {
-			p, err = r.read(6 + int(binary.BigEndian.Uint32(p[1:])))
-			f(p)
-		}
+// discard consumes the next n bytes without holding them in the buffer.
+func (r *Reader) discard(n int) error {
+	if err := r.checkMessageSize(n); err != nil {
 		return err
 	}
 
-	switch {
-	case isPosFixintTag(tag) || isNegFixintTag(tag):
-		p, err := r.read(1)
-		f(p)
-		return err
-	case isFixstrTag(tag):
-		p, err := r.read(1 + int(readFixstr(tag)))
-		f(p)
-		return err
-	case isFixarrayTag(tag):
-		p, err := r.read(1)
-		if err != nil {
-			return err
-		}
-		f(p)
-		for i, n := 0, int(readFixarray(tag)); i < n; i++ {
-			if r.readRaw(f); err != nil {
-				return err
-			}
-		}
-		return nil
-	case isFixmapTag(tag):
-		p, err := r.read(1)
-		if err != nil {
-			return err
-		}
-		f(p)
-		for i, n := 0, 2*int(readFixmap(tag)); i < n; i++ {
-			if r.readRaw(f); err != nil {
+	for n > 0 {
+		if r.first == r.last {
+			if err := r.fillBuf(1); err != nil {
 				return err
 			}
 		}
-		return nil
+		m := min(n, r.last-r.first)
+		r.advance(m)
+		n -= m
 	}
-
-	return errorf("unknown tag %#02x", tag)
+	return nil
 }
 
 func (r *Reader) advance(n int) {
 	r.first += n
+	r.offset += int64(n)
 }
 
 func (r *Reader) fillBuf(minSize int) error {
@@ -967,6 +1106,13 @@ func (r *Reader) fillBuf(minSize int) error {
 		return r.err
 	}
 
+	// A reader created with NewReaderBytes has nothing left to read and
+	// must not move the data within the caller's slice.
+	if _, ok := r.r.(eofReader); ok {
+		r.err = io.EOF
+		return r.err
+	}
+
 	if len(r.buf) < minSize {
 		buf := make([]byte, minSize)
 		n := copy(buf, r.buf[r.first:r.last])
@@ -978,12 +1124,16 @@ func (r *Reader) fillBuf(minSize int) error {
 	}
 
 	for r.last < minSize {
+		// Readers may return data together with an error, which is only
+		// reported once the data is consumed.
 		n, err := r.r.Read(r.buf[r.last:])
+		r.last += n
 		if err != nil {
 			r.err = err
-			return err
+			if r.last < minSize {
+				return err
+			}
 		}
-		r.last += n
 	}
 	return nil
 }
@@ -430,6 +430,14 @@ func TestReaderRead(t *testing.T) {
 			read:  func(r *Reader) (interface{}, error) { return r.ReadRaw(nil) },
 		},
 		// ext
+		{
+			data:  []byte{tagExt8, 0x02, 0xfe, ' ', ' '},
+			value: RawExt{Type: -2, Data: []byte("  ")},
+			read: func(r *Reader) (interface{}, error) {
+				typ, data, err := r.ReadExtAny()
+				return RawExt{Type: typ, Data: data}, err
+			},
+		},
 		{
 			data: []byte{tagFixExt1, 0xd, ' '},
 			read: func(r *Reader) (interface{}, error) { return nil, r.ReadExt(0xd, nopBinaryMarshaler{}) },
@@ -981,6 +989,157 @@ func TestReaderError(t *testing.T) {
 	}
 }
 
+func TestReaderLimits(t *testing.T) {
+	nested := func(depth int) []byte {
+		data := bytes.Repeat([]byte{fixarrayTag(1)}, depth)
+		return append(data, tagNil)
+	}
+
+	tests := []struct {
+		data []byte
+		opts ReaderOptions
+		err  string
+		read func(*Reader) (interface{}, error)
+	}{
+		// strings
+		{
+			data: []byte{tagStr32, 0xff, 0xff, 0xff, 0xff},
+			opts: ReaderOptions{MaxStringLength: 16},
+			err:  "MaxStringLength exceeded: 4294967295 > 16",
+			read: func(r *Reader) (interface{}, error) { return r.ReadString() },
+		},
+		{
+			data: []byte{tagStr32, 0xff, 0xff, 0xff, 0xff},
+			opts: ReaderOptions{MaxStringLength: 16},
+			err:  "MaxStringLength exceeded: 4294967295 > 16",
+			read: func(r *Reader) (interface{}, error) { return nil, r.Skip() },
+		},
+		{
+			data: []byte{fixstrTag(3), 'f', 'o', 'o'},
+			opts: ReaderOptions{MaxStringLength: 2},
+			err:  "MaxStringLength exceeded: 3 > 2",
+			read: func(r *Reader) (interface{}, error) { return r.ReadBytes(nil) },
+		},
+		// binary
+		{
+			data: []byte{tagBin32, 0xff, 0xff, 0xff, 0xff},
+			opts: ReaderOptions{MaxBinaryLength: 16},
+			err:  "MaxBinaryLength exceeded: 4294967295 > 16",
+			read: func(r *Reader) (interface{}, error) { return r.ReadBytesNoCopy() },
+		},
+		{
+			data: []byte{tagBin32, 0xff, 0xff, 0xff, 0xff},
+			opts: ReaderOptions{MaxBinaryLength: 16},
+			err:  "MaxBinaryLength exceeded: 4294967295 > 16",
+			read: func(r *Reader) (interface{}, error) { return r.ReadRaw(nil) },
+		},
+		// ext
+		{
+			data: []byte{tagExt32, 0xff, 0xff, 0xff, 0xff, 0x0d},
+			opts: ReaderOptions{MaxExtLength: 16},
+			err:  "MaxExtLength exceeded: 4294967295 > 16",
+			read: func(r *Reader) (interface{}, error) { return nil, r.ReadExt(0x0d, nopBinaryMarshaler{}) },
+		},
+		{
+			data: []byte{tagExt32, 0xff, 0xff, 0xff, 0xff, 0x0d},
+			opts: ReaderOptions{MaxExtLength: 16},
+			err:  "MaxExtLength exceeded: 4294967295 > 16",
+			read: func(r *Reader) (interface{}, error) { return nil, r.Skip() },
+		},
+		// collections
+		{
+			data: []byte{tagArray32, 0xff, 0xff, 0xff, 0xff},
+			opts: ReaderOptions{MaxCollectionLength: 16},
+			err:  "MaxCollectionLength exceeded: 4294967295 > 16",
+			read: func(r *Reader) (interface{}, error) { return r.ReadArrayHeader() },
+		},
+		{
+			data: []byte{tagMap32, 0xff, 0xff, 0xff, 0xff},
+			opts: ReaderOptions{MaxCollectionLength: 16},
+			err:  "MaxCollectionLength exceeded: 4294967295 > 16",
+			read: func(r *Reader) (interface{}, error) { return nil, r.Skip() },
+		},
+		// depth
+		{
+			data: nested(100000),
+			opts: ReaderOptions{MaxDepth: 32},
+			err:  "MaxDepth exceeded: 33 > 32",
+			read: func(r *Reader) (interface{}, error) { return nil, r.Skip() },
+		},
+		{
+			data: nested(100000),
+			opts: ReaderOptions{MaxDepth: 32},
+			err:  "MaxDepth exceeded: 33 > 32",
+			read: func(r *Reader) (interface{}, error) {
+				var v interface{}
+				return nil, r.ReadValue(&v)
+			},
+		},
+		{
+			data: nested(100000),
+			opts: ReaderOptions{MaxDepth: 32},
+			err:  "MaxDepth exceeded: 33 > 32",
+			read: func(r *Reader) (interface{}, error) { return CopyReaderToJSON(io.Discard, r) },
+		},
+		// message size
+		{
+			data: append([]byte{fixarrayTag(2), tagStr8, 0x08}, bytes.Repeat([]byte{' '}, 16)...),
+			opts: ReaderOptions{MaxMessageSize: 8},
+			err:  "MaxMessageSize exceeded: 11 > 8",
+			read: func(r *Reader) (interface{}, error) {
+				if _, err := r.ReadArrayHeader(); err != nil {
+					return nil, err
+				}
+				return r.ReadString()
+			},
+		},
+		{
+			data: append([]byte{fixarrayTag(2), tagStr8, 0x08}, bytes.Repeat([]byte{' '}, 16)...),
+			opts: ReaderOptions{MaxMessageSize: 8},
+			err:  "MaxMessageSize exceeded: 11 > 8",
+			read: func(r *Reader) (interface{}, error) { return r.ReadRaw(nil) },
+		},
+	}
+
+	for _, test := range tests {
+		r := NewReaderBytes(test.data)
+		r.SetOptions(test.opts)
+		_, err := test.read(r)
+		if err == nil {
+			t.Errorf("expected error %q, got none", test.err)
+		} else if _, ok := err.(LimitError); !ok {
+			t.Errorf("unexpected error type %T: %v", err, err)
+		} else if err.Error() != test.err {
+			t.Errorf("unexpected error message: %v", err)
+		}
+	}
+}
+
+func TestReaderLimitsWithinBounds(t *testing.T) {
+	// two messages: [[1], [2]] and "foo"
+	data := []byte{
+		fixarrayTag(2), fixarrayTag(1), posFixintTag(1), fixarrayTag(1), posFixintTag(2),
+		fixstrTag(3), 'f', 'o', 'o',
+	}
+
+	r := NewReader(bytes.NewReader(data))
+	r.SetOptions(ReaderOptions{MaxDepth: 2, MaxMessageSize: 5, MaxCollectionLength: 2})
+	for i := 0; i < 3; i++ {
+		n, err := r.ReadArrayHeader()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if i > 0 {
+			if x, err := r.ReadInt(); err != nil || x != i || n != 1 {
+				t.Fatalf("unexpected element: %d, %v", x, err)
+			}
+		}
+	}
+	if s, err := r.ReadString(); err != nil || s != "foo" {
+		t.Errorf("unexpected string: %q, %v", s, err)
+	}
+}
+
 func TestReaderBufferRealloc(t *testing.T) {
 	data := []byte{tagBin8, 0x03, 'f', 'o', 'o'}
 	r := NewReader(bytes.NewReader(data))
@@ -995,6 +1154,18 @@ func TestReaderBufferRealloc(t *testing.T) {
 	}
 }
 
+func TestReaderResetBytes(t *testing.T) {
+	data := []byte{posFixintTag(1)}
+	r := NewReaderBytes(data)
+	r.Reset(bytes.NewReader([]byte{posFixintTag(2)}))
+	if _, err := r.ReadInt(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data[0] != posFixintTag(1) {
+		t.Errorf("reset overwrote the caller's data: %x", data)
+	}
+}
+
 type nopBinaryMarshaler struct{}
 
 func (m nopBinaryMarshaler) UnmarshalBinary(p []byte) error {
@@ -0,0 +1,796 @@
+package msgpack
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// maxPrealloc limits the number of elements which are allocated up front
+// when decoding a collection. Larger collections grow while decoding, so
+// that a forged header cannot trigger a huge allocation.
+const maxPrealloc = 1024
+
+var (
+	encoderType = reflect.TypeOf((*Encoder)(nil)).Elem()
+	decoderType = reflect.TypeOf((*Decoder)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+
+	encoderCache sync.Map // map[reflect.Type]encodeFunc
+	decoderCache sync.Map // map[reflect.Type]decodeFunc
+)
+
+type encodeFunc func(w *Writer, v reflect.Value) error
+
+type decodeFunc func(r *Reader, v reflect.Value) error
+
+// typeEncoder returns the cached encode function for type t.
+func typeEncoder(t reflect.Type) encodeFunc {
+	if f, ok := encoderCache.Load(t); ok {
+		return f.(encodeFunc)
+	}
+
+	// Store a forwarding function first, so that recursive types
+	// find an entry in the cache while their encoder is being built.
+	var (
+		wg sync.WaitGroup
+		f  encodeFunc
+	)
+	wg.Add(1)
+	fi, loaded := encoderCache.LoadOrStore(t, encodeFunc(func(w *Writer, v reflect.Value) error {
+		wg.Wait()
+		return f(w, v)
+	}))
+	if loaded {
+		return fi.(encodeFunc)
+	}
+
+	f = newTypeEncoder(t)
+	wg.Done()
+	encoderCache.Store(t, f)
+	return f
+}
+
+// typeDecoder returns the cached decode function for type t.
+func typeDecoder(t reflect.Type) decodeFunc {
+	if f, ok := decoderCache.Load(t); ok {
+		return f.(decodeFunc)
+	}
+
+	var (
+		wg sync.WaitGroup
+		f  decodeFunc
+	)
+	wg.Add(1)
+	fi, loaded := decoderCache.LoadOrStore(t, decodeFunc(func(r *Reader, v reflect.Value) error {
+		wg.Wait()
+		return f(r, v)
+	}))
+	if loaded {
+		return fi.(decodeFunc)
+	}
+
+	f = newTypeDecoder(t)
+	wg.Done()
+	decoderCache.Store(t, f)
+	return f
+}
+
+func newTypeEncoder(t reflect.Type) encodeFunc {
+	if t.Implements(encoderType) {
+		return encodeEncoder
+	}
+	if t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(encoderType) {
+		return encodeAddrEncoder
+	}
+	if t == timeType {
+		return encodeTime
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return encodeBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return encodeInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return encodeUint
+	case reflect.Float32:
+		return encodeFloat32
+	case reflect.Float64:
+		return encodeFloat64
+	case reflect.String:
+		return encodeString
+	case reflect.Interface:
+		return encodeInterface
+	case reflect.Pointer:
+		return newPtrEncoder(t)
+	case reflect.Slice:
+		if isByteType(t.Elem()) {
+			return encodeByteSlice
+		}
+		return newSliceEncoder(t)
+	case reflect.Array:
+		if isByteType(t.Elem()) {
+			return encodeByteArray
+		}
+		return newArrayEncoder(t)
+	case reflect.Map:
+		return newMapEncoder(t)
+	case reflect.Struct:
+		return newStructEncoder(t)
+	default:
+		return func(*Writer, reflect.Value) error {
+			return unsupportedTypeError{t}
+		}
+	}
+}
+
+func newTypeDecoder(t reflect.Type) decodeFunc {
+	if reflect.PointerTo(t).Implements(decoderType) {
+		return decodeDecoder
+	}
+	if t == timeType {
+		return decodeTime
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return decodeBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return decodeInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return decodeUint
+	case reflect.Float32:
+		return decodeFloat32
+	case reflect.Float64:
+		return decodeFloat64
+	case reflect.String:
+		return decodeString
+	case reflect.Interface:
+		return decodeInterface
+	case reflect.Pointer:
+		return newPtrDecoder(t)
+	case reflect.Slice:
+		if isByteType(t.Elem()) {
+			return decodeByteSlice
+		}
+		return newSliceDecoder(t)
+	case reflect.Array:
+		if isByteType(t.Elem()) {
+			return decodeByteArray
+		}
+		return newArrayDecoder(t)
+	case reflect.Map:
+		return newMapDecoder(t)
+	case reflect.Struct:
+		return newStructDecoder(t)
+	default:
+		return func(*Reader, reflect.Value) error {
+			return unsupportedTypeError{t}
+		}
+	}
+}
+
+// isByteType reports whether t is encoded as a single byte of a binary
+// value. Byte types with their own Encoder implementation are excluded.
+func isByteType(t reflect.Type) bool {
+	return t.Kind() == reflect.Uint8 &&
+		!t.Implements(encoderType) &&
+		!reflect.PointerTo(t).Implements(encoderType) &&
+		!reflect.PointerTo(t).Implements(decoderType)
+}
+
+func encodeEncoder(w *Writer, v reflect.Value) error {
+	if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+		return w.WriteNil()
+	}
+	return v.Interface().(Encoder).EncodeMsgpack(w)
+}
+
+func encodeAddrEncoder(w *Writer, v reflect.Value) error {
+	if !v.CanAddr() {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		v = p.Elem()
+	}
+	return v.Addr().Interface().(Encoder).EncodeMsgpack(w)
+}
+
+func encodeTime(w *Writer, v reflect.Value) error {
+	return w.WriteTime(v.Interface().(time.Time))
+}
+
+func encodeBool(w *Writer, v reflect.Value) error {
+	return w.WriteBool(v.Bool())
+}
+
+func encodeInt(w *Writer, v reflect.Value) error {
+	return w.WriteInt64(v.Int())
+}
+
+func encodeUint(w *Writer, v reflect.Value) error {
+	return w.WriteUint64(v.Uint())
+}
+
+func encodeFloat32(w *Writer, v reflect.Value) error {
+	return w.WriteFloat32(float32(v.Float()))
+}
+
+func encodeFloat64(w *Writer, v reflect.Value) error {
+	return w.WriteFloat64(v.Float())
+}
+
+func encodeString(w *Writer, v reflect.Value) error {
+	return w.WriteString(v.String())
+}
+
+func encodeInterface(w *Writer, v reflect.Value) error {
+	if v.IsNil() {
+		return w.WriteNil()
+	}
+	e := v.Elem()
+	return typeEncoder(e.Type())(w, e)
+}
+
+func encodeByteSlice(w *Writer, v reflect.Value) error {
+	if v.IsNil() {
+		return w.WriteNil()
+	}
+	return w.WriteBytes(v.Bytes())
+}
+
+func encodeByteArray(w *Writer, v reflect.Value) error {
+	if v.CanAddr() {
+		return w.WriteBytes(v.Slice(0, v.Len()).Bytes())
+	}
+	p := make([]byte, v.Len())
+	reflect.Copy(reflect.ValueOf(p), v)
+	return w.WriteBytes(p)
+}
+
+func newPtrEncoder(t reflect.Type) encodeFunc {
+	elemEnc := typeEncoder(t.Elem())
+	return func(w *Writer, v reflect.Value) error {
+		if v.IsNil() {
+			return w.WriteNil()
+		}
+		return elemEnc(w, v.Elem())
+	}
+}
+
+func newSliceEncoder(t reflect.Type) encodeFunc {
+	arrayEnc := newArrayEncoder(t)
+	return func(w *Writer, v reflect.Value) error {
+		if v.IsNil() {
+			return w.WriteNil()
+		}
+		return arrayEnc(w, v)
+	}
+}
+
+func newArrayEncoder(t reflect.Type) encodeFunc {
+	elemEnc := typeEncoder(t.Elem())
+	return func(w *Writer, v reflect.Value) error {
+		n := v.Len()
+		if err := w.WriteArrayHeader(n); err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := elemEnc(w, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func newMapEncoder(t reflect.Type) encodeFunc {
+	keyEnc := typeEncoder(t.Key())
+	elemEnc := typeEncoder(t.Elem())
+	return func(w *Writer, v reflect.Value) error {
+		if v.IsNil() {
+			return w.WriteNil()
+		}
+		return w.writeMap(func(w *Writer) error {
+			if err := w.WriteMapHeader(v.Len()); err != nil {
+				return err
+			}
+			for it := v.MapRange(); it.Next(); {
+				if err := keyEnc(w, it.Key()); err != nil {
+					return err
+				}
+				if err := elemEnc(w, it.Value()); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
+
+func newStructEncoder(t reflect.Type) encodeFunc {
+	fields := cachedFields(t)
+	encs := make([]encodeFunc, len(fields.list))
+	for i, f := range fields.list {
+		encs[i] = typeEncoder(f.typ)
+	}
+
+	if fields.asArray {
+		return func(w *Writer, v reflect.Value) error {
+			if err := w.WriteArrayHeader(len(fields.list)); err != nil {
+				return err
+			}
+			for i, f := range fields.list {
+				fv, ok := fieldByIndex(v, f.index)
+				if !ok {
+					if err := w.WriteNil(); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := encs[i](w, fv); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	return func(w *Writer, v reflect.Value) error {
+		n := 0
+		for _, f := range fields.list {
+			if fv, ok := fieldByIndex(v, f.index); ok && !(f.omitEmpty && isEmptyValue(fv)) {
+				n++
+			}
+		}
+
+		return w.writeMap(func(w *Writer) error {
+			if err := w.WriteMapHeader(n); err != nil {
+				return err
+			}
+			for i, f := range fields.list {
+				fv, ok := fieldByIndex(v, f.index)
+				if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+					continue
+				}
+				if err := w.WriteString(f.name); err != nil {
+					return err
+				}
+				if err := encs[i](w, fv); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
+
+func decodeDecoder(r *Reader, v reflect.Value) error {
+	return v.Addr().Interface().(Decoder).DecodeMsgpack(r)
+}
+
+func decodeTime(r *Reader, v reflect.Value) error {
+	if r.peekNil() {
+		v.Set(reflect.Zero(v.Type()))
+		return r.ReadNil()
+	}
+
+	tm, err := r.ReadTime()
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(tm))
+	return nil
+}
+
+func decodeBool(r *Reader, v reflect.Value) error {
+	b, err := r.ReadBool()
+	if err != nil {
+		return err
+	}
+	v.SetBool(b)
+	return nil
+}
+
+func decodeInt(r *Reader, v reflect.Value) error {
+	i, err := r.ReadInt64()
+	switch {
+	case err != nil:
+		return err
+	case v.OverflowInt(i):
+		return intOverflowError{}
+	default:
+		v.SetInt(i)
+		return nil
+	}
+}
+
+func decodeUint(r *Reader, v reflect.Value) error {
+	ui, err := r.ReadUint64()
+	switch {
+	case err != nil:
+		return err
+	case v.OverflowUint(ui):
+		return intOverflowError{}
+	default:
+		v.SetUint(ui)
+		return nil
+	}
+}
+
+func decodeFloat32(r *Reader, v reflect.Value) error {
+	f, err := r.ReadFloat32()
+	if err != nil {
+		return err
+	}
+	v.SetFloat(float64(f))
+	return nil
+}
+
+func decodeFloat64(r *Reader, v reflect.Value) error {
+	f, err := r.ReadFloat64()
+	if err != nil {
+		return err
+	}
+	v.SetFloat(f)
+	return nil
+}
+
+func decodeString(r *Reader, v reflect.Value) error {
+	s, err := r.ReadString()
+	if err != nil {
+		return err
+	}
+	v.SetString(s)
+	return nil
+}
+
+func decodeInterface(r *Reader, v reflect.Value) error {
+	if r.peekNil() {
+		v.Set(reflect.Zero(v.Type()))
+		return r.ReadNil()
+	}
+
+	// Decode into the value pointed to, if the interface already
+	// holds a non-nil pointer.
+	if !v.IsNil() {
+		if e := v.Elem(); e.Kind() == reflect.Pointer && !e.IsNil() {
+			return typeDecoder(e.Type().Elem())(r, e.Elem())
+		}
+	}
+
+	if v.NumMethod() != 0 {
+		// A registered extension value can be stored, if it implements
+		// the interface.
+		if typ, err := r.PeekExtType(); err == nil && extFactory(typ) != nil {
+			x, err := r.readExt()
+			if err != nil {
+				return err
+			}
+			if xv := reflect.ValueOf(x); xv.Type().Implements(v.Type()) {
+				v.Set(xv)
+				return nil
+			}
+			return errorf("cannot decode %T into interface type %s", x, v.Type())
+		}
+		return errorf("cannot decode into interface type %s", v.Type())
+	}
+
+	x, err := r.readInterface()
+	if err != nil {
+		return err
+	}
+	if x == nil {
+		v.Set(reflect.Zero(v.Type()))
+	} else {
+		v.Set(reflect.ValueOf(x))
+	}
+	return nil
+}
+
+func decodeByteSlice(r *Reader, v reflect.Value) error {
+	if r.peekNil() {
+		v.Set(reflect.Zero(v.Type()))
+		return r.ReadNil()
+	}
+
+	p, err := r.ReadBytes(nil)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		p = []byte{}
+	}
+	v.SetBytes(p)
+	return nil
+}
+
+func decodeByteArray(r *Reader, v reflect.Value) error {
+	p, err := r.ReadBytesNoCopy()
+	if err != nil {
+		return err
+	}
+	n := reflect.Copy(v, reflect.ValueOf(p))
+	for i := n; i < v.Len(); i++ {
+		v.Index(i).SetUint(0)
+	}
+	return nil
+}
+
+func newPtrDecoder(t reflect.Type) decodeFunc {
+	elemDec := typeDecoder(t.Elem())
+	return func(r *Reader, v reflect.Value) error {
+		if r.peekNil() {
+			v.Set(reflect.Zero(v.Type()))
+			return r.ReadNil()
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return elemDec(r, v.Elem())
+	}
+}
+
+func newSliceDecoder(t reflect.Type) decodeFunc {
+	elemType := t.Elem()
+	elemDec := typeDecoder(elemType)
+	return func(r *Reader, v reflect.Value) error {
+		if r.peekNil() {
+			v.Set(reflect.Zero(t))
+			return r.ReadNil()
+		}
+
+		n, err := r.ReadArrayHeader()
+		if err != nil {
+			return err
+		}
+
+		s := v.Slice(0, 0)
+		if s.Cap() < n {
+			s = reflect.MakeSlice(t, 0, min(n, maxPrealloc))
+		}
+		zero := reflect.Zero(elemType)
+		for i := 0; i < n; i++ {
+			s = reflect.Append(s, zero)
+			if err := elemDec(r, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	}
+}
+
+func newArrayDecoder(t reflect.Type) decodeFunc {
+	elemDec := typeDecoder(t.Elem())
+	zero := reflect.Zero(t.Elem())
+	return func(r *Reader, v reflect.Value) error {
+		n, err := r.ReadArrayHeader()
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < n; i++ {
+			if i >= v.Len() {
+				if err := r.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := elemDec(r, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		for i := n; i < v.Len(); i++ {
+			v.Index(i).Set(zero)
+		}
+		return nil
+	}
+}
+
+func newMapDecoder(t reflect.Type) decodeFunc {
+	keyType, elemType := t.Key(), t.Elem()
+	keyDec := typeDecoder(keyType)
+	elemDec := typeDecoder(elemType)
+	return func(r *Reader, v reflect.Value) error {
+		if r.peekNil() {
+			v.Set(reflect.Zero(t))
+			return r.ReadNil()
+		}
+
+		n, err := r.ReadMapHeader()
+		if err != nil {
+			return err
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(t, min(n, maxPrealloc)))
+		}
+		for i := 0; i < n; i++ {
+			key := reflect.New(keyType).Elem()
+			if err := keyDec(r, key); err != nil {
+				return err
+			}
+			elem := reflect.New(elemType).Elem()
+			if err := elemDec(r, elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+		return nil
+	}
+}
+
+func newStructDecoder(t reflect.Type) decodeFunc {
+	fields := cachedFields(t)
+	decs := make([]decodeFunc, len(fields.list))
+	for i, f := range fields.list {
+		decs[i] = typeDecoder(f.typ)
+	}
+
+	// Structs are decoded from both layouts, so that the layout of a
+	// struct type can be changed without breaking existing data.
+	return func(r *Reader, v reflect.Value) error {
+		typ, err := r.Peek()
+		if err != nil {
+			return err
+		}
+		if typ == Array {
+			return decodeStructArray(r, v, fields, decs)
+		}
+		return decodeStructMap(r, v, fields, decs)
+	}
+}
+
+// decodeStructArray decodes a struct from its positional array layout. Missing
+// trailing fields are left untouched and surplus elements are skipped.
+func decodeStructArray(r *Reader, v reflect.Value, fields *structFields, decs []decodeFunc) error {
+	n, err := r.ReadArrayHeader()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		if i >= len(fields.list) {
+			if err := r.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := decs[i](r, fieldByIndexAlloc(v, fields.list[i].index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeStructMap(r *Reader, v reflect.Value, fields *structFields, decs []decodeFunc) error {
+	n, err := r.ReadMapHeader()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		name, err := r.ReadString()
+		if err != nil {
+			return err
+		}
+
+		idx, ok := fields.byName[name]
+		if !ok {
+			if err := r.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := decs[idx](r, fieldByIndexAlloc(v, fields.list[idx].index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readInterface reads the next value from the MessagePack stream into its
+// natural Go representation: nil, bool, int64, uint64, float64, string,
+// []byte, time.Time, []interface{} or a map. Maps whose keys are all strings
+// are returned as map[string]interface{}, all other maps as
+// map[interface{}]interface{}. Extension values are returned as created by
+// their registered factory, or as RawExt if their type is not registered.
+func (r *Reader) readInterface() (interface{}, error) {
+	typ, err := r.Peek()
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case Nil:
+		return nil, r.ReadNil()
+	case Bool:
+		return r.ReadBool()
+	case Int:
+		return r.ReadInt64()
+	case Uint:
+		return r.ReadUint64()
+	case Float:
+		return r.ReadFloat64()
+	case String:
+		return r.ReadString()
+	case Bytes:
+		p, err := r.ReadBytes(nil)
+		if p == nil && err == nil {
+			p = []byte{}
+		}
+		return p, err
+	case Time:
+		return r.ReadTime()
+	case Ext:
+		return r.readExt()
+	case Array:
+		return r.readInterfaceArray()
+	case Map:
+		return r.readInterfaceMap()
+	default:
+		return nil, errorf("cannot decode %s into interface value", typ)
+	}
+}
+
+func (r *Reader) readInterfaceArray() (interface{}, error) {
+	n, err := r.ReadArrayHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	arr := make([]interface{}, 0, min(n, maxPrealloc))
+	for i := 0; i < n; i++ {
+		x, err := r.readInterface()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, x)
+	}
+	return arr, nil
+}
+
+func (r *Reader) readInterfaceMap() (interface{}, error) {
+	n, err := r.ReadMapHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]interface{}, 0, min(n, maxPrealloc))
+	vals := make([]interface{}, 0, min(n, maxPrealloc))
+	stringKeys := true
+	for i := 0; i < n; i++ {
+		key, err := r.readInterface()
+		if err != nil {
+			return nil, err
+		}
+		val, err := r.readInterface()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := key.(string); !ok {
+			stringKeys = false
+			if key != nil && !reflect.TypeOf(key).Comparable() {
+				return nil, errorf("unhashable map key of type %T", key)
+			}
+		}
+		keys = append(keys, key)
+		vals = append(vals, val)
+	}
+
+	if stringKeys {
+		m := make(map[string]interface{}, len(keys))
+		for i, key := range keys {
+			m[key.(string)] = vals[i]
+		}
+		return m, nil
+	}
+
+	m := make(map[interface{}]interface{}, len(keys))
+	for i, key := range keys {
+		m[key] = vals[i]
+	}
+	return m, nil
+}
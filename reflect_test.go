@@ -0,0 +1,265 @@
+package msgpack
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type reflectInner struct {
+	Name string
+	Tags []string
+}
+
+type reflectOuter struct {
+	ID       int
+	Score    float64
+	Ratio    float32
+	Active   bool
+	Count    uint16
+	Blob     []byte
+	Hash     [4]byte
+	When     time.Time
+	Inner    reflectInner
+	InnerPtr *reflectInner
+	List     []reflectInner
+	Lookup   map[string]int
+	Any      interface{}
+	Custom   reflectCustom
+	private  int
+}
+
+type reflectCustom struct {
+	Value int
+}
+
+func (c *reflectCustom) EncodeMsgpack(w *Writer) error {
+	return w.WriteString(string(rune('a' + c.Value)))
+}
+
+func (c *reflectCustom) DecodeMsgpack(r *Reader) error {
+	s, err := r.ReadString()
+	if err == nil && len(s) == 1 {
+		c.Value = int(s[0] - 'a')
+	}
+	return err
+}
+
+type reflectList struct {
+	Value int
+	Next  *reflectList
+}
+
+func TestMarshalValueRoundtrip(t *testing.T) {
+	tests := []struct {
+		in  interface{}
+		out interface{} // pointer to a zero value of the decoded type
+	}{
+		{in: true, out: new(bool)},
+		{in: int8(-7), out: new(int8)},
+		{in: 1 << 40, out: new(int)},
+		{in: uint32(1 << 20), out: new(uint32)},
+		{in: float32(3.5), out: new(float32)},
+		{in: 3.141592, out: new(float64)},
+		{in: "foo", out: new(string)},
+		{in: []byte("blob"), out: new([]byte)},
+		{in: [3]byte{1, 2, 3}, out: new([3]byte)},
+		{in: []int{1, 2, 3}, out: new([]int)},
+		{in: [2]string{"a", "b"}, out: new([2]string)},
+		{in: map[string]int{"a": 1, "b": 2}, out: new(map[string]int)},
+		{in: map[int]bool{1: true}, out: new(map[int]bool)},
+		{in: time.Date(2017, time.September, 26, 13, 14, 15, 0, time.UTC), out: new(time.Time)},
+		{in: reflectCustom{Value: 3}, out: new(reflectCustom)},
+		{in: &reflectList{Value: 1, Next: &reflectList{Value: 2}}, out: new(*reflectList)},
+		{
+			in: reflectOuter{
+				ID:       -13,
+				Score:    1.5,
+				Ratio:    0.25,
+				Active:   true,
+				Count:    300,
+				Blob:     []byte{0x01, 0x02},
+				Hash:     [4]byte{0xde, 0xad, 0xbe, 0xef},
+				When:     time.Date(2017, time.September, 26, 13, 14, 15, 0, time.UTC),
+				Inner:    reflectInner{Name: "inner", Tags: []string{"x", "y"}},
+				InnerPtr: &reflectInner{Name: "ptr"},
+				List:     []reflectInner{{Name: "first"}, {Name: "second"}},
+				Lookup:   map[string]int{"one": 1},
+				Any:      "any",
+				Custom:   reflectCustom{Value: 2},
+			},
+			out: new(reflectOuter),
+		},
+	}
+
+	for _, test := range tests {
+		data, err := MarshalValue(test.in)
+		if err != nil {
+			t.Errorf("unexpected marshal error for %T: %v", test.in, err)
+			continue
+		}
+		if err := UnmarshalValue(data, test.out); err != nil {
+			t.Errorf("unexpected unmarshal error for %T: %v", test.in, err)
+			continue
+		}
+
+		expected := test.in
+		if reflect.TypeOf(test.in) != reflect.TypeOf(test.out).Elem() {
+			t.Fatalf("invalid test for %T", test.in)
+		}
+		if res := reflect.ValueOf(test.out).Elem().Interface(); !reflect.DeepEqual(res, expected) {
+			t.Errorf("unexpected value for %T: %#v", test.in, res)
+		}
+	}
+}
+
+func TestMarshalValueEncoding(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		data  []byte
+	}{
+		{
+			value: nil,
+			data:  []byte{tagNil},
+		},
+		{
+			value: []int(nil),
+			data:  []byte{tagNil},
+		},
+		{
+			value: map[string]int(nil),
+			data:  []byte{tagNil},
+		},
+		{
+			value: (*int)(nil),
+			data:  []byte{tagNil},
+		},
+		{
+			value: (*reflectCustom)(nil),
+			data:  []byte{tagNil},
+		},
+		{
+			value: []byte{},
+			data:  []byte{tagBin8, 0x00},
+		},
+		{
+			value: struct{ A, b int }{A: 1, b: 2},
+			data:  []byte{fixmapTag(1), fixstrTag(1), 'A', posFixintTag(1)},
+		},
+		{
+			value: []interface{}{nil, "a", 7},
+			data:  []byte{fixarrayTag(3), tagNil, fixstrTag(1), 'a', posFixintTag(7)},
+		},
+		{
+			value: Raw{posFixintTag(7)},
+			data:  []byte{posFixintTag(7)},
+		},
+	}
+
+	for _, test := range tests {
+		data, err := MarshalValue(test.value)
+		if err != nil {
+			t.Errorf("unexpected error for %#v: %v", test.value, err)
+		} else if !bytes.Equal(data, test.data) {
+			t.Errorf("unexpected encoding for %#v: %x", test.value, data)
+		}
+	}
+}
+
+func TestUnmarshalValueInterface(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteMapHeader(3)
+	w.WriteString("int")
+	w.WriteInt(-7)
+	w.WriteString("list")
+	w.WriteArrayHeader(2)
+	w.WriteUint(7)
+	w.WriteBytes([]byte("blob"))
+	w.WriteString("map")
+	w.WriteMapHeader(1)
+	w.WriteInt(-1)
+	w.WriteNil()
+	w.Flush()
+
+	var v interface{}
+	if err := UnmarshalValue(buf.Bytes(), &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"int":  int64(-7),
+		"list": []interface{}{uint64(7), []byte("blob")},
+		"map":  map[interface{}]interface{}{int64(-1): nil},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("unexpected value: %#v", v)
+	}
+}
+
+func TestUnmarshalValueStruct(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteMapHeader(3)
+	w.WriteString("Name")
+	w.WriteString("foo")
+	w.WriteString("Unknown")
+	w.WriteArrayHeader(2)
+	w.WriteNil()
+	w.WriteNil()
+	w.WriteString("Tags")
+	w.WriteNil()
+	w.Flush()
+
+	v := reflectInner{Tags: []string{"old"}}
+	if err := UnmarshalValue(buf.Bytes(), &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.Name != "foo" || v.Tags != nil {
+		t.Errorf("unexpected value: %#v", v)
+	}
+}
+
+func TestUnmarshalValueError(t *testing.T) {
+	tests := []struct {
+		data  []byte
+		value interface{}
+		err   string
+	}{
+		{
+			data:  []byte{posFixintTag(7)},
+			value: 0,
+			err:   "cannot decode into non-pointer type int",
+		},
+		{
+			data:  []byte{tagUint16, 0x01, 0x00},
+			value: new(int8),
+			err:   "integer overflow",
+		},
+		{
+			data:  []byte{fixstrTag(1), 'a'},
+			value: new(int),
+			err:   "unexpected type: string (expected int)",
+		},
+		{
+			data:  []byte{posFixintTag(7)},
+			value: new(chan int),
+			err:   "unsupported type chan int",
+		},
+		{
+			data:  []byte{fixmapTag(1), fixarrayTag(0), tagNil},
+			value: new(interface{}),
+			err:   "unhashable map key of type []interface {}",
+		},
+	}
+
+	for _, test := range tests {
+		err := UnmarshalValue(test.data, test.value)
+		if err == nil {
+			t.Errorf("expected error for %x, got none", test.data)
+		} else if err.Error() != test.err {
+			t.Errorf("unexpected error message for %x: %v", test.data, err)
+		}
+	}
+}
@@ -0,0 +1,545 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// SchemaType is the name of a type which is allowed by a Schema.
+type SchemaType string
+
+// All supported schema types. Besides the types of JSON Schema, the
+// MessagePack types bin, ext and time are supported.
+const (
+	SchemaNull    SchemaType = "null"    // nil
+	SchemaBoolean SchemaType = "boolean" // bool
+	SchemaInteger SchemaType = "integer" // integers and integral floating-point numbers
+	SchemaNumber  SchemaType = "number"  // integers and floating-point numbers
+	SchemaString  SchemaType = "string"  // str
+	SchemaBin     SchemaType = "bin"     // binary data
+	SchemaArray   SchemaType = "array"
+	SchemaObject  SchemaType = "object" // map
+	SchemaExt     SchemaType = "ext"    // extension values other than time
+	SchemaTime    SchemaType = "time"   // timestamp extension values
+)
+
+// Schema describes the expected shape of a MessagePack value. It supports a
+// subset of JSON Schema, extended by MessagePack specific types. Every
+// constraint only applies to values of the respective types, e.g. Minimum is
+// ignored for strings. A zero schema accepts every value.
+//
+// A schema can be loaded from its JSON representation with ParseSchema. The
+// supported keywords are type, properties, required, additionalProperties,
+// items, enum, minimum, maximum, minLength, maxLength and the MessagePack
+// specific extType.
+type Schema struct {
+	// Type lists the allowed types. An empty list allows all types.
+	Type []SchemaType
+	// Enum lists the allowed values. Numbers are compared by their value,
+	// regardless of their encoding. An empty list allows all values.
+	Enum []Value
+
+	// Minimum and Maximum are the inclusive bounds of numbers. A nil value
+	// means that the respective bound is not checked.
+	Minimum Value
+	Maximum Value
+	// MinLength and MaxLength are the bounds of the number of characters of
+	// strings and the number of bytes of binary data.
+	MinLength *int
+	MaxLength *int
+
+	// Items is the schema of all array elements. A nil schema allows any
+	// elements.
+	Items *Schema
+
+	// Properties holds the schemas of map entries with string keys.
+	Properties map[string]*Schema
+	// Required lists the keys which need to be present in a map.
+	Required []string
+	// AdditionalProperties is the schema of map entries which are not listed
+	// in Properties. A nil schema allows any entries, unless
+	// NoAdditionalProperties is set, which rejects all of them.
+	AdditionalProperties   *Schema
+	NoAdditionalProperties bool
+
+	// ExtType is the required type of extension values.
+	ExtType *int8
+}
+
+// ParseSchema parses the JSON representation of a schema. Keywords outside
+// of the supported subset result in an error, except for annotations like
+// title and description, which are ignored.
+func ParseSchema(data []byte) (*Schema, error) {
+	s := new(Schema)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate reads the next value from r and validates it against the schema.
+// All violations are reported as diagnostics of a ValidationError. Errors
+// which occur while reading the value, e.g. for truncated data, stop the
+// validation and are returned as is.
+func (s *Schema) Validate(r *Reader) error {
+	v := schemaValidator{r: r}
+	if err := v.validate(s); err != nil {
+		return err
+	}
+	if len(v.diags) != 0 {
+		return ValidationError{Diagnostics: v.diags}
+	}
+	return nil
+}
+
+// ValidateBytes validates the MessagePack encoded value in p against the
+// schema. See Validate for details.
+func (s *Schema) ValidateBytes(p []byte) error {
+	return s.Validate(NewReaderBytes(p))
+}
+
+// allows reports whether the schema allows values of type typ.
+func (s *Schema) allows(typ Type) bool {
+	if len(s.Type) == 0 {
+		return true
+	}
+	for _, t := range s.Type {
+		if t == schemaType(typ) || t == SchemaNumber && (typ == Int || typ == Uint || typ == Float) {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaType returns the schema type which matches values of type typ.
+func schemaType(typ Type) SchemaType {
+	switch typ {
+	case Nil:
+		return SchemaNull
+	case Bool:
+		return SchemaBoolean
+	case Int, Uint:
+		return SchemaInteger
+	case Float:
+		return SchemaNumber
+	case String:
+		return SchemaString
+	case Bytes:
+		return SchemaBin
+	case Array:
+		return SchemaArray
+	case Map:
+		return SchemaObject
+	case Ext:
+		return SchemaExt
+	case Time:
+		return SchemaTime
+	default:
+		return SchemaType(typ)
+	}
+}
+
+type schemaValidator struct {
+	r     *Reader
+	base  int64 // offset of r within the validated input
+	path  Path
+	diags []Diagnostic
+}
+
+func (v *schemaValidator) offset() int64 {
+	return v.base + v.r.offset
+}
+
+func (v *schemaValidator) report(offset int64, format string, args ...interface{}) {
+	v.diags = append(v.diags, Diagnostic{
+		Offset: offset,
+		Path:   append(Path(nil), v.path...),
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *schemaValidator) validate(s *Schema) error {
+	if s == nil {
+		return v.r.Skip()
+	}
+
+	start := v.offset()
+	if len(s.Enum) != 0 {
+		return v.validateEnum(s, start)
+	}
+
+	typ, err := v.r.Peek()
+	if err != nil {
+		return err
+	}
+	// Floating-point numbers might still be integers, which is only known
+	// once they are read.
+	if !s.allows(typ) && !(typ == Float && s.allows(Int)) {
+		v.reportType(s, start, typ)
+		return v.r.Skip()
+	}
+
+	switch typ {
+	case Int, Uint, Float:
+		n, err := readNumber(v.r)
+		if err != nil {
+			return err
+		}
+		if typ == Float && !s.allows(Float) && !isIntegral(n.Float()) {
+			v.reportType(s, start, typ)
+			return nil
+		}
+		if s.Minimum.isNumber() && compareNumbers(n, s.Minimum) < 0 {
+			v.report(start, "%s is less than the minimum %s", formatValue(n), formatValue(s.Minimum))
+		}
+		if s.Maximum.isNumber() && compareNumbers(n, s.Maximum) > 0 {
+			v.report(start, "%s is greater than the maximum %s", formatValue(n), formatValue(s.Maximum))
+		}
+		return nil
+
+	case String:
+		str, err := v.r.readStringNoCopy()
+		if err != nil {
+			return err
+		}
+		v.checkLength(s, start, "string", utf8.RuneCount(str))
+		return nil
+
+	case Bytes:
+		if s.MinLength == nil && s.MaxLength == nil {
+			return v.r.Skip()
+		}
+		b, err := v.r.ReadBytesNoCopy()
+		if err != nil {
+			return err
+		}
+		v.checkLength(s, start, "binary", len(b))
+		return nil
+
+	case Ext:
+		typ, _, err := v.r.ReadExtAny()
+		if err != nil {
+			return err
+		}
+		if s.ExtType != nil && typ != *s.ExtType {
+			v.report(start, "unexpected extension type %d (expected %d)", typ, *s.ExtType)
+		}
+		return nil
+
+	case Time:
+		_, err := v.r.ReadTime()
+		return err
+
+	case Array:
+		n, err := v.r.ReadArrayHeader()
+		if err != nil {
+			return err
+		}
+		depth := len(v.path)
+		for i := 0; i < n; i++ {
+			v.path = append(v.path[:depth], i)
+			if err := v.validate(s.Items); err != nil {
+				return unexpectedEOF(err)
+			}
+		}
+		v.path = v.path[:depth]
+		return nil
+
+	case Map:
+		return v.validateMap(s, start)
+
+	default:
+		return v.r.Skip()
+	}
+}
+
+// isIntegral reports whether f is a finite integer.
+func isIntegral(f float64) bool {
+	return f == math.Trunc(f) && !math.IsInf(f, 0)
+}
+
+func (v *schemaValidator) reportType(s *Schema, start int64, typ Type) {
+	types := make([]string, len(s.Type))
+	for i, t := range s.Type {
+		types[i] = string(t)
+	}
+	v.report(start, "unexpected type %s (expected %s)", schemaType(typ), strings.Join(types, " or "))
+}
+
+func (v *schemaValidator) checkLength(s *Schema, start int64, kind string, n int) {
+	if s.MinLength != nil && n < *s.MinLength {
+		v.report(start, "%s length %d is less than the minimum length %d", kind, n, *s.MinLength)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		v.report(start, "%s length %d is greater than the maximum length %d", kind, n, *s.MaxLength)
+	}
+}
+
+// validateEnum validates the next value against a schema with an enum. The
+// value is read as a whole to compare it with the enumerated values.
+func (v *schemaValidator) validateEnum(s *Schema, start int64) error {
+	raw, err := v.r.ReadRaw(nil)
+	if err != nil {
+		return err
+	}
+
+	var val Value
+	if err := unmarshalValue(raw, &val); err != nil {
+		return err
+	}
+	found := false
+	for _, e := range s.Enum {
+		if equalValues(val, e) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		v.report(start, "value %s is not one of the enumerated values", formatValue(val))
+	}
+
+	rest := *s
+	rest.Enum = nil
+	r, base := v.r, v.base
+	v.r, v.base = NewReaderBytes(raw), start
+	err = v.validate(&rest)
+	v.r, v.base = r, base
+	return err
+}
+
+func (v *schemaValidator) validateMap(s *Schema, start int64) error {
+	n, err := v.r.ReadMapHeader()
+	if err != nil {
+		return err
+	}
+
+	var seen map[string]bool
+	if len(s.Required) != 0 {
+		seen = make(map[string]bool, len(s.Required))
+	}
+
+	depth := len(v.path)
+	for i := 0; i < n; i++ {
+		keyStart := v.offset()
+		var key Value
+		if err := key.DecodeMsgpack(v.r); err != nil {
+			return unexpectedEOF(err)
+		}
+		v.path = append(v.path[:depth], keyPathElem(key))
+
+		var (
+			prop *Schema
+			ok   bool
+		)
+		if key.typ == String {
+			prop, ok = s.Properties[key.str]
+			if seen != nil {
+				seen[key.str] = true
+			}
+		}
+		if !ok {
+			if s.NoAdditionalProperties {
+				v.report(keyStart, "unexpected property %s", formatValue(key))
+			}
+			prop = s.AdditionalProperties
+		}
+
+		if err := v.validate(prop); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+	v.path = v.path[:depth]
+
+	for _, name := range s.Required {
+		if !seen[name] {
+			v.report(start, "missing required property %q", name)
+		}
+	}
+	return nil
+}
+
+// jsonSchema is the JSON representation of a Schema.
+type jsonSchema struct {
+	Type                 interface{}        `json:"type,omitempty"`
+	Enum                 []json.RawMessage  `json:"enum,omitempty"`
+	Minimum              json.RawMessage    `json:"minimum,omitempty"`
+	Maximum              json.RawMessage    `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	ExtType              *int8              `json:"extType,omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	js := jsonSchema{
+		MinLength:  s.MinLength,
+		MaxLength:  s.MaxLength,
+		Items:      s.Items,
+		Properties: s.Properties,
+		Required:   s.Required,
+		ExtType:    s.ExtType,
+	}
+
+	switch {
+	case len(s.Type) == 1:
+		js.Type = s.Type[0]
+	case len(s.Type) > 1:
+		js.Type = s.Type
+	}
+	for _, e := range s.Enum {
+		js.Enum = append(js.Enum, json.RawMessage(formatValue(e)))
+	}
+	if s.Minimum.isNumber() {
+		js.Minimum = json.RawMessage(formatValue(s.Minimum))
+	}
+	if s.Maximum.isNumber() {
+		js.Maximum = json.RawMessage(formatValue(s.Maximum))
+	}
+	if s.NoAdditionalProperties {
+		js.AdditionalProperties = false
+	} else if s.AdditionalProperties != nil {
+		js.AdditionalProperties = s.AdditionalProperties
+	}
+	return json.Marshal(js)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	var allowed bool
+	if err := json.Unmarshal(data, &allowed); err == nil {
+		if !allowed {
+			return errorString("false schema is only supported for additionalProperties")
+		}
+		*s = Schema{}
+		return nil
+	}
+
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keywords); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	*s = Schema{}
+	for _, name := range names {
+		if err := s.unmarshalKeyword(name, keywords[name]); err != nil {
+			return errorf("invalid schema keyword %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) unmarshalKeyword(name string, data json.RawMessage) error {
+	switch name {
+	case "type":
+		var types []SchemaType
+		if err := json.Unmarshal(data, &types); err != nil {
+			var typ SchemaType
+			if err := json.Unmarshal(data, &typ); err != nil {
+				return err
+			}
+			types = []SchemaType{typ}
+		}
+		for _, t := range types {
+			switch t {
+			case SchemaNull, SchemaBoolean, SchemaInteger, SchemaNumber, SchemaString,
+				SchemaBin, SchemaArray, SchemaObject, SchemaExt, SchemaTime:
+			default:
+				return errorf("unknown type %q", t)
+			}
+		}
+		s.Type = types
+		return nil
+
+	case "enum":
+		var values []json.RawMessage
+		if err := json.Unmarshal(data, &values); err != nil {
+			return err
+		}
+		s.Enum = make([]Value, len(values))
+		for i, val := range values {
+			var err error
+			if s.Enum[i], err = jsonValue(val); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "minimum", "maximum":
+		n, err := jsonValue(data)
+		if err != nil {
+			return err
+		} else if !n.isNumber() {
+			return errorString("number expected")
+		}
+		if name == "minimum" {
+			s.Minimum = n
+		} else {
+			s.Maximum = n
+		}
+		return nil
+
+	case "minLength", "maxLength":
+		n := new(int)
+		if err := json.Unmarshal(data, n); err != nil {
+			return err
+		} else if *n < 0 {
+			return errorf("negative length %d", *n)
+		}
+		if name == "minLength" {
+			s.MinLength = n
+		} else {
+			s.MaxLength = n
+		}
+		return nil
+
+	case "items":
+		return json.Unmarshal(data, &s.Items)
+	case "properties":
+		return json.Unmarshal(data, &s.Properties)
+	case "required":
+		return json.Unmarshal(data, &s.Required)
+
+	case "additionalProperties":
+		var allowed bool
+		if err := json.Unmarshal(data, &allowed); err == nil {
+			s.NoAdditionalProperties = !allowed
+			return nil
+		}
+		return json.Unmarshal(data, &s.AdditionalProperties)
+
+	case "extType":
+		return json.Unmarshal(data, &s.ExtType)
+
+	case "$schema", "$id", "$comment", "title", "description", "default", "examples":
+		return nil // annotations
+
+	default:
+		return errorString("unsupported keyword")
+	}
+}
+
+// jsonValue converts a JSON value into a Value.
+func jsonValue(data []byte) (Value, error) {
+	var buf bytes.Buffer
+	if _, err := CopyFromJSON(&buf, bytes.NewReader(data)); err != nil {
+		return Value{}, err
+	}
+
+	var v Value
+	err := Unmarshal(buf.Bytes(), &v)
+	return v, unexpectedEOF(err)
+}
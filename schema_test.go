@@ -0,0 +1,145 @@
+package msgpack
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := ParseSchema([]byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "reading",
+		"type": "object",
+		"properties": {
+			"id": {"type": "integer", "minimum": 1, "maximum": 65535},
+			"name": {"type": "string", "minLength": 1, "maxLength": 4},
+			"unit": {"enum": ["C", "F"]},
+			"values": {"type": "array", "items": {"type": ["number", "null"], "minimum": -40.5}},
+			"raw": {"type": "bin", "maxLength": 2},
+			"taken": {"type": "time"},
+			"extra": {"type": "ext", "extType": 5}
+		},
+		"required": ["id", "name", "taken"],
+		"additionalProperties": false
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	valid := marshalEditValue(t, MapValue(
+		KeyValue{Key: StringValue("id"), Value: IntValue(7)},
+		KeyValue{Key: StringValue("name"), Value: StringValue("äöü")},
+		KeyValue{Key: StringValue("unit"), Value: StringValue("C")},
+		KeyValue{Key: StringValue("values"), Value: ArrayValue(FloatValue(-40.5), NilValue(), UintValue(3))},
+		KeyValue{Key: StringValue("raw"), Value: BytesValue([]byte{1, 2})},
+		KeyValue{Key: StringValue("taken"), Value: TimeValue(time.Unix(1, 0))},
+		KeyValue{Key: StringValue("extra"), Value: ExtValue(5, []byte{1})},
+	))
+	if err := schema.ValidateBytes(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	invalid := marshalEditValue(t, MapValue(
+		KeyValue{Key: StringValue("id"), Value: UintValue(65536)},
+		KeyValue{Key: StringValue("name"), Value: StringValue("")},
+		KeyValue{Key: StringValue("unit"), Value: StringValue("K")},
+		KeyValue{Key: StringValue("values"), Value: ArrayValue(FloatValue(-41), StringValue("x"))},
+		KeyValue{Key: StringValue("raw"), Value: StringValue("abc")},
+		KeyValue{Key: StringValue("extra"), Value: ExtValue(6, []byte{1})},
+		KeyValue{Key: IntValue(-1), Value: NilValue()},
+	))
+	err = schema.ValidateBytes(invalid)
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"offset 4 (/id): 65536 is greater than the maximum 65535",
+		"offset 14 (/name): string length 0 is less than the minimum length 1",
+		"offset 20 (/unit): value \"K\" is not one of the enumerated values",
+		"offset 30 (/values/0): -41 is less than the minimum -40.5",
+		"offset 39 (/values/1): unexpected type string (expected number or null)",
+		"offset 45 (/raw): unexpected type string (expected bin)",
+		"offset 55 (/extra): unexpected extension type 6 (expected 5)",
+		"offset 58 (/-1): unexpected property -1",
+		"offset 0: missing required property \"taken\"",
+	}
+	var diags []string
+	for _, d := range verr.Diagnostics {
+		diags = append(diags, d.String())
+	}
+	if strings.Join(diags, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected diagnostics:\n%s", strings.Join(diags, "\n"))
+	}
+
+	if err := schema.ValidateBytes(valid[:len(valid)-1]); err == nil {
+		t.Errorf("expected error for truncated value")
+	} else if _, ok := err.(ValidationError); ok {
+		t.Errorf("unexpected validation error for truncated value: %v", err)
+	}
+}
+
+func TestSchemaIntegerType(t *testing.T) {
+	schema := &Schema{Type: []SchemaType{SchemaInteger}, Maximum: IntValue(10)}
+
+	tests := []struct {
+		value    Value
+		expected string
+	}{
+		{value: IntValue(-3)},
+		{value: FloatValue(1)},
+		{value: Float32Value(-2)},
+		{value: FloatValue(12), expected: "offset 0: 12 is greater than the maximum 10"},
+		{value: FloatValue(1.5), expected: "offset 0: unexpected type number (expected integer)"},
+		{value: FloatValue(math.Inf(-1)), expected: "offset 0: unexpected type number (expected integer)"},
+		{value: FloatValue(math.NaN()), expected: "offset 0: unexpected type number (expected integer)"},
+		{value: StringValue("1"), expected: "offset 0: unexpected type string (expected integer)"},
+	}
+
+	for _, test := range tests {
+		err := schema.ValidateBytes(marshalEditValue(t, test.value))
+		switch {
+		case test.expected == "" && err != nil:
+			t.Errorf("unexpected error for %s: %v", formatValue(test.value), err)
+		case test.expected != "" && (err == nil || err.Error() != test.expected):
+			t.Errorf("unexpected error for %s: %v", formatValue(test.value), err)
+		}
+	}
+}
+
+func TestParseSchema(t *testing.T) {
+	s, err := ParseSchema([]byte(`{"type": ["integer", "null"], "minimum": -1, "enum": [1, 2.5, "x"], "items": true, "additionalProperties": {"type": "time"}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.Type) != 2 || s.Minimum.Int() != -1 || len(s.Enum) != 3 || s.Items == nil || s.AdditionalProperties.Type[0] != SchemaTime {
+		t.Errorf("unexpected schema: %+v", s)
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `{"type":["integer","null"],"enum":[1,2.5,"x"],"minimum":-1,"items":{},"additionalProperties":{"type":"time"}}`
+	if string(data) != expected {
+		t.Errorf("unexpected json: %s", data)
+	}
+
+	invalid := []string{
+		`{"type": "float"}`,
+		`{"pattern": "^a"}`,
+		`{"minimum": "1"}`,
+		`{"maxLength": -1}`,
+		`{"items": false}`,
+		`{"properties": {"a": {"type": 1}}}`,
+	}
+	for _, data := range invalid {
+		if _, err := ParseSchema([]byte(data)); err == nil {
+			t.Errorf("expected error for %s", data)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package msgpack
+
+import (
+	"bytes"
+	"io"
+)
+
+// StreamDecoder decodes a sequence of MessagePack values from an input
+// stream. Unlike Decode, it keeps data which was read ahead between
+// subsequent calls, so that no data is lost when decoding several values
+// from the same stream.
+type StreamDecoder struct {
+	r *Reader
+}
+
+// NewStreamDecoder creates a decoder which reads from r. The decoder
+// introduces its own buffering and may read data from r beyond the values
+// requested.
+func NewStreamDecoder(r io.Reader) *StreamDecoder {
+	return &StreamDecoder{
+		r: &Reader{
+			r:   r,
+			buf: make([]byte, 1024),
+		},
+	}
+}
+
+// Decode decodes the next value from the stream into v. If the stream is
+// exhausted before a new value starts, io.EOF is returned.
+func (d *StreamDecoder) Decode(v Decoder) error {
+	return v.DecodeMsgpack(d.r)
+}
+
+// DecodeValue decodes the next value from the stream into the value pointed
+// to by v. See Reader.ReadValue for details.
+func (d *StreamDecoder) DecodeValue(v interface{}) error {
+	return d.r.ReadValue(v)
+}
+
+// Reader returns the underlying reader of the decoder, which can be used to
+// read values piece by piece.
+func (d *StreamDecoder) Reader() *Reader {
+	return d.r
+}
+
+// Buffered returns a reader of the data remaining in the decoder's buffer.
+// The reader is valid until the next call to Decode.
+func (d *StreamDecoder) Buffered() io.Reader {
+	return bytes.NewReader(d.r.buf[d.r.first:d.r.last])
+}
+
+// InputOffset returns the number of bytes consumed from the input stream
+// so far. It is the offset of the next value in the stream.
+func (d *StreamDecoder) InputOffset() int64 {
+	return d.r.offset
+}
+
+// Reset discards any buffered data and resets the decoder to read from r.
+func (d *StreamDecoder) Reset(r io.Reader) {
+	d.r.Reset(r)
+}
+
+// StreamEncoder encodes a sequence of MessagePack values into an output
+// stream.
+type StreamEncoder struct {
+	w *Writer
+}
+
+// NewStreamEncoder creates an encoder which writes to w.
+func NewStreamEncoder(w io.Writer) *StreamEncoder {
+	return &StreamEncoder{w: NewWriter(w)}
+}
+
+// Encode encodes v and writes it to the stream. If v fails to encode, the
+// data written so far is discarded. If parts of it already had to be
+// written to the stream, because they exceeded the buffer of the encoder,
+// the stream is corrupted and the error is returned by all subsequent
+// calls.
+func (e *StreamEncoder) Encode(v Encoder) error {
+	return e.encode(v.EncodeMsgpack)
+}
+
+// EncodeValue encodes v and writes it to the stream. See Writer.WriteValue
+// for details and Encode for the handling of errors.
+func (e *StreamEncoder) EncodeValue(v interface{}) error {
+	return e.encode(func(w *Writer) error {
+		return w.WriteValue(v)
+	})
+}
+
+func (e *StreamEncoder) encode(f func(w *Writer) error) error {
+	w := e.w
+	n, writes := len(w.buf), w.writes
+	if err := f(w); err != nil {
+		if w.writes == writes {
+			w.buf = w.buf[:n]
+		} else if w.err == nil {
+			w.err = err
+		}
+		return err
+	}
+	return w.Flush()
+}
+
+// Writer returns the underlying writer of the encoder, which can be used to
+// write values piece by piece. Data written this way is buffered until the
+// next call to Encode, EncodeValue or Flush.
+func (e *StreamEncoder) Writer() *Writer {
+	return e.w
+}
+
+// Flush writes any buffered data to the stream.
+func (e *StreamEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+// Reset discards any unflushed data and resets the encoder to write to w.
+func (e *StreamEncoder) Reset(w io.Writer) {
+	e.w.Reset(w)
+}
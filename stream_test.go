@@ -0,0 +1,149 @@
+package msgpack
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func TestStreamRoundtrip(t *testing.T) {
+	values := []interface{}{
+		"first",
+		int64(-7),
+		map[string]interface{}{"key": "value"},
+		[]interface{}{uint64(1), uint64(2), uint64(3)},
+	}
+
+	var buf bytes.Buffer
+	enc := NewStreamEncoder(&buf)
+	for _, v := range values {
+		if err := enc.EncodeValue(v); err != nil {
+			t.Fatalf("unexpected encode error: %v", err)
+		}
+	}
+	size := int64(buf.Len())
+
+	// read byte by byte to force several buffer refills
+	dec := NewStreamDecoder(io.LimitReader(&oneByteReader{&buf}, size))
+	for _, expected := range values {
+		var v interface{}
+		if err := dec.DecodeValue(&v); err != nil {
+			t.Fatalf("unexpected decode error: %v", err)
+		}
+		if !reflect.DeepEqual(v, expected) {
+			t.Errorf("unexpected value: %#v", v)
+		}
+	}
+
+	var v interface{}
+	if err := dec.DecodeValue(&v); err != io.EOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if off := dec.InputOffset(); off != size {
+		t.Errorf("unexpected input offset: %d (expected %d)", off, size)
+	}
+}
+
+func TestStreamEncoderError(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewStreamEncoder(&buf)
+	if err := enc.Encode(failingEncoder{}); err == nil || err.Error() != "encoder failed" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := enc.EncodeValue(7); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{posFixintTag(7)}) {
+		t.Errorf("unexpected stream: %x", buf.Bytes())
+	}
+
+	// the partial value exceeds the buffer and reaches the stream
+	buf.Reset()
+	if err := enc.Encode(failingEncoder{payload: make([]byte, 4096)}); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := enc.EncodeValue(7); err == nil || err.Error() != "encoder failed" {
+		t.Errorf("unexpected error after corrupting the stream: %v", err)
+	}
+}
+
+func TestStreamDecoderDataErr(t *testing.T) {
+	data := []byte{posFixintTag(1), posFixintTag(2)}
+
+	// The reader returns the last bytes together with io.EOF.
+	dec := NewStreamDecoder(iotest.DataErrReader(bytes.NewReader(data)))
+	for _, expected := range []int{1, 2} {
+		var n int
+		if err := dec.DecodeValue(&n); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n != expected {
+			t.Errorf("unexpected value: %d (expected %d)", n, expected)
+		}
+	}
+
+	var n int
+	if err := dec.DecodeValue(&n); err != io.EOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStreamDecoderBuffered(t *testing.T) {
+	data := []byte{posFixintTag(1), posFixintTag(2), 'r', 'e', 's', 't'}
+	dec := NewStreamDecoder(bytes.NewReader(data))
+
+	var n int
+	if err := dec.DecodeValue(&n); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := dec.DecodeValue(&n); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("unexpected value: %d", n)
+	}
+	if off := dec.InputOffset(); off != 2 {
+		t.Errorf("unexpected input offset: %d", off)
+	}
+
+	rest, err := io.ReadAll(dec.Buffered())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(rest) != "rest" {
+		t.Errorf("unexpected buffered data: %q", rest)
+	}
+
+	dec.Reset(bytes.NewReader([]byte{posFixintTag(3)}))
+	if err := dec.DecodeValue(&n); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 3 || dec.InputOffset() != 1 {
+		t.Errorf("unexpected state after reset: value=%d offset=%d", n, dec.InputOffset())
+	}
+}
+
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return r.r.Read(p[:1])
+}
+
+// failingEncoder writes the beginning of an array and fails afterwards.
+type failingEncoder struct {
+	payload []byte
+}
+
+func (e failingEncoder) EncodeMsgpack(w *Writer) error {
+	w.WriteArrayHeader(2)
+	w.WriteInt(1)
+	w.WriteBytes(e.payload)
+	return errorString("encoder failed")
+}
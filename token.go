@@ -0,0 +1,162 @@
+package msgpack
+
+import (
+	"time"
+)
+
+// TokenKind specifies the kind of a token.
+type TokenKind int
+
+// All token kinds.
+const (
+	TokenValue      TokenKind = iota // nil, boolean, number, string, binary, time or extension value
+	TokenBeginArray                  // array header
+	TokenEndArray                    // end of an array
+	TokenBeginMap                    // map header
+	TokenEndMap                      // end of a map
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case TokenValue:
+		return "value"
+	case TokenBeginArray:
+		return "begin array"
+	case TokenEndArray:
+		return "end array"
+	case TokenBeginMap:
+		return "begin map"
+	case TokenEndMap:
+		return "end map"
+	default:
+		return "invalid"
+	}
+}
+
+// Token holds a single token of a MessagePack stream as returned by
+// Reader.NextToken. Only the fields which belong to the token's kind and
+// type are set.
+type Token struct {
+	Kind TokenKind
+	Type Type // type of the value; Array or Map for begin and end tokens
+
+	Bool  bool      // Bool values
+	Int   int64     // Int values
+	Uint  uint64    // Uint values
+	Float float64   // Float values
+	Time  time.Time // Time values
+	Ext   int8      // extension type of Ext values
+
+	// Bytes holds the data of String, Bytes and Ext values. It refers to
+	// the reader's internal buffer and is only valid until the next read.
+	Bytes []byte
+
+	// Len is the number of elements of an array, the number of entries of
+	// a map, or the data length of a String, Bytes or Ext value.
+	Len int
+
+	// Depth is the number of containers enclosing the token. Top-level
+	// values have a depth of 0. Begin and end tokens of a container have
+	// the same depth.
+	Depth int
+
+	// Remaining is the number of values remaining in the enclosing
+	// container after this token, where map keys and values are counted
+	// separately. It is 0 for top-level tokens.
+	Remaining int
+
+	// Key reports whether the token is (or begins) a map key.
+	Key bool
+}
+
+// NextToken reads the next token from the MessagePack stream. Array and map
+// values produce a begin token, followed by the tokens of their elements and
+// an end token. All other values produce a single token. At the end of the
+// stream, io.EOF is returned.
+//
+// NextToken can be mixed with the other Read* methods. An end token is
+// returned once all elements of an array or map have been read, regardless
+// of how they were read. However, it needs to be requested with NextToken
+// before any further Read* call, which drops the end tokens of all arrays
+// and maps whose elements have been read completely.
+func (r *Reader) NextToken() (Token, error) {
+	if n := len(r.frames); n > 0 && r.frames[n-1].remaining == 0 {
+		f := r.frames[n-1]
+		r.frames = r.frames[:n-1]
+
+		tok := Token{Kind: TokenEndArray, Type: Array}
+		if f.isMap {
+			tok.Kind, tok.Type = TokenEndMap, Map
+		}
+		r.setTokenPosition(&tok, n-1)
+		return tok, nil
+	}
+
+	typ, err := r.Peek()
+	if err != nil {
+		return Token{}, err
+	}
+
+	depth := len(r.frames)
+	tok := Token{Kind: TokenValue, Type: typ}
+	switch typ {
+	case Nil:
+		err = r.ReadNil()
+	case Bool:
+		tok.Bool, err = r.ReadBool()
+	case Int:
+		tok.Int, err = r.ReadInt64()
+	case Uint:
+		tok.Uint, err = r.ReadUint64()
+	case Float:
+		tok.Float, err = r.ReadFloat64()
+	case String:
+		tok.Bytes, err = r.readStringNoCopy()
+		tok.Len = len(tok.Bytes)
+	case Bytes:
+		tok.Bytes, err = r.ReadBytesNoCopy()
+		tok.Len = len(tok.Bytes)
+	case Time:
+		tok.Time, err = r.ReadTime()
+		tok.Ext = extTime
+	case Ext:
+		r.beginValue()
+		var headerLen int
+		if tok.Ext, headerLen, tok.Len, err = r.peekExtension(); err == nil {
+			tok.Bytes, err = r.readExtensionData(headerLen, tok.Len)
+		}
+	case Array:
+		tok.Kind = TokenBeginArray
+		tok.Len, err = r.ReadArrayHeader()
+	case Map:
+		tok.Kind = TokenBeginMap
+		tok.Len, err = r.ReadMapHeader()
+	default:
+		err = errorf("unsupported type %s", typ)
+	}
+	if err != nil {
+		return Token{}, err
+	}
+
+	r.setTokenPosition(&tok, depth)
+	return tok, nil
+}
+
+// setTokenPosition sets the depth of tok and its position within the
+// container at the given depth, after tok has been consumed.
+func (r *Reader) setTokenPosition(tok *Token, depth int) {
+	tok.Depth = depth
+	if depth > 0 {
+		f := r.frames[depth-1]
+		tok.Remaining = f.remaining
+		tok.Key = f.isMap && f.remaining%2 == 1
+	}
+}
+
+func (r *Reader) readStringNoCopy() ([]byte, error) {
+	n, err := r.readBlobHeader(String)
+	if err != nil {
+		return nil, err
+	}
+	return r.read(n)
+}
@@ -0,0 +1,157 @@
+package msgpack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReaderNextToken(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteMapHeader(2)
+	w.WriteString("list")
+	w.WriteArrayHeader(3)
+	w.WriteInt(-1)
+	w.WriteArrayHeader(0)
+	w.WriteBytes([]byte{0x01})
+	w.WriteString("ext")
+	w.WriteExt(5, bytesMarshaler{0x02, 0x03})
+	w.WriteTime(time.Unix(1, 0))
+	w.WriteFloat64(1.5)
+	w.WriteBool(true)
+	w.WriteUint(7)
+	w.WriteNil()
+	w.Flush()
+
+	expected := []string{
+		"begin map len=2 depth=0 remaining=0",
+		"string data=list depth=1 remaining=3 key",
+		"begin array len=3 depth=1 remaining=2",
+		"int -1 depth=2 remaining=2",
+		"begin array len=0 depth=2 remaining=1",
+		"end array depth=2 remaining=1",
+		"bytes data=\x01 depth=2 remaining=0",
+		"end array depth=1 remaining=2",
+		"string data=ext depth=1 remaining=1 key",
+		"ext 5 data=\x02\x03 depth=1 remaining=0",
+		"end map depth=0 remaining=0",
+		"time 1970-01-01T00:00:01Z depth=0 remaining=0",
+		"float 1.5 depth=0 remaining=0",
+		"bool true depth=0 remaining=0",
+		"uint 7 depth=0 remaining=0",
+		"nil depth=0 remaining=0",
+	}
+
+	r := NewReader(&buf)
+	for _, exp := range expected {
+		tok, err := r.NextToken()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if s := formatToken(tok); s != exp {
+			t.Errorf("unexpected token: %q (expected %q)", s, exp)
+		}
+	}
+	if _, err := r.NextToken(); err != io.EOF {
+		t.Errorf("unexpected error at end of stream: %v", err)
+	}
+}
+
+func TestReaderNextTokenMixed(t *testing.T) {
+	r := NewReaderBytes([]byte{fixarrayTag(2), posFixintTag(1), fixarrayTag(1), tagNil, tagTrue})
+
+	if tok, err := r.NextToken(); err != nil || tok.Kind != TokenBeginArray {
+		t.Fatalf("unexpected token: %v (error: %v)", tok.Kind, err)
+	}
+	if _, err := r.ReadInt(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Skip(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tok, err := r.NextToken(); err != nil || tok.Kind != TokenEndArray {
+		t.Fatalf("unexpected token: %v (error: %v)", tok.Kind, err)
+	}
+	if tok, err := r.NextToken(); err != nil || tok.Type != Bool || !tok.Bool {
+		t.Fatalf("unexpected token: %v (error: %v)", tok.Type, err)
+	}
+}
+
+func TestReaderNextTokenMixedNested(t *testing.T) {
+	raw := []byte{fixarrayTag(2), fixarrayTag(1), posFixintTag(1), posFixintTag(2)}
+
+	// end tokens are returned if requested before the next read
+	r := NewReaderBytes(raw)
+	for _, step := range []string{
+		"begin array len=2 depth=0 remaining=0",
+		"begin array len=1 depth=1 remaining=1",
+		"read",
+		"end array depth=1 remaining=1",
+		"read",
+		"end array depth=0 remaining=0",
+	} {
+		if step == "read" {
+			if _, err := r.ReadInt(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			continue
+		}
+		if tok, err := r.NextToken(); err != nil || formatToken(tok) != step {
+			t.Fatalf("unexpected token: %s (error: %v, expected %s)", formatToken(tok), err, step)
+		}
+	}
+
+	// a read drops the end token of the exhausted inner array
+	r = NewReaderBytes(raw)
+	r.NextToken()
+	r.NextToken()
+	r.ReadInt()
+	if _, err := r.ReadInt(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tok, err := r.NextToken(); err != nil || tok.Kind != TokenEndArray || tok.Depth != 0 {
+		t.Fatalf("unexpected token: %s at depth %d (error: %v)", formatToken(tok), tok.Depth, err)
+	}
+	if _, err := r.NextToken(); err != io.EOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func formatToken(tok Token) string {
+	var s string
+	switch tok.Kind {
+	case TokenBeginArray, TokenBeginMap:
+		s = fmt.Sprintf("%s len=%d", tok.Kind, tok.Len)
+	case TokenEndArray, TokenEndMap:
+		s = tok.Kind.String()
+	default:
+		switch tok.Type {
+		case Nil:
+			s = "nil"
+		case Bool:
+			s = fmt.Sprintf("bool %t", tok.Bool)
+		case Int:
+			s = fmt.Sprintf("int %d", tok.Int)
+		case Uint:
+			s = fmt.Sprintf("uint %d", tok.Uint)
+		case Float:
+			s = fmt.Sprintf("float %g", tok.Float)
+		case Time:
+			s = "time " + tok.Time.Format(time.RFC3339)
+		case Ext:
+			s = fmt.Sprintf("ext %d data=%s", tok.Ext, tok.Bytes)
+		default:
+			s = fmt.Sprintf("%s data=%s", tok.Type, tok.Bytes)
+		}
+	}
+
+	s += fmt.Sprintf(" depth=%d remaining=%d", tok.Depth, tok.Remaining)
+	if tok.Key {
+		s += " key"
+	}
+	return strings.TrimSpace(s)
+}
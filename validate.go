@@ -0,0 +1,601 @@
+package msgpack
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"unicode/utf8"
+)
+
+const errValidationStopped = errorString("validation stopped")
+
+// ValidateOptions defines the checks which are performed by Validate. The
+// zero value performs all checks on a single value without any limits.
+type ValidateOptions struct {
+	// Limits are enforced in the same way as by a Reader. Exceeding a limit
+	// is reported as a diagnostic and stops the validation.
+	Limits ReaderOptions
+	// AllowNonMinimal accepts integers, lengths and headers which are
+	// encoded in a longer form than the one chosen by a Writer, as well as
+	// timestamps which fit into a smaller layout. Non-negative integers in
+	// the smallest signed encoding, as written for an IntValue, are always
+	// accepted.
+	AllowNonMinimal bool
+	// AllowDuplicateKeys accepts maps with several keys of the same value.
+	// Keys are compared like Equal does, i.e. by value regardless of their
+	// encoding, so 1 and 1.0 are duplicates, but a string and binary data
+	// with the same bytes are not.
+	AllowDuplicateKeys bool
+	// AllowInvalidUTF8 accepts string values which are not valid UTF-8.
+	AllowInvalidUTF8 bool
+	// Stream validates a sequence of values up to the end of the input.
+	// Otherwise the input needs to consist of exactly one value.
+	Stream bool
+	// MaxDiagnostics is the number of diagnostics after which the validation
+	// stops. A zero value means that all diagnostics are reported.
+	MaxDiagnostics int
+}
+
+// Diagnostic describes a single problem found by Validate.
+type Diagnostic struct {
+	Offset int64  // byte offset of the offending value or header
+	Path   Path   // path of the offending value within its top-level value
+	Msg    string // description of the problem
+}
+
+// String returns a human-readable representation of the diagnostic.
+func (d Diagnostic) String() string {
+	if len(d.Path) == 0 {
+		return fmt.Sprintf("offset %d: %s", d.Offset, d.Msg)
+	}
+	return fmt.Sprintf("offset %d (%s): %s", d.Offset, d.Path, d.Msg)
+}
+
+// ValidationError is returned by Validate for malformed input. It holds all
+// diagnostics in the order in which they were found.
+type ValidationError struct {
+	Diagnostics []Diagnostic
+}
+
+// Error returns the error message of the error.
+func (e ValidationError) Error() string {
+	msg := e.Diagnostics[0].String()
+	if n := len(e.Diagnostics) - 1; n > 0 {
+		msg += fmt.Sprintf(" (and %d more)", n)
+	}
+	return msg
+}
+
+// Validate checks whether the MessagePack data read from r is well-formed.
+// Truncated values, the unused tag 0xc1 and trailing data after the value
+// are always reported. Depending on opts, invalid UTF-8 in strings, duplicate
+// map keys, non-minimal encodings and malformed timestamp extensions are
+// reported as well. Timestamps are malformed if their data length is not
+// one of the lengths accepted by Reader.ReadTime or if their nanoseconds
+// exceed 999999999.
+//
+// If any problems are found, a ValidationError is returned. Structural
+// problems stop the validation, all other problems are collected. Errors of
+// r are returned as is.
+func Validate(r io.Reader, opts ValidateOptions) error {
+	reader := NewReader(r)
+	err := validate(reader, opts)
+	releaseReader(reader)
+	return err
+}
+
+// ValidateBytes checks whether the MessagePack data in p is well-formed. See
+// Validate for details.
+func ValidateBytes(p []byte, opts ValidateOptions) error {
+	return validate(NewReaderBytes(p), opts)
+}
+
+func validate(r *Reader, opts ValidateOptions) error {
+	r.SetOptions(opts.Limits)
+	v := &validator{r: r, opts: opts}
+	if err := v.run(); err != nil && err != errValidationStopped {
+		return err
+	}
+	if len(v.diags) != 0 {
+		return ValidationError{Diagnostics: v.diags}
+	}
+	return nil
+}
+
+type validator struct {
+	r     *Reader
+	opts  ValidateOptions
+	path  Path
+	diags []Diagnostic
+	stack []validateFrame // open arrays and maps, innermost last
+
+	// The encoding of the current map key is captured to detect duplicate
+	// keys.
+	capturing bool
+	key       []byte
+}
+
+// validateFrame holds the state of an open array or map.
+type validateFrame struct {
+	n, i      uint64 // number of elements or entries, index of the current one
+	depth     int    // length of the path outside of the container
+	isMap     bool
+	needValue bool // the key of the current entry has been read
+
+	// For maps, the keys read so far and the start of the current key. As
+	// keys can be nested, the capture state outside of the current key is
+	// restored after it has been read.
+	keys      map[[sha256.Size]byte]struct{}
+	keyStart  int64
+	capturing bool
+	captured  []byte
+}
+
+func (v *validator) run() error {
+	for n := 0; ; n++ {
+		_, err := v.r.peek()
+		switch {
+		case err == io.EOF && (n > 0 || v.opts.Stream):
+			return nil
+		case err == nil && n > 0 && !v.opts.Stream:
+			return v.fatal(v.r.offset, "trailing data after value")
+		}
+
+		v.r.msgStart = v.r.offset
+		if err := v.value(); err != nil {
+			return err
+		}
+	}
+}
+
+// report adds a diagnostic for the value at the given offset. It returns
+// errValidationStopped if the maximum number of diagnostics is reached.
+func (v *validator) report(offset int64, format string, args ...interface{}) error {
+	v.diags = append(v.diags, Diagnostic{
+		Offset: offset,
+		Path:   append(Path(nil), v.path...),
+		Msg:    fmt.Sprintf(format, args...),
+	})
+	if v.opts.MaxDiagnostics > 0 && len(v.diags) >= v.opts.MaxDiagnostics {
+		return errValidationStopped
+	}
+	return nil
+}
+
+// fatal adds a diagnostic for a problem which stops the validation.
+func (v *validator) fatal(offset int64, format string, args ...interface{}) error {
+	v.report(offset, format, args...)
+	return errValidationStopped
+}
+
+func (v *validator) readErr(start int64, err error) error {
+	if _, ok := err.(LimitError); ok {
+		return v.fatal(start, "%v", err)
+	} else if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return v.fatal(start, "truncated value")
+	}
+	return err
+}
+
+// read consumes n bytes of the value starting at the given offset.
+func (v *validator) read(start int64, n int) ([]byte, error) {
+	p, err := v.r.read(n)
+	if err != nil {
+		return nil, v.readErr(start, err)
+	}
+	if v.capturing {
+		v.key = append(v.key, p...)
+	}
+	return p, nil
+}
+
+// skip consumes n bytes of the value starting at the given offset without
+// holding them in memory, unless they are captured.
+func (v *validator) skip(start int64, n int) error {
+	if v.capturing {
+		_, err := v.read(start, n)
+		return err
+	}
+	if err := v.r.discard(n); err != nil {
+		return v.readErr(start, err)
+	}
+	return nil
+}
+
+// header consumes the tag and the size-byte big-endian number which follows
+// it.
+func (v *validator) header(start int64, size int) (uint64, error) {
+	p, err := v.read(start, 1+size)
+	if err != nil {
+		return 0, err
+	}
+
+	switch size {
+	case 1:
+		return uint64(p[1]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(p[1:])), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(p[1:])), nil
+	default:
+		return binary.BigEndian.Uint64(p[1:]), nil
+	}
+}
+
+// nonMinimal reports a non-minimal encoding if cond holds.
+func (v *validator) nonMinimal(start int64, cond bool, format string, args ...interface{}) error {
+	if !cond || v.opts.AllowNonMinimal {
+		return nil
+	}
+	return v.report(start, "non-minimal "+format, args...)
+}
+
+// value validates the next value, including all of its nested values. Nested
+// arrays and maps are validated without recursion.
+func (v *validator) value() error {
+	for {
+		depth := len(v.stack)
+		if err := v.item(); err != nil {
+			return err
+		}
+		if len(v.stack) == depth {
+			if err := v.valueDone(); err != nil {
+				return err
+			}
+		}
+
+		for {
+			n := len(v.stack)
+			if n == 0 {
+				return nil
+			} else if f := &v.stack[n-1]; f.i < f.n {
+				break
+			}
+			v.path = v.path[:v.stack[n-1].depth]
+			v.stack = v.stack[:n-1]
+			if err := v.valueDone(); err != nil {
+				return err
+			}
+		}
+		v.beginElement()
+	}
+}
+
+// beginElement prepares the next element of the innermost container. Map
+// keys are captured until they have been read completely.
+func (v *validator) beginElement() {
+	f := &v.stack[len(v.stack)-1]
+	switch {
+	case !f.isMap:
+		v.path = append(v.path[:f.depth], int(f.i))
+	case !f.needValue:
+		v.path = v.path[:f.depth]
+		f.keyStart = v.r.offset
+		f.capturing, f.captured = v.capturing, v.key
+		v.capturing, v.key = true, nil
+	}
+}
+
+// valueDone is called after a value has been read completely.
+func (v *validator) valueDone() error {
+	if len(v.stack) == 0 {
+		return nil
+	}
+
+	f := &v.stack[len(v.stack)-1]
+	switch {
+	case !f.isMap:
+		f.i++
+		return nil
+	case f.needValue:
+		f.i++
+		f.needValue = false
+		return nil
+	}
+
+	key := v.key
+	v.capturing, v.key = f.capturing, f.captured
+	if v.capturing {
+		v.key = append(v.key, key...)
+	}
+	f.needValue = true
+
+	var k Value
+	err := unmarshalValue(key, &k)
+	if f.keys != nil {
+		id := keyID(k, key, err == nil)
+		if _, dup := f.keys[id]; dup {
+			if err := v.report(f.keyStart, "duplicate map key %s", formatValue(k)); err != nil {
+				return err
+			}
+		}
+		f.keys[id] = struct{}{}
+	}
+	v.path = append(v.path[:f.depth], keyPathElem(k))
+	return nil
+}
+
+// keyID returns an identifier of the map key k, which is the same for all
+// keys which are equal according to Equal. If the key could not be decoded,
+// it is identified by its encoding raw instead.
+func keyID(k Value, raw []byte, decoded bool) [sha256.Size]byte {
+	h := sha256.New()
+	if decoded {
+		vh := valueHasher{h: h}
+		vh.hash(k)
+	} else {
+		h.Write([]byte{'r'}) // not used as a type byte by valueHasher
+		h.Write(raw)
+	}
+
+	var id [sha256.Size]byte
+	h.Sum(id[:0])
+	return id
+}
+
+// item validates the next item. For arrays and maps, only the header is
+// consumed and a new frame is pushed.
+func (v *validator) item() error {
+	start := v.r.offset
+	tag, err := v.r.peek()
+	if err == io.EOF {
+		return v.fatal(start, "unexpected end of data")
+	} else if err != nil {
+		return err
+	}
+
+	switch {
+	case isPosFixintTag(tag), isNegFixintTag(tag):
+		_, err := v.read(start, 1)
+		return err
+	case isFixstrTag(tag):
+		return v.str(start, 0, uint64(readFixstr(tag)))
+	case isFixarrayTag(tag):
+		return v.array(start, 0, uint64(readFixarray(tag)))
+	case isFixmapTag(tag):
+		return v.mapValue(start, 0, uint64(readFixmap(tag)))
+	}
+
+	switch tag {
+	case tagNil, tagFalse, tagTrue:
+		_, err := v.read(start, 1)
+		return err
+
+	case tagFloat32:
+		return v.skip(start, 5)
+	case tagFloat64:
+		return v.skip(start, 9)
+
+	case tagUint8, tagUint16, tagUint32, tagUint64:
+		size := 1 << (tag - tagUint8)
+		u, err := v.header(start, size)
+		if err != nil {
+			return err
+		}
+		return v.nonMinimal(start, u <= math.MaxInt8 || fitsSmaller(u, size), "%s encoding of %d", tagName(tag), u)
+
+	case tagInt8, tagInt16, tagInt32, tagInt64:
+		size := 1 << (tag - tagInt8)
+		u, err := v.header(start, size)
+		if err != nil {
+			return err
+		}
+		i := int64(u<<(64-8*size)) >> (64 - 8*size) // sign extension
+		if size == 1 {
+			// non-negative integers may be written as int8 to keep their
+			// signedness, see IntValue
+			return v.nonMinimal(start, -32 <= i && i < 0, "%s encoding of %d", tagName(tag), i)
+		}
+		half := int64(1) << (4*size - 1)
+		return v.nonMinimal(start, -half <= i && i < half, "%s encoding of %d", tagName(tag), i)
+
+	case tagStr8, tagStr16, tagStr32:
+		size := 1 << (tag - tagStr8)
+		n, err := v.header(start, size)
+		if err != nil {
+			return err
+		}
+		if err := v.nonMinimal(start, n < 32 || fitsSmaller(n, size), "%s header for length %d", tagName(tag), n); err != nil {
+			return err
+		}
+		return v.str(start, 1+size, n)
+
+	case tagBin8, tagBin16, tagBin32:
+		size := 1 << (tag - tagBin8)
+		n, err := v.header(start, size)
+		if err != nil {
+			return err
+		}
+		if err := v.nonMinimal(start, fitsSmaller(n, size), "%s header for length %d", tagName(tag), n); err != nil {
+			return err
+		}
+		if err := v.checkLimit(start, "MaxBinaryLength", n, v.opts.Limits.MaxBinaryLength); err != nil {
+			return err
+		}
+		return v.skip(start, int(n))
+
+	case tagArray16, tagArray32:
+		size := 2 << (tag - tagArray16)
+		n, err := v.header(start, size)
+		if err != nil {
+			return err
+		}
+		if err := v.nonMinimal(start, n < 16 || size == 4 && fitsSmaller(n, size), "%s header for %d elements", tagName(tag), n); err != nil {
+			return err
+		}
+		return v.array(start, 1+size, n)
+
+	case tagMap16, tagMap32:
+		size := 2 << (tag - tagMap16)
+		n, err := v.header(start, size)
+		if err != nil {
+			return err
+		}
+		if err := v.nonMinimal(start, n < 16 || size == 4 && fitsSmaller(n, size), "%s header for %d entries", tagName(tag), n); err != nil {
+			return err
+		}
+		return v.mapValue(start, 1+size, n)
+
+	case tagFixExt1, tagFixExt2, tagFixExt4, tagFixExt8, tagFixExt16:
+		if _, err := v.read(start, 1); err != nil {
+			return err
+		}
+		return v.ext(start, 1<<(tag-tagFixExt1))
+
+	case tagExt8, tagExt16, tagExt32:
+		size := 1 << (tag - tagExt8)
+		n, err := v.header(start, size)
+		if err != nil {
+			return err
+		}
+		fixext := n == 1 || n == 2 || n == 4 || n == 8 || n == 16
+		if err := v.nonMinimal(start, size == 1 && fixext || fitsSmaller(n, size), "%s header for length %d", tagName(tag), n); err != nil {
+			return err
+		}
+		return v.ext(start, n)
+
+	default:
+		return v.fatal(start, "unknown tag 0x%02x", tag)
+	}
+}
+
+// str validates the data of a string value with n bytes. The header has
+// already been consumed, unless it is a fixstr tag.
+func (v *validator) str(start int64, headerLen int, n uint64) error {
+	if headerLen == 0 {
+		if _, err := v.read(start, 1); err != nil {
+			return err
+		}
+	}
+	if err := v.checkLimit(start, "MaxStringLength", n, v.opts.Limits.MaxStringLength); err != nil {
+		return err
+	}
+	if v.opts.AllowInvalidUTF8 {
+		return v.skip(start, int(n))
+	}
+
+	p, err := v.read(start, int(n))
+	if err != nil {
+		return err
+	} else if !utf8.Valid(p) {
+		return v.report(start, "invalid UTF-8 in string")
+	}
+	return nil
+}
+
+// ext validates an extension value with n bytes of data. The header, apart
+// from the extension type, has already been consumed.
+func (v *validator) ext(start int64, n uint64) error {
+	p, err := v.read(start, 1)
+	if err != nil {
+		return err
+	}
+	if err := v.checkLimit(start, "MaxExtLength", n, v.opts.Limits.MaxExtLength); err != nil {
+		return err
+	}
+	if int8(p[0]) != extTime {
+		return v.skip(start, int(n))
+	}
+
+	if n != 4 && n != 8 && n != 12 {
+		if err := v.report(start, "invalid timestamp length %d", n); err != nil {
+			return err
+		}
+		return v.skip(start, int(n))
+	}
+
+	data, err := v.read(start, int(n))
+	if err != nil {
+		return err
+	}
+
+	var (
+		secs  int64
+		nsecs uint32
+	)
+	switch n {
+	case 4:
+		secs = int64(binary.BigEndian.Uint32(data))
+	case 8:
+		tm := binary.BigEndian.Uint64(data)
+		secs, nsecs = int64(tm&0x3ffffffff), uint32(tm>>34)
+	case 12:
+		secs, nsecs = int64(binary.BigEndian.Uint64(data[4:])), binary.BigEndian.Uint32(data)
+	}
+
+	if nsecs > 999999999 {
+		return v.report(start, "invalid timestamp nanoseconds %d", nsecs)
+	}
+	fitsTimestamp32 := nsecs == 0 && secs>>32 == 0
+	fitsTimestamp64 := secs>>34 == 0
+	return v.nonMinimal(start, n == 8 && fitsTimestamp32 || n == 12 && fitsTimestamp64, "timestamp layout with length %d", n)
+}
+
+func (v *validator) array(start int64, headerLen int, n uint64) error {
+	return v.openContainer(start, headerLen, n, false)
+}
+
+func (v *validator) mapValue(start int64, headerLen int, n uint64) error {
+	return v.openContainer(start, headerLen, n, true)
+}
+
+// openContainer pushes a frame for an array or map with n elements or
+// entries. The header has already been consumed, unless it is a fixarray or
+// fixmap tag.
+func (v *validator) openContainer(start int64, headerLen int, n uint64, isMap bool) error {
+	if headerLen == 0 {
+		if _, err := v.read(start, 1); err != nil {
+			return err
+		}
+	}
+	if err := v.checkLimit(start, "MaxCollectionLength", n, v.opts.Limits.MaxCollectionLength); err != nil {
+		return err
+	}
+	if err := v.checkLimit(start, "MaxDepth", uint64(len(v.stack)+1), v.opts.Limits.MaxDepth); err != nil {
+		return err
+	}
+
+	f := validateFrame{n: n, depth: len(v.path), isMap: isMap}
+	if isMap && !v.opts.AllowDuplicateKeys {
+		f.keys = make(map[[sha256.Size]byte]struct{}, min(n, maxPrealloc))
+	}
+	v.stack = append(v.stack, f)
+	return nil
+}
+
+func (v *validator) checkLimit(start int64, name string, n uint64, max int) error {
+	if max > 0 && n > uint64(max) {
+		return v.fatal(start, "%v", LimitError{Limit: name, Value: int(n), Max: max})
+	}
+	return nil
+}
+
+// fitsSmaller reports whether n fits into a number of half the given size
+// in bytes.
+func fitsSmaller(n uint64, size int) bool {
+	return size > 1 && n>>(4*size) == 0
+}
+
+// tagName returns the name of a tag which is followed by a length or value.
+func tagName(tag byte) string {
+	switch tag {
+	case tagUint8, tagUint16, tagUint32, tagUint64:
+		return fmt.Sprintf("uint%d", 8<<(tag-tagUint8))
+	case tagInt8, tagInt16, tagInt32, tagInt64:
+		return fmt.Sprintf("int%d", 8<<(tag-tagInt8))
+	case tagStr8, tagStr16, tagStr32:
+		return fmt.Sprintf("str%d", 8<<(tag-tagStr8))
+	case tagBin8, tagBin16, tagBin32:
+		return fmt.Sprintf("bin%d", 8<<(tag-tagBin8))
+	case tagArray16, tagArray32:
+		return fmt.Sprintf("array%d", 16<<(tag-tagArray16))
+	case tagMap16, tagMap32:
+		return fmt.Sprintf("map%d", 16<<(tag-tagMap16))
+	case tagExt8, tagExt16, tagExt32:
+		return fmt.Sprintf("ext%d", 8<<(tag-tagExt8))
+	default:
+		return fmt.Sprintf("0x%02x", tag)
+	}
+}
@@ -0,0 +1,241 @@
+package msgpack
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	var valid bytes.Buffer
+	w := NewWriter(&valid)
+	w.WriteValue(map[string]interface{}{
+		"int":   -200,
+		"uint":  uint64(1 << 40),
+		"str":   strings.Repeat("x", 300),
+		"bin":   []byte{1, 2, 3},
+		"time":  time.Unix(1<<33, 5),
+		"array": []interface{}{nil, true, 1.5, float32(2)},
+	})
+	w.WriteValue(RawExt{Type: 5, Data: []byte{1, 2, 3}})
+	w.Flush()
+
+	tests := []struct {
+		raw      []byte
+		opts     ValidateOptions
+		expected []string
+	}{
+		{ // valid sequence
+			raw:  valid.Bytes(),
+			opts: ValidateOptions{Stream: true},
+		},
+		{
+			raw:  []byte{},
+			opts: ValidateOptions{Stream: true},
+		},
+		{ // structural problems
+			raw:      []byte{},
+			expected: []string{"offset 0: unexpected end of data"},
+		},
+		{
+			raw:      []byte{fixarrayTag(2), tagNil},
+			expected: []string{"offset 2 (/1): unexpected end of data"},
+		},
+		{
+			raw:      []byte{fixmapTag(1), fixstrTag(1), 'a', tagStr8, 0x25, 'a', 'b'},
+			expected: []string{"offset 3 (/a): truncated value"},
+		},
+		{
+			raw:      []byte{fixarrayTag(1), tagUint16, 0x01},
+			expected: []string{"offset 1 (/0): truncated value"},
+		},
+		{
+			raw:      []byte{fixarrayTag(2), 0xc1, tagNil},
+			expected: []string{"offset 1 (/0): unknown tag 0xc1"},
+		},
+		{
+			raw:      []byte{tagNil, tagNil},
+			expected: []string{"offset 1: trailing data after value"},
+		},
+		{ // invalid UTF-8
+			raw:      []byte{fixarrayTag(2), fixstrTag(1), 0xff, fixstrTag(1), 'a'},
+			expected: []string{"offset 1 (/0): invalid UTF-8 in string"},
+		},
+		{
+			raw:  []byte{fixstrTag(1), 0xff},
+			opts: ValidateOptions{AllowInvalidUTF8: true},
+		},
+		{ // duplicate keys
+			raw:      []byte{fixmapTag(3), posFixintTag(1), tagNil, fixstrTag(1), 'a', tagNil, tagInt64, 0, 0, 0, 0, 0, 0, 0, 1, tagNil},
+			opts:     ValidateOptions{AllowNonMinimal: true},
+			expected: []string{"offset 6: duplicate map key 1"},
+		},
+		{
+			raw:      []byte{fixmapTag(2), posFixintTag(1), tagNil, tagFloat32, 0x3f, 0x80, 0x00, 0x00, tagNil},
+			expected: []string{"offset 3: duplicate map key 1"},
+		},
+		{
+			raw:      []byte{fixarrayTag(1), fixmapTag(2), fixarrayTag(1), fixstrTag(1), 'a', tagNil, fixarrayTag(1), fixstrTag(1), 'a', tagTrue},
+			expected: []string{"offset 6 (/0): duplicate map key [\"a\"]"},
+		},
+		{
+			raw: []byte{fixmapTag(2), fixstrTag(1), 'a', tagNil, tagBin8, 0x01, 'a', tagNil},
+		},
+		{
+			raw:  []byte{fixmapTag(2), fixstrTag(1), 'a', tagNil, fixstrTag(1), 'a', tagNil},
+			opts: ValidateOptions{AllowDuplicateKeys: true},
+		},
+		{ // non-minimal encodings
+			raw: []byte{
+				fixarrayTag(9),
+				tagUint8, 0x7f,
+				tagUint32, 0x00, 0x00, 0xff, 0xff,
+				tagInt8, 0xe0,
+				tagInt32, 0xff, 0xff, 0xff, 0x80,
+				tagStr8, 0x01, 'a',
+				tagBin16, 0x00, 0x00,
+				tagArray16, 0x00, 0x00,
+				tagMap32, 0x00, 0x00, 0x00, 0x10,
+			},
+			opts: ValidateOptions{MaxDiagnostics: 8},
+			expected: []string{
+				"offset 1 (/0): non-minimal uint8 encoding of 127",
+				"offset 3 (/1): non-minimal uint32 encoding of 65535",
+				"offset 8 (/2): non-minimal int8 encoding of -32",
+				"offset 10 (/3): non-minimal int32 encoding of -128",
+				"offset 15 (/4): non-minimal str8 header for length 1",
+				"offset 18 (/5): non-minimal bin16 header for length 0",
+				"offset 21 (/6): non-minimal array16 header for 0 elements",
+				"offset 24 (/7): non-minimal map32 header for 16 entries",
+			},
+		},
+		{
+			raw: []byte{fixarrayTag(4), tagInt16, 0x00, 0xc8, tagBin8, 0x00, tagExt8, 0x03, 0x01, 1, 2, 3, tagArray16, 0x00, 0x10,
+				0xc0, 0xc0, 0xc0, 0xc0, 0xc0, 0xc0, 0xc0, 0xc0, 0xc0, 0xc0, 0xc0, 0xc0, 0xc0, 0xc0, 0xc0, 0xc0},
+		},
+		{
+			raw: []byte{fixarrayTag(2), tagInt8, 0x05, tagInt16, 0x00, 0x80},
+		},
+		{
+			raw:      []byte{tagInt16, 0x00, 0x05},
+			expected: []string{"offset 0: non-minimal int16 encoding of 5"},
+		},
+		{
+			raw:      []byte{tagExt8, 0x04, 0x01, 1, 2, 3, 4},
+			expected: []string{"offset 0: non-minimal ext8 header for length 4"},
+		},
+		{
+			raw:  []byte{tagUint64, 0, 0, 0, 0, 0, 0, 0, 1},
+			opts: ValidateOptions{AllowNonMinimal: true},
+		},
+		{ // timestamps
+			raw:      []byte{tagFixExt2, 0xff, 0x00, 0x00},
+			expected: []string{"offset 0: invalid timestamp length 2"},
+		},
+		{
+			raw:      []byte{tagFixExt4, 0xff, 0x00, 0x00, 0x00, 0x00, tagNil},
+			opts:     ValidateOptions{Stream: true},
+			expected: nil,
+		},
+		{
+			raw:      []byte{tagFixExt8, 0xff, 0xff, 0xff, 0xff, 0xfc, 0x00, 0x00, 0x00, 0x00},
+			expected: []string{"offset 0: invalid timestamp nanoseconds 1073741823"},
+		},
+		{
+			raw:      []byte{tagFixExt8, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
+			expected: []string{"offset 0: non-minimal timestamp layout with length 8"},
+		},
+		{
+			raw:      []byte{tagExt8, 0x0c, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
+			expected: []string{"offset 0: non-minimal timestamp layout with length 12"},
+		},
+		{ // limits
+			raw:      []byte{fixarrayTag(1), fixarrayTag(1), fixarrayTag(0)},
+			opts:     ValidateOptions{Limits: ReaderOptions{MaxDepth: 2}},
+			expected: []string{"offset 2 (/0/0): MaxDepth exceeded: 3 > 2"},
+		},
+		{
+			raw:      []byte{fixmapTag(1), fixstrTag(1), 'a', fixstrTag(3), 'a', 'b', 'c'},
+			opts:     ValidateOptions{Limits: ReaderOptions{MaxStringLength: 2}},
+			expected: []string{"offset 3 (/a): MaxStringLength exceeded: 3 > 2"},
+		},
+		{
+			raw:      []byte{fixarrayTag(2), tagNil, tagBin8, 0x02, 1, 2},
+			opts:     ValidateOptions{Limits: ReaderOptions{MaxMessageSize: 5}},
+			expected: []string{"offset 2 (/1): MaxMessageSize exceeded: 6 > 5"},
+		},
+	}
+
+	for _, test := range tests {
+		err := ValidateBytes(test.raw, test.opts)
+		if len(test.expected) == 0 {
+			if err != nil {
+				t.Errorf("unexpected error for %x: %v", test.raw, err)
+			}
+			continue
+		}
+
+		verr, ok := err.(ValidationError)
+		if !ok {
+			t.Errorf("unexpected error for %x: %v", test.raw, err)
+			continue
+		}
+		var diags []string
+		for _, d := range verr.Diagnostics {
+			diags = append(diags, d.String())
+		}
+		if strings.Join(diags, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("unexpected diagnostics for %x:\n%s", test.raw, strings.Join(diags, "\n"))
+		}
+
+		if err := Validate(bytes.NewReader(test.raw), test.opts); err == nil || err.Error() != verr.Error() {
+			t.Errorf("unexpected error when reading %x: %v", test.raw, err)
+		}
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	raw := []byte{fixarrayTag(3), fixstrTag(1), 0xff, tagUint8, 0x01, 0xc1}
+	err := ValidateBytes(raw, ValidateOptions{})
+	if err == nil || err.Error() != "offset 1 (/0): invalid UTF-8 in string (and 2 more)" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateWriterIntegers(t *testing.T) {
+	ints := []int64{math.MinInt8 - 1, math.MinInt8, -33, -32, -1, 0, 1, math.MaxInt8, math.MaxInt8 + 1, math.MaxUint8, math.MaxUint8 + 1}
+	for _, canonical := range []bool{false, true} {
+		w := newAppendWriter(nil)
+		w.SetCanonical(canonical)
+		for _, i := range ints {
+			w.WriteInt64(i)
+			if i >= 0 {
+				w.WriteUint64(uint64(i))
+			}
+		}
+		if err := ValidateBytes(w.buf, ValidateOptions{Stream: true}); err != nil {
+			t.Errorf("unexpected error (canonical: %t): %v", canonical, err)
+		}
+	}
+}
+
+func TestValidateDeepNesting(t *testing.T) {
+	limitStack(t)
+
+	const depth = 200000
+	if err := ValidateBytes(nestedArrays(depth), ValidateOptions{}); err != nil {
+		t.Errorf("unexpected error for nested arrays: %v", err)
+	}
+	if err := ValidateBytes(nestedMaps(depth), ValidateOptions{}); err != nil {
+		t.Errorf("unexpected error for nested maps: %v", err)
+	}
+
+	err := ValidateBytes(nestedArrays(depth)[:depth], ValidateOptions{})
+	if verr, ok := err.(ValidationError); !ok {
+		t.Errorf("unexpected error for truncated value: %v", err)
+	} else if d := verr.Diagnostics[0]; d.Offset != depth || len(d.Path) != depth || d.Msg != "unexpected end of data" {
+		t.Errorf("unexpected diagnostic for truncated value: offset %d, path length %d: %s", d.Offset, len(d.Path), d.Msg)
+	}
+}
@@ -0,0 +1,560 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Value holds an arbitrary MessagePack value. It can be used to inspect,
+// build and rewrite documents whose shape is not known in advance. A value
+// preserves the wire family it was decoded from: signed and unsigned
+// integers, 32-bit and 64-bit floating-point values, strings and binary data,
+// as well as the type of extension values, are kept apart and written back
+// as such.
+//
+// The zero value represents nil. Value implements the Encoder and Decoder
+// interfaces.
+type Value struct {
+	typ   Type
+	num   uint64 // bool, int, uint and float bits
+	f32   bool   // float was decoded from or created as a 32-bit float
+	ext   int8
+	str   string
+	bytes []byte // binary and extension data
+	tm    time.Time
+	arr   []Value
+	kvs   []KeyValue
+}
+
+// KeyValue holds a single entry of a map value.
+type KeyValue struct {
+	Key   Value
+	Value Value
+}
+
+// NilValue returns a nil value.
+func NilValue() Value {
+	return Value{}
+}
+
+// BoolValue returns a boolean value.
+func BoolValue(b bool) Value {
+	v := Value{typ: Bool}
+	if b {
+		v.num = 1
+	}
+	return v
+}
+
+// IntValue returns a signed integer value.
+func IntValue(i int64) Value {
+	return Value{typ: Int, num: uint64(i)}
+}
+
+// UintValue returns an unsigned integer value.
+func UintValue(u uint64) Value {
+	return Value{typ: Uint, num: u}
+}
+
+// Float32Value returns a 32-bit floating-point value.
+func Float32Value(f float32) Value {
+	return Value{typ: Float, num: math.Float64bits(float64(f)), f32: true}
+}
+
+// FloatValue returns a 64-bit floating-point value.
+func FloatValue(f float64) Value {
+	return Value{typ: Float, num: math.Float64bits(f)}
+}
+
+// StringValue returns a string value.
+func StringValue(s string) Value {
+	return Value{typ: String, str: s}
+}
+
+// BytesValue returns a binary value.
+func BytesValue(b []byte) Value {
+	return Value{typ: Bytes, bytes: b}
+}
+
+// TimeValue returns a time value.
+func TimeValue(tm time.Time) Value {
+	return Value{typ: Time, tm: tm}
+}
+
+// ExtValue returns an extension value with the given type and data.
+func ExtValue(typ int8, data []byte) Value {
+	return Value{typ: Ext, ext: typ, bytes: data}
+}
+
+// ArrayValue returns an array value holding elems.
+func ArrayValue(elems ...Value) Value {
+	if elems == nil {
+		elems = []Value{}
+	}
+	return Value{typ: Array, arr: elems}
+}
+
+// MapValue returns a map value holding entries. The order of the entries is
+// preserved.
+func MapValue(entries ...KeyValue) Value {
+	if entries == nil {
+		entries = []KeyValue{}
+	}
+	return Value{typ: Map, kvs: entries}
+}
+
+// Type returns the type of v.
+func (v Value) Type() Type {
+	if v.typ == "" {
+		return Nil
+	}
+	return v.typ
+}
+
+// IsNil reports whether v is nil.
+func (v Value) IsNil() bool {
+	return v.Type() == Nil
+}
+
+// Bool returns the boolean value of v, or false if v is not a boolean.
+func (v Value) Bool() bool {
+	return v.typ == Bool && v.num != 0
+}
+
+// Int returns the integer value of v. Unsigned integers are converted if
+// they fit into an int64. For all other values, 0 is returned.
+func (v Value) Int() int64 {
+	switch {
+	case v.typ == Int:
+		return int64(v.num)
+	case v.typ == Uint && v.num <= math.MaxInt64:
+		return int64(v.num)
+	default:
+		return 0
+	}
+}
+
+// Uint returns the unsigned integer value of v. Signed integers are
+// converted if they are not negative. For all other values, 0 is returned.
+func (v Value) Uint() uint64 {
+	switch {
+	case v.typ == Uint:
+		return v.num
+	case v.typ == Int && int64(v.num) >= 0:
+		return v.num
+	default:
+		return 0
+	}
+}
+
+// Float returns the floating-point value of v. Integers are converted to
+// floating-point values. For all other values, 0 is returned.
+func (v Value) Float() float64 {
+	switch v.typ {
+	case Float:
+		return math.Float64frombits(v.num)
+	case Int:
+		return float64(int64(v.num))
+	case Uint:
+		return float64(v.num)
+	default:
+		return 0
+	}
+}
+
+// Str returns the string value of v, or an empty string if v is not a
+// string.
+func (v Value) Str() string {
+	return v.str
+}
+
+// Bytes returns the binary data of v, or nil if v is not a binary value.
+func (v Value) Bytes() []byte {
+	if v.typ != Bytes {
+		return nil
+	}
+	return v.bytes
+}
+
+// Time returns the time value of v, or the zero time if v is not a time.
+func (v Value) Time() time.Time {
+	return v.tm
+}
+
+// Ext returns the extension type and data of v. If v is not an extension
+// value, 0 and nil are returned.
+func (v Value) Ext() (int8, []byte) {
+	if v.typ != Ext {
+		return 0, nil
+	}
+	return v.ext, v.bytes
+}
+
+// Len returns the number of elements of an array, the number of entries of
+// a map, or the length of a string, binary or extension value. For all other
+// values, 0 is returned.
+func (v Value) Len() int {
+	switch v.typ {
+	case Array:
+		return len(v.arr)
+	case Map:
+		return len(v.kvs)
+	case String:
+		return len(v.str)
+	case Bytes, Ext:
+		return len(v.bytes)
+	default:
+		return 0
+	}
+}
+
+// Index returns the i-th element of an array. If v is not an array or i is
+// out of range, a nil value is returned.
+func (v Value) Index(i int) Value {
+	if i < 0 || i >= len(v.arr) {
+		return Value{}
+	}
+	return v.arr[i]
+}
+
+// Get returns the value of the first map entry whose key is the string key.
+// If v is not a map or no such entry exists, a nil value is returned.
+func (v Value) Get(key string) Value {
+	if i := v.find(key); i >= 0 {
+		return v.kvs[i].Value
+	}
+	return Value{}
+}
+
+// Has reports whether v is a map which holds an entry for the string key.
+func (v Value) Has(key string) bool {
+	return v.find(key) >= 0
+}
+
+// Array returns the elements of an array value, or nil if v is not an array.
+// The returned slice shares its storage with v.
+func (v Value) Array() []Value {
+	return v.arr
+}
+
+// Map returns the entries of a map value in their original order, or nil if
+// v is not a map. The returned slice shares its storage with v.
+func (v Value) Map() []KeyValue {
+	return v.kvs
+}
+
+// SetIndex replaces the i-th element of an array value. It panics if v is
+// not an array or i is out of range.
+func (v *Value) SetIndex(i int, elem Value) {
+	if v.typ != Array {
+		panic("msgpack: SetIndex called on " + string(v.Type()) + " value")
+	}
+	v.arr[i] = elem
+}
+
+// Append appends elems to an array value. It panics if v is not an array.
+func (v *Value) Append(elems ...Value) {
+	if v.typ != Array {
+		panic("msgpack: Append called on " + string(v.Type()) + " value")
+	}
+	v.arr = append(v.arr, elems...)
+}
+
+// Set replaces the value of the first map entry whose key is the string key.
+// If no such entry exists, a new entry is appended. It panics if v is not a
+// map.
+func (v *Value) Set(key string, val Value) {
+	if v.typ != Map {
+		panic("msgpack: Set called on " + string(v.Type()) + " value")
+	}
+	if i := v.find(key); i >= 0 {
+		v.kvs[i].Value = val
+	} else {
+		v.kvs = append(v.kvs, KeyValue{Key: StringValue(key), Value: val})
+	}
+}
+
+// Delete removes all map entries whose key is the string key and reports
+// whether an entry was removed. The order of the remaining entries is
+// preserved.
+func (v *Value) Delete(key string) bool {
+	kvs := v.kvs[:0]
+	for _, kv := range v.kvs {
+		if kv.Key.typ != String || kv.Key.str != key {
+			kvs = append(kvs, kv)
+		}
+	}
+
+	deleted := len(kvs) != len(v.kvs)
+	for i := len(kvs); i < len(v.kvs); i++ {
+		v.kvs[i] = KeyValue{}
+	}
+	v.kvs = kvs
+	return deleted
+}
+
+func (v Value) find(key string) int {
+	for i, kv := range v.kvs {
+		if kv.Key.typ == String && kv.Key.str == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// EncodeMsgpack implements the Encoder interface. Nested arrays and maps are
+// encoded without recursion.
+func (v Value) EncodeMsgpack(w *Writer) error {
+	var stack []encodeFrame
+	next := &v
+	for {
+		switch next.Type() {
+		case Array:
+			if err := w.WriteArrayHeader(len(next.arr)); err != nil {
+				return err
+			}
+			stack = append(stack, encodeFrame{v: next})
+		case Map:
+			f := encodeFrame{v: next}
+			if w.canonical {
+				// The entries are sorted when the map is closed, see
+				// Writer.writeMap.
+				f.parent = w
+				w = newAppendWriter(nil)
+				w.canonical = true
+			}
+			if err := w.WriteMapHeader(len(next.kvs)); err != nil {
+				return err
+			}
+			stack = append(stack, f)
+		default:
+			if err := next.encodeScalar(w); err != nil {
+				return err
+			}
+		}
+
+		for {
+			n := len(stack)
+			if n == 0 {
+				return nil
+			}
+			f := &stack[n-1]
+			if next = f.next(); next != nil {
+				break
+			}
+
+			if f.parent != nil {
+				sorted, err := sortMapEntries(w.buf)
+				if err != nil {
+					return err
+				}
+				w = f.parent
+				if err := w.writePayload(sorted); err != nil {
+					return err
+				}
+			}
+			stack = stack[:n-1]
+		}
+	}
+}
+
+// encodeFrame holds an array or map which is encoded by Value.EncodeMsgpack.
+type encodeFrame struct {
+	v      *Value
+	i      int     // number of elements, or keys and values, written so far
+	parent *Writer // writer enclosing a map in canonical mode
+}
+
+// next returns the next element, key or value to be written, or nil if the
+// container is exhausted.
+func (f *encodeFrame) next() *Value {
+	i := f.i
+	switch {
+	case f.v.typ == Array && i < len(f.v.arr):
+		f.i++
+		return &f.v.arr[i]
+	case f.v.typ == Map && i < 2*len(f.v.kvs):
+		f.i++
+		if i%2 == 0 {
+			return &f.v.kvs[i/2].Key
+		}
+		return &f.v.kvs[i/2].Value
+	default:
+		return nil
+	}
+}
+
+// encodeScalar writes a value which is neither an array nor a map.
+func (v *Value) encodeScalar(w *Writer) error {
+	switch v.Type() {
+	case Nil:
+		return w.WriteNil()
+	case Bool:
+		return w.WriteBool(v.num != 0)
+	case Int:
+		return writeSignedInt(w, int64(v.num))
+	case Uint:
+		return w.WriteUint64(v.num)
+	case Float:
+		if v.f32 {
+			return w.WriteFloat32(float32(math.Float64frombits(v.num)))
+		}
+		return w.WriteFloat64(math.Float64frombits(v.num))
+	case String:
+		return w.WriteString(v.str)
+	case Bytes:
+		return w.WriteBytes(v.bytes)
+	case Time:
+		return w.WriteTime(v.tm)
+	case Ext:
+		if v.ext == extTime {
+			return newInvalidExtensionError(v.ext)
+		}
+		return w.writeExtension(v.ext, v.bytes)
+	default:
+		return errorf("invalid value type %s", v.typ)
+	}
+}
+
+// DecodeMsgpack implements the Decoder interface. Nested arrays and maps are
+// decoded without recursion, so the nesting depth is only bounded by the
+// MaxDepth limit of r.
+func (v *Value) DecodeMsgpack(r *Reader) error {
+	var b valueBuilder
+	r.beginValue()
+	if err := r.walk(b.add, b.end); err != nil {
+		return err
+	}
+	r.endValue()
+	*v = b.root
+	return nil
+}
+
+// valueBuilder assembles a value from the items visited by Reader.walk.
+type valueBuilder struct {
+	root  Value
+	stack []valueFrame // open arrays and maps
+}
+
+type valueFrame struct {
+	v         *Value
+	needValue bool // the last map entry is still missing its value
+}
+
+func (b *valueBuilder) add(it item, p []byte) error {
+	val, err := itemValue(it, p)
+	if err != nil {
+		return err
+	}
+
+	elem := &b.root
+	if n := len(b.stack); n == 0 {
+		b.root = val
+	} else if f := &b.stack[n-1]; f.v.typ == Array {
+		f.v.arr = append(f.v.arr, val)
+		elem = &f.v.arr[len(f.v.arr)-1]
+	} else if f.needValue {
+		kv := &f.v.kvs[len(f.v.kvs)-1]
+		kv.Value, f.needValue = val, false
+		elem = &kv.Value
+	} else {
+		f.v.kvs = append(f.v.kvs, KeyValue{Key: val})
+		f.needValue = true
+		elem = &f.v.kvs[len(f.v.kvs)-1].Key
+	}
+
+	// The elements of a container are added before any of its siblings,
+	// so elem stays valid until the container is closed.
+	if it.isContainer() {
+		b.stack = append(b.stack, valueFrame{v: elem})
+	}
+	return nil
+}
+
+func (b *valueBuilder) end(isMap bool) error {
+	b.stack = b.stack[:len(b.stack)-1]
+	return nil
+}
+
+// itemValue returns the value of a single item. Arrays and maps are returned
+// without elements.
+func itemValue(it item, p []byte) (Value, error) {
+	data := p[it.headerLen:]
+	switch it.typ {
+	case Nil:
+		return Value{}, nil
+	case Bool:
+		return BoolValue(p[0] == tagTrue), nil
+	case Int:
+		return IntValue(parseInt(p)), nil
+	case Uint:
+		return UintValue(parseUint(p)), nil
+	case Float:
+		if p[0] == tagFloat32 {
+			return Float32Value(float32(parseFloat(p))), nil
+		}
+		return FloatValue(parseFloat(p)), nil
+	case String:
+		return StringValue(string(data)), nil
+	case Bytes:
+		return BytesValue(append([]byte{}, data...)), nil
+	case Time:
+		tm, err := parseTimestamp(data)
+		return TimeValue(tm), err
+	case Ext:
+		return ExtValue(it.ext, append([]byte{}, data...)), nil
+	case Array:
+		return Value{typ: Array, arr: make([]Value, 0, min(it.n, maxPrealloc))}, nil
+	default: // Map
+		return Value{typ: Map, kvs: make([]KeyValue, 0, min(it.n, maxPrealloc))}, nil
+	}
+}
+
+// maxValueDepth is the maximum nesting depth of values which are processed
+// recursively, e.g. by Equal or Diff.
+const maxValueDepth = 10000
+
+// newValueReader returns a reader for p, which limits the nesting depth to
+// maxValueDepth.
+func newValueReader(p []byte) *Reader {
+	r := NewReaderBytes(p)
+	r.SetOptions(ReaderOptions{MaxDepth: maxValueDepth})
+	return r
+}
+
+// unmarshalValue unmarshals p into v like Unmarshal does, but returns a
+// LimitError if the nesting depth exceeds maxValueDepth.
+func unmarshalValue(p []byte, v *Value) error {
+	return v.DecodeMsgpack(newValueReader(p))
+}
+
+// writeSignedInt writes i with the smallest signed integer encoding. Unlike
+// Writer.WriteInt64, non-negative integers are never written as positive
+// fixints, which are read as unsigned integers. In canonical mode, i is
+// written like Writer.WriteInt64 does.
+func writeSignedInt(w *Writer, i int64) error {
+	if i < 0 || w.canonical {
+		return w.WriteInt64(i)
+	}
+
+	var buf [9]byte
+	switch {
+	case i <= math.MaxInt8:
+		buf[0] = tagInt8
+		buf[1] = byte(i)
+		return w.write(buf[:2])
+	case i <= math.MaxInt16:
+		buf[0] = tagInt16
+		binary.BigEndian.PutUint16(buf[1:], uint16(i))
+		return w.write(buf[:3])
+	case i <= math.MaxInt32:
+		buf[0] = tagInt32
+		binary.BigEndian.PutUint32(buf[1:], uint32(i))
+		return w.write(buf[:5])
+	default:
+		buf[0] = tagInt64
+		binary.BigEndian.PutUint64(buf[1:], uint64(i))
+		return w.write(buf[:])
+	}
+}
@@ -0,0 +1,258 @@
+package msgpack
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+func TestValueRoundtrip(t *testing.T) {
+	tests := []struct {
+		value Value
+		data  []byte
+	}{
+		{value: NilValue(), data: []byte{tagNil}},
+		{value: BoolValue(true), data: []byte{tagTrue}},
+		{value: IntValue(7), data: []byte{tagInt8, 0x07}},
+		{value: IntValue(-7), data: []byte{negFixintTag(-7)}},
+		{value: IntValue(300), data: []byte{tagInt16, 0x01, 0x2c}},
+		{value: UintValue(7), data: []byte{posFixintTag(7)}},
+		{value: UintValue(300), data: []byte{tagUint16, 0x01, 0x2c}},
+		{value: Float32Value(1.5), data: []byte{tagFloat32, 0x3f, 0xc0, 0x00, 0x00}},
+		{value: FloatValue(1.5), data: []byte{tagFloat64, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{value: StringValue("foo"), data: []byte{fixstrTag(3), 'f', 'o', 'o'}},
+		{value: BytesValue([]byte("foo")), data: []byte{tagBin8, 0x03, 'f', 'o', 'o'}},
+		{value: BytesValue([]byte{}), data: []byte{tagBin8, 0x00}},
+		{value: ExtValue(-2, []byte{0x01}), data: []byte{tagFixExt1, 0xfe, 0x01}},
+		{
+			value: TimeValue(time.Date(2017, time.September, 26, 13, 14, 15, 0, time.UTC)),
+			data:  []byte{tagFixExt4, 0xff, 0x59, 0xca, 0x52, 0xa7},
+		},
+		{value: ArrayValue(), data: []byte{fixarrayTag(0)}},
+		{
+			value: ArrayValue(UintValue(1), StringValue("a"), NilValue()),
+			data:  []byte{fixarrayTag(3), posFixintTag(1), fixstrTag(1), 'a', tagNil},
+		},
+		{
+			value: ArrayValue(IntValue(0), IntValue(127), IntValue(128), IntValue(-32)),
+			data:  []byte{fixarrayTag(4), tagInt8, 0x00, tagInt8, 0x7f, tagInt16, 0x00, 0x80, negFixintTag(-32)},
+		},
+		{
+			value: MapValue(
+				KeyValue{Key: StringValue("b"), Value: UintValue(1)},
+				KeyValue{Key: IntValue(-1), Value: MapValue()},
+				KeyValue{Key: StringValue("a"), Value: ArrayValue(BoolValue(false))},
+			),
+			data: []byte{
+				fixmapTag(3),
+				fixstrTag(1), 'b', posFixintTag(1),
+				negFixintTag(-1), fixmapTag(0),
+				fixstrTag(1), 'a', fixarrayTag(1), tagFalse,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		data, err := Marshal(test.value)
+		if err != nil {
+			t.Errorf("unexpected marshal error for %x: %v", test.data, err)
+			continue
+		} else if !bytes.Equal(data, test.data) {
+			t.Errorf("unexpected encoding for %x: %x", test.data, data)
+		}
+		if err := ValidateBytes(data, ValidateOptions{}); err != nil {
+			t.Errorf("unexpected validation error for %x: %v", test.data, err)
+		}
+
+		var v Value
+		if err := UnmarshalValue(test.data, &v); err != nil {
+			t.Errorf("unexpected unmarshal error for %x: %v", test.data, err)
+		} else if !reflect.DeepEqual(v, test.value) {
+			t.Errorf("unexpected value for %x: %#v", test.data, v)
+		}
+	}
+}
+
+func TestValueAccessors(t *testing.T) {
+	v := MapValue(
+		KeyValue{Key: StringValue("id"), Value: UintValue(13)},
+		KeyValue{Key: StringValue("name"), Value: StringValue("foo")},
+		KeyValue{Key: StringValue("tags"), Value: ArrayValue(StringValue("a"), StringValue("b"))},
+		KeyValue{Key: StringValue("score"), Value: IntValue(-3)},
+	)
+
+	switch {
+	case v.Type() != Map || v.Len() != 4:
+		t.Errorf("unexpected map: %s with %d entries", v.Type(), v.Len())
+	case v.Get("id").Int() != 13 || v.Get("id").Uint() != 13 || v.Get("id").Float() != 13:
+		t.Errorf("unexpected id: %#v", v.Get("id"))
+	case v.Get("score").Int() != -3 || v.Get("score").Uint() != 0:
+		t.Errorf("unexpected score: %#v", v.Get("score"))
+	case v.Get("name").Str() != "foo" || v.Get("name").Int() != 0:
+		t.Errorf("unexpected name: %#v", v.Get("name"))
+	case v.Get("tags").Index(1).Str() != "b" || !v.Get("tags").Index(2).IsNil():
+		t.Errorf("unexpected tags: %#v", v.Get("tags"))
+	case !v.Get("missing").IsNil() || v.Has("missing") || !v.Has("id"):
+		t.Errorf("unexpected lookup of missing key")
+	case !v.Index(0).IsNil() || !v.Get("tags").Get("a").IsNil():
+		t.Errorf("unexpected lookup in wrong type")
+	}
+
+	var keys []string
+	for _, kv := range v.Map() {
+		keys = append(keys, kv.Key.Str())
+	}
+	if !reflect.DeepEqual(keys, []string{"id", "name", "tags", "score"}) {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+	if typ, data := ExtValue(5, []byte{1}).Ext(); typ != 5 || !bytes.Equal(data, []byte{1}) {
+		t.Errorf("unexpected extension: %d %x", typ, data)
+	}
+}
+
+func TestValueModify(t *testing.T) {
+	v := MapValue(
+		KeyValue{Key: StringValue("a"), Value: UintValue(1)},
+		KeyValue{Key: StringValue("b"), Value: UintValue(2)},
+		KeyValue{Key: StringValue("a"), Value: UintValue(3)},
+	)
+	v.Set("b", StringValue("two"))
+	v.Set("c", ArrayValue())
+	if !v.Delete("a") || v.Delete("a") {
+		t.Errorf("unexpected delete result")
+	}
+
+	tags := v.Get("c")
+	tags.Append(UintValue(1), UintValue(2))
+	tags.SetIndex(0, NilValue())
+	v.Set("c", tags)
+
+	data, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []byte{
+		fixmapTag(2),
+		fixstrTag(1), 'b', fixstrTag(3), 't', 'w', 'o',
+		fixstrTag(1), 'c', fixarrayTag(2), tagNil, posFixintTag(2),
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("unexpected encoding: %x", data)
+	}
+
+	defer func() {
+		if msg := recover(); msg != "msgpack: Append called on string value" {
+			t.Errorf("unexpected panic: %v", msg)
+		}
+	}()
+	s := StringValue("foo")
+	s.Append(NilValue())
+}
+
+func TestValueDeepNesting(t *testing.T) {
+	limitStack(t)
+
+	const depth = 200000
+	var root Value
+	if err := Unmarshal(nestedArrays(depth), &root); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err := Marshal(root); err != nil {
+		t.Errorf("unexpected marshal error: %v", err)
+	} else if !bytes.Equal(data, nestedArrays(depth)) {
+		t.Errorf("unexpected encoding of nested arrays")
+	}
+
+	v := root
+	for i := 0; i < depth; i++ {
+		if v.Type() != Array || v.Len() != 1 {
+			t.Fatalf("unexpected value at depth %d: %s", i, v.Type())
+		}
+		v = v.Index(0)
+	}
+	if !v.IsNil() {
+		t.Errorf("unexpected innermost value: %s", v.Type())
+	}
+
+	// maps are sorted by the encoding of their keys in canonical mode, which
+	// copies every nested map, so a smaller depth is used
+	const mapDepth = 1000
+	var m Value
+	if err := Unmarshal(nestedMaps(mapDepth), &m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.Set("0", NilValue())
+	w := newAppendWriter(nil)
+	w.SetCanonical(true)
+	if err := m.EncodeMsgpack(w); err != nil {
+		t.Errorf("unexpected canonical encoding error: %v", err)
+	} else if expected := append([]byte{fixmapTag(2), fixstrTag(1), '0', tagNil}, nestedMaps(mapDepth)[1:]...); !bytes.Equal(w.buf, expected) {
+		t.Errorf("unexpected canonical encoding of nested maps")
+	}
+
+	r := NewReaderBytes(nestedArrays(depth))
+	r.SetOptions(ReaderOptions{MaxDepth: 100})
+	if err := v.DecodeMsgpack(r); err == nil || err.Error() != "MaxDepth exceeded: 101 > 100" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValueDepthLimit(t *testing.T) {
+	limitStack(t)
+
+	arrays := nestedArrays(maxValueDepth + 1)
+	maps := nestedMaps(maxValueDepth + 1)
+	tests := map[string]func() error{
+		"Equal": func() error {
+			_, err := Equal(arrays, arrays)
+			return err
+		},
+		"Hash": func() error {
+			return Hash(sha256.New(), arrays)
+		},
+		"Diff": func() error {
+			_, err := Diff(arrays, arrays)
+			return err
+		},
+		"MergePatch": func() error {
+			_, err := MergePatch(maps, maps)
+			return err
+		},
+		"CreateMergePatch": func() error {
+			_, err := CreateMergePatch(maps, maps)
+			return err
+		},
+	}
+
+	for name, f := range tests {
+		err := f()
+		if _, ok := err.(LimitError); !ok {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+	}
+
+	if eq, err := Equal(nestedArrays(maxValueDepth), nestedArrays(maxValueDepth)); err != nil || !eq {
+		t.Errorf("unexpected result: %v, %v", eq, err)
+	}
+}
+
+// nestedArrays returns the encoding of nil nested in depth arrays.
+func nestedArrays(depth int) []byte {
+	return append(bytes.Repeat([]byte{fixarrayTag(1)}, depth), tagNil)
+}
+
+// nestedMaps returns the encoding of nil nested in depth maps, which hold
+// a single entry with the key "a".
+func nestedMaps(depth int) []byte {
+	return append(bytes.Repeat([]byte{fixmapTag(1), fixstrTag(1), 'a'}, depth), tagNil)
+}
+
+// limitStack limits the stack size for the duration of the test, so that
+// unbounded recursion fails fast.
+func limitStack(t *testing.T) {
+	prev := debug.SetMaxStack(16 << 20)
+	t.Cleanup(func() { debug.SetMaxStack(prev) })
+}
@@ -0,0 +1,348 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// SkipValue can be returned by Visitor.OnBeginArray and Visitor.OnBeginMap
+// to skip all elements of the array or map. The corresponding end callback
+// is not called for skipped containers. When returned by any other
+// callback, SkipValue is ignored.
+var SkipValue error = errorString("skip value")
+
+// Visitor defines callbacks for every kind of value visited by Walk. Slices
+// passed to a callback refer to the reader's internal buffer and are only
+// valid until the callback returns. If a callback returns an error other
+// than SkipValue, the walk is stopped and the error is returned by Walk.
+type Visitor interface {
+	OnNil() error
+	OnBool(b bool) error
+	OnInt(i int64) error
+	OnUint(u uint64) error
+	OnFloat(f float64) error
+	OnString(s []byte) error
+	OnBytes(b []byte) error
+	OnTime(tm time.Time) error
+	OnExt(id int8, data []byte) error
+
+	// OnBeginArray and OnBeginMap are called with the number of elements
+	// and entries, respectively. The keys and values of a map's entries
+	// are visited alternately.
+	OnBeginArray(n int) error
+	OnEndArray() error
+	OnBeginMap(n int) error
+	OnEndMap() error
+}
+
+// Walk reads the next value, including all of its nested values, from r and
+// calls the matching callbacks of v in a single pass. No intermediate values
+// are allocated and nested containers are traversed without recursion. If the
+// stream is exhausted before a new value starts, io.EOF is returned.
+func Walk(r *Reader, v Visitor) error {
+	r.beginValue()
+	err := r.walk(func(it item, p []byte) error {
+		return visitItem(v, &it, p)
+	}, func(isMap bool) error {
+		if isMap {
+			return v.OnEndMap()
+		}
+		return v.OnEndArray()
+	})
+	if err != nil {
+		return err
+	}
+	r.endValue()
+	return nil
+}
+
+func visitItem(v Visitor, it *item, p []byte) error {
+	switch it.typ {
+	case Nil:
+		return v.OnNil()
+	case Bool:
+		return v.OnBool(p[0] == tagTrue)
+	case Int:
+		return v.OnInt(parseInt(p))
+	case Uint:
+		return v.OnUint(parseUint(p))
+	case Float:
+		return v.OnFloat(parseFloat(p))
+	case String:
+		return v.OnString(p[it.headerLen:])
+	case Bytes:
+		return v.OnBytes(p[it.headerLen:])
+	case Time:
+		tm, err := parseTimestamp(p[it.headerLen:])
+		if err != nil {
+			return err
+		}
+		return v.OnTime(tm)
+	case Ext:
+		return v.OnExt(it.ext, p[it.headerLen:])
+	case Array:
+		return v.OnBeginArray(it.n)
+	default: // Map
+		return v.OnBeginMap(it.n)
+	}
+}
+
+// item describes a single value in the MessagePack stream. For arrays and
+// maps, an item only covers the header.
+type item struct {
+	typ       Type
+	headerLen int  // length of the tag and all length and type fields
+	n         int  // data length of strings, binary and extension values, number of elements of arrays and maps
+	ext       int8 // type of extension values
+}
+
+func (it *item) isContainer() bool {
+	return it.typ == Array || it.typ == Map
+}
+
+// size returns the encoded size of the item, excluding any nested values.
+func (it *item) size() int {
+	if it.isContainer() {
+		return it.headerLen
+	}
+	return it.headerLen + it.n
+}
+
+// walk reads the next value, including all of its nested values, without
+// recursion. Before walk is called, beginValue has to be called.
+//
+// For every item, visit is called with the item's encoding, which is the
+// complete encoding for scalar, string, binary and extension values and the
+// header for arrays and maps. If visit returns SkipValue for an array or a
+// map, all of its elements are discarded without calling visit. Otherwise,
+// end is called after the last element of an array or map was visited. If
+// visit is nil, the whole value is discarded.
+func (r *Reader) walk(visit func(it item, p []byte) error, end func(isMap bool) error) (err error) {
+	stack := r.walkStack[:0]
+	defer func() {
+		r.walkStack = stack[:0]
+		if err != nil && len(stack) != 0 {
+			err = unexpectedEOF(err)
+		}
+	}()
+
+	skipBelow := -1 // stack length below which all items are skipped, or -1
+	if visit == nil {
+		skipBelow = 0
+	}
+
+	for {
+		it, err := r.peekItem()
+		if err != nil {
+			return err
+		}
+		if it.isContainer() {
+			if err := checkLimit("MaxDepth", len(r.frames)+len(stack)+1, r.opts.MaxDepth); err != nil {
+				return err
+			}
+		}
+
+		if skipBelow >= 0 {
+			err = r.discard(it.size())
+		} else {
+			var p []byte
+			if p, err = r.read(it.size()); err == nil {
+				err = visit(it, p)
+			}
+			if err == SkipValue {
+				err = nil
+				if it.isContainer() {
+					skipBelow = len(stack)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(stack) != 0 {
+			stack[len(stack)-1].remaining--
+		}
+		switch it.typ {
+		case Array:
+			stack = append(stack, frame{remaining: it.n})
+		case Map:
+			stack = append(stack, frame{remaining: 2 * it.n, isMap: true})
+		}
+
+		// close all exhausted containers
+		for len(stack) != 0 && stack[len(stack)-1].remaining == 0 {
+			f := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			switch {
+			case skipBelow < 0 && end != nil:
+				if err := end(f.isMap); err != nil {
+					return err
+				}
+			case skipBelow == len(stack) && visit != nil:
+				skipBelow = -1
+			}
+		}
+
+		if len(stack) == 0 {
+			return nil
+		}
+	}
+}
+
+// peekItem returns the next item in the MessagePack stream without
+// consuming it. All length limits are checked.
+func (r *Reader) peekItem() (item, error) {
+	tag, err := r.peek()
+	if err != nil {
+		return item{}, err
+	}
+
+	switch tag {
+	case tagNil:
+		return item{typ: Nil, headerLen: 1}, nil
+	case tagFalse, tagTrue:
+		return item{typ: Bool, headerLen: 1}, nil
+	case tagInt8:
+		return item{typ: Int, headerLen: 2}, nil
+	case tagInt16:
+		return item{typ: Int, headerLen: 3}, nil
+	case tagInt32:
+		return item{typ: Int, headerLen: 5}, nil
+	case tagInt64:
+		return item{typ: Int, headerLen: 9}, nil
+	case tagUint8:
+		return item{typ: Uint, headerLen: 2}, nil
+	case tagUint16:
+		return item{typ: Uint, headerLen: 3}, nil
+	case tagUint32:
+		return item{typ: Uint, headerLen: 5}, nil
+	case tagUint64:
+		return item{typ: Uint, headerLen: 9}, nil
+	case tagFloat32:
+		return item{typ: Float, headerLen: 5}, nil
+	case tagFloat64:
+		return item{typ: Float, headerLen: 9}, nil
+	case tagStr8:
+		return r.peekSizedItem(String, 2)
+	case tagStr16:
+		return r.peekSizedItem(String, 3)
+	case tagStr32:
+		return r.peekSizedItem(String, 5)
+	case tagBin8:
+		return r.peekSizedItem(Bytes, 2)
+	case tagBin16:
+		return r.peekSizedItem(Bytes, 3)
+	case tagBin32:
+		return r.peekSizedItem(Bytes, 5)
+	case tagArray16:
+		return r.peekSizedItem(Array, 3)
+	case tagArray32:
+		return r.peekSizedItem(Array, 5)
+	case tagMap16:
+		return r.peekSizedItem(Map, 3)
+	case tagMap32:
+		return r.peekSizedItem(Map, 5)
+	case tagFixExt1, tagFixExt2, tagFixExt4, tagFixExt8, tagFixExt16, tagExt8, tagExt16, tagExt32:
+		typ, headerLen, n, err := r.peekExtension()
+		if err != nil {
+			return item{}, err
+		}
+		if err := checkLimit("MaxExtLength", n, r.opts.MaxExtLength); err != nil {
+			return item{}, err
+		}
+		return item{typ: extType(typ), headerLen: headerLen, n: n, ext: typ}, nil
+	}
+
+	switch {
+	case isPosFixintTag(tag):
+		return item{typ: Uint, headerLen: 1}, nil
+	case isNegFixintTag(tag):
+		return item{typ: Int, headerLen: 1}, nil
+	case isFixstrTag(tag):
+		return r.checkItem(item{typ: String, headerLen: 1, n: int(readFixstr(tag))})
+	case isFixarrayTag(tag):
+		return r.checkItem(item{typ: Array, headerLen: 1, n: int(readFixarray(tag))})
+	case isFixmapTag(tag):
+		return r.checkItem(item{typ: Map, headerLen: 1, n: int(readFixmap(tag))})
+	}
+
+	return item{}, errorf("unknown tag %#02x", tag)
+}
+
+// peekSizedItem returns the next item of type typ, whose header of
+// headerLen bytes ends with a big-endian length field.
+func (r *Reader) peekSizedItem(typ Type, headerLen int) (item, error) {
+	p, err := r.peekn(headerLen)
+	if err != nil {
+		return item{}, err
+	}
+
+	it := item{typ: typ, headerLen: headerLen}
+	switch headerLen {
+	case 2:
+		it.n = int(p[1])
+	case 3:
+		it.n = int(binary.BigEndian.Uint16(p[1:]))
+	default:
+		it.n = int(binary.BigEndian.Uint32(p[1:]))
+	}
+	return r.checkItem(it)
+}
+
+func (r *Reader) checkItem(it item) (item, error) {
+	var err error
+	switch it.typ {
+	case String:
+		err = checkLimit("MaxStringLength", it.n, r.opts.MaxStringLength)
+	case Bytes:
+		err = checkLimit("MaxBinaryLength", it.n, r.opts.MaxBinaryLength)
+	default:
+		err = checkLimit("MaxCollectionLength", it.n, r.opts.MaxCollectionLength)
+	}
+	if err != nil {
+		return item{}, err
+	}
+	return it, nil
+}
+
+// parseInt parses the encoding of a signed integer.
+func parseInt(p []byte) int64 {
+	switch p[0] {
+	case tagInt8:
+		return int64(int8(p[1]))
+	case tagInt16:
+		return int64(int16(binary.BigEndian.Uint16(p[1:])))
+	case tagInt32:
+		return int64(int32(binary.BigEndian.Uint32(p[1:])))
+	case tagInt64:
+		return int64(binary.BigEndian.Uint64(p[1:]))
+	default: // negative fixint
+		return int64(int8(p[0]))
+	}
+}
+
+// parseUint parses the encoding of an unsigned integer.
+func parseUint(p []byte) uint64 {
+	switch p[0] {
+	case tagUint8:
+		return uint64(p[1])
+	case tagUint16:
+		return uint64(binary.BigEndian.Uint16(p[1:]))
+	case tagUint32:
+		return uint64(binary.BigEndian.Uint32(p[1:]))
+	case tagUint64:
+		return binary.BigEndian.Uint64(p[1:])
+	default: // positive fixint
+		return uint64(p[0])
+	}
+}
+
+// parseFloat parses the encoding of a floating-point value.
+func parseFloat(p []byte) float64 {
+	if p[0] == tagFloat32 {
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(p[1:])))
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(p[1:]))
+}
@@ -0,0 +1,186 @@
+package msgpack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingVisitor struct {
+	events []string
+	skip   string // event which returns SkipValue
+}
+
+func (v *recordingVisitor) record(format string, args ...interface{}) error {
+	ev := fmt.Sprintf(format, args...)
+	v.events = append(v.events, ev)
+	if ev == v.skip {
+		return SkipValue
+	}
+	return nil
+}
+
+func (v *recordingVisitor) OnNil() error                  { return v.record("nil") }
+func (v *recordingVisitor) OnBool(b bool) error           { return v.record("bool %t", b) }
+func (v *recordingVisitor) OnInt(i int64) error           { return v.record("int %d", i) }
+func (v *recordingVisitor) OnUint(u uint64) error         { return v.record("uint %d", u) }
+func (v *recordingVisitor) OnFloat(f float64) error       { return v.record("float %g", f) }
+func (v *recordingVisitor) OnString(s []byte) error       { return v.record("string %s", s) }
+func (v *recordingVisitor) OnBytes(b []byte) error        { return v.record("bytes %x", b) }
+func (v *recordingVisitor) OnTime(tm time.Time) error     { return v.record("time %d", tm.Unix()) }
+func (v *recordingVisitor) OnExt(id int8, p []byte) error { return v.record("ext %d %x", id, p) }
+func (v *recordingVisitor) OnBeginArray(n int) error      { return v.record("array %d", n) }
+func (v *recordingVisitor) OnEndArray() error             { return v.record("end array") }
+func (v *recordingVisitor) OnBeginMap(n int) error        { return v.record("map %d", n) }
+func (v *recordingVisitor) OnEndMap() error               { return v.record("end map") }
+
+func TestWalk(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteMapHeader(2)
+	w.WriteString("a")
+	w.WriteArrayHeader(4)
+	w.WriteInt(-1)
+	w.WriteUint(300)
+	w.WriteFloat32(1.5)
+	w.WriteArrayHeader(0)
+	w.WriteString("b")
+	w.WriteMapHeader(1)
+	w.WriteBytes([]byte{0x01})
+	w.WriteExt(5, bytesMarshaler{0x02})
+	w.WriteTime(time.Unix(7, 0))
+	w.WriteBool(true)
+	w.WriteNil()
+	w.Flush()
+	data := buf.Bytes()
+
+	tests := []struct {
+		skip   string
+		events string
+	}{
+		{
+			events: "map 2, string a, array 4, int -1, uint 300, float 1.5, array 0, end array, end array, " +
+				"string b, map 1, bytes 01, ext 5 02, end map, end map | time 7 | bool true | nil",
+		},
+		{
+			skip:   "array 4",
+			events: "map 2, string a, array 4, string b, map 1, bytes 01, ext 5 02, end map, end map | time 7 | bool true | nil",
+		},
+		{
+			skip:   "map 2",
+			events: "map 2 | time 7 | bool true | nil",
+		},
+		{
+			skip: "array 0",
+			events: "map 2, string a, array 4, int -1, uint 300, float 1.5, array 0, end array, " +
+				"string b, map 1, bytes 01, ext 5 02, end map, end map | time 7 | bool true | nil",
+		},
+		{
+			skip: "bool true",
+			events: "map 2, string a, array 4, int -1, uint 300, float 1.5, array 0, end array, end array, " +
+				"string b, map 1, bytes 01, ext 5 02, end map, end map | time 7 | bool true | nil",
+		},
+	}
+
+	for _, test := range tests {
+		r := NewReaderBytes(data)
+		var values []string
+		for {
+			v := &recordingVisitor{skip: test.skip}
+			err := Walk(r, v)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			values = append(values, strings.Join(v.events, ", "))
+		}
+
+		if events := strings.Join(values, " | "); events != test.events {
+			t.Errorf("unexpected events when skipping %q:\n%s", test.skip, events)
+		}
+	}
+}
+
+func TestWalkError(t *testing.T) {
+	tests := []struct {
+		data []byte
+		err  string
+	}{
+		{[]byte{fixarrayTag(2), tagNil}, "unexpected EOF"},
+		{[]byte{fixstrTag(3), 'a'}, "EOF"},
+		{[]byte{0xc1}, "unknown tag 0xc1"},
+		{[]byte{tagFixExt1, 0xff, 0x00}, "invalid timestamp length 1"},
+	}
+
+	for _, test := range tests {
+		err := Walk(NewReaderBytes(test.data), &recordingVisitor{})
+		if err == nil {
+			t.Errorf("expected error for %x", test.data)
+		} else if err.Error() != test.err {
+			t.Errorf("unexpected error for %x: %v", test.data, err)
+		}
+	}
+}
+
+func TestWalkAllocs(t *testing.T) {
+	data, err := MarshalValue(map[string]interface{}{
+		"list": []interface{}{"a", 1, 2.5, []byte{1}},
+		"map":  map[string]interface{}{"nested": nil},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	br := bytes.NewReader(data)
+	r := NewReader(br)
+	var v nopVisitor
+	allocs := testing.AllocsPerRun(100, func() {
+		br.Reset(data)
+		r.Reset(br)
+		if err := Walk(r, v); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("unexpected number of allocations: %v", allocs)
+	}
+}
+
+func TestSkipLargeValue(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteBytes(make([]byte, 1<<20))
+	w.WriteBool(true)
+	w.Flush()
+
+	r := NewReader(&buf)
+	if err := r.Skip(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.buf) > 1024 {
+		t.Errorf("unexpected buffer growth: %d", len(r.buf))
+	}
+	if b, err := r.ReadBool(); err != nil || !b {
+		t.Errorf("unexpected value after skip: %v (error: %v)", b, err)
+	}
+}
+
+type nopVisitor struct{}
+
+func (nopVisitor) OnNil() error             { return nil }
+func (nopVisitor) OnBool(bool) error        { return nil }
+func (nopVisitor) OnInt(int64) error        { return nil }
+func (nopVisitor) OnUint(uint64) error      { return nil }
+func (nopVisitor) OnFloat(float64) error    { return nil }
+func (nopVisitor) OnString([]byte) error    { return nil }
+func (nopVisitor) OnBytes([]byte) error     { return nil }
+func (nopVisitor) OnTime(time.Time) error   { return nil }
+func (nopVisitor) OnExt(int8, []byte) error { return nil }
+func (nopVisitor) OnBeginArray(int) error   { return nil }
+func (nopVisitor) OnEndArray() error        { return nil }
+func (nopVisitor) OnBeginMap(int) error     { return nil }
+func (nopVisitor) OnEndMap() error          { return nil }
@@ -5,24 +5,152 @@ import (
 	"encoding/binary"
 	"io"
 	"math"
+	"reflect"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
-// Writer defines a writer for MessagePack encoded data.
+var writerPool sync.Pool
+
+// Writer defines a writer for MessagePack encoded data. The written data is
+// buffered. After all data has been written, Flush should be called to
+// write any buffered data to the underlying io.Writer.
 type Writer struct {
-	w io.Writer
+	w      io.Writer // nil, if the writer only appends to buf
+	buf    []byte
+	err    error
+	writes int // number of writes to w
+
+	tsLayout  TimestampLayout
+	canonical bool
+}
+
+// TimestampLayout specifies the layout which is used to write timestamps.
+type TimestampLayout int
+
+// Supported timestamp layouts. TimestampAuto chooses the smallest layout
+// which is able to represent a time value.
+const (
+	TimestampAuto TimestampLayout = iota
+	Timestamp32                   // 32-bit seconds, no sub-second precision
+	Timestamp64                   // 34-bit seconds and 30-bit nanoseconds
+	Timestamp96                   // 64-bit signed seconds and 32-bit nanoseconds
+)
+
+func (l TimestampLayout) String() string {
+	switch l {
+	case TimestampAuto:
+		return "auto"
+	case Timestamp32:
+		return "timestamp32"
+	case Timestamp64:
+		return "timestamp64"
+	case Timestamp96:
+		return "timestamp96"
+	default:
+		return "invalid"
+	}
 }
 
 // NewWriter creates a writer for MessagePack encoded data which writes to w.
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{w: w}
+	if v := writerPool.Get(); v != nil {
+		writer := v.(*Writer)
+		writer.Reset(w)
+		return writer
+	}
+
+	return &Writer{
+		w:   w,
+		buf: make([]byte, 0, 1024),
+	}
+}
+
+func releaseWriter(w *Writer) {
+	w.w = nil
+	w.tsLayout = TimestampAuto
+	w.canonical = false
+	writerPool.Put(w)
+}
+
+// Flush writes any buffered data to the underlying io.Writer. Once an error
+// occurred while writing to the underlying io.Writer, all subsequent writes
+// and flushes return this error.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.w == nil || len(w.buf) == 0 {
+		return nil
+	}
+
+	w.writes++
+	n, err := w.w.Write(w.buf)
+	if n < len(w.buf) && err == nil {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		w.err = err
+		return err
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Buffered returns the number of bytes which have been written to the writer
+// but not yet flushed to the underlying io.Writer.
+func (w *Writer) Buffered() int {
+	return len(w.buf)
+}
+
+// Reset discards any unflushed data and resets the writer to write to wr.
+func (w *Writer) Reset(wr io.Writer) {
+	w.w = wr
+	w.buf = w.buf[:0]
+	w.err = nil
+}
+
+// SetTimestampLayout sets the layout which is used to write time values. A
+// layout other than TimestampAuto is useful when communicating with peers
+// which only understand a single timestamp layout. Writing a time which
+// cannot be represented in the forced layout returns an error.
+func (w *Writer) SetTimestampLayout(l TimestampLayout) {
+	w.tsLayout = l
+}
+
+// TimestampLayout returns the layout which is used to write time values.
+func (w *Writer) TimestampLayout() TimestampLayout {
+	return w.tsLayout
+}
+
+// SetCanonical enables or disables the canonical mode. In canonical mode,
+// equal values are always written with identical bytes (see Canonicalize):
+//   - non-negative signed integers are written as unsigned integers
+//   - NaN values are written as the canonical quiet NaN
+//   - binary values which hold valid UTF-8 are written as strings
+//   - time values are written with the smallest timestamp layout,
+//     regardless of SetTimestampLayout
+//   - the entries of maps are sorted by the encoding of their keys
+//   - raw values are canonicalized before they are written
+//
+// Integers, lengths and headers are always written in their shortest form.
+// Maps are sorted if they are written with WriteValue, by a Value or by the
+// reflection-based encoding. The entries of maps which are written manually
+// after WriteMapHeader must be written in canonical order by the caller.
+func (w *Writer) SetCanonical(canonical bool) {
+	w.canonical = canonical
+}
+
+// Canonical reports whether the writer is in canonical mode.
+func (w *Writer) Canonical() bool {
+	return w.canonical
 }
 
 // WriteNil writes a nil value to the MessagePack stream.
 func (w *Writer) WriteNil() error {
 	buf := [1]byte{tagNil}
-	_, err := w.w.Write(buf[:])
-	return err
+	return w.write(buf[:])
 }
 
 // WriteBool writes a boolean value to the MessagePack stream.
@@ -32,8 +160,7 @@ func (w *Writer) WriteBool(b bool) error {
 		buf[0] = tagTrue
 	}
 
-	_, err := w.w.Write(buf[:])
-	return err
+	return w.write(buf[:])
 }
 
 // WriteInt8 writes an 8-bit integer value to the MessagePack stream.
@@ -42,38 +169,38 @@ func (w *Writer) WriteInt8(i int8) error {
 	switch {
 	case i >= 0:
 		buf[0] = posFixintTag(uint8(i))
-		_, err := w.w.Write(buf[:1])
-		return err
+		return w.write(buf[:1])
 
-	case i > -32:
+	case i >= -32:
 		buf[0] = negFixintTag(i)
-		_, err := w.w.Write(buf[:1])
-		return err
+		return w.write(buf[:1])
 
 	default:
 		buf[0] = tagInt8
 		buf[1] = byte(i)
-		_, err := w.w.Write(buf[:2])
-		return err
+		return w.write(buf[:2])
 	}
 }
 
 // WriteInt16 writes a 16-bit integer value to the MessagePack stream.
 func (w *Writer) WriteInt16(i int16) error {
 	switch {
+	case w.canonical && i >= 0:
+		return w.WriteUint64(uint64(i))
 	case math.MinInt8 <= i && i <= math.MaxInt8:
 		return w.WriteInt8(int8(i))
 	default:
 		buf := [3]byte{tagInt16}
 		binary.BigEndian.PutUint16(buf[1:], uint16(i))
-		_, err := w.w.Write(buf[:])
-		return err
+		return w.write(buf[:])
 	}
 }
 
 // WriteInt32 writes a 32-bit integer value to the MessagePack stream.
 func (w *Writer) WriteInt32(i int32) error {
 	switch {
+	case w.canonical && i >= 0:
+		return w.WriteUint64(uint64(i))
 	case math.MinInt8 <= i && i <= math.MaxInt8:
 		return w.WriteInt8(int8(i))
 	case math.MinInt16 <= i && i <= math.MaxInt16:
@@ -81,14 +208,15 @@ func (w *Writer) WriteInt32(i int32) error {
 	default:
 		buf := [5]byte{tagInt32}
 		binary.BigEndian.PutUint32(buf[1:], uint32(i))
-		_, err := w.w.Write(buf[:])
-		return err
+		return w.write(buf[:])
 	}
 }
 
 // WriteInt64 writes a 64-bit integer value to the MessagePack stream.
 func (w *Writer) WriteInt64(i int64) error {
 	switch {
+	case w.canonical && i >= 0:
+		return w.WriteUint64(uint64(i))
 	case math.MinInt8 <= i && i <= math.MaxInt8:
 		return w.WriteInt8(int8(i))
 	case math.MinInt16 <= i && i <= math.MaxInt16:
@@ -98,8 +226,7 @@ func (w *Writer) WriteInt64(i int64) error {
 	default:
 		buf := [9]byte{tagInt64}
 		binary.BigEndian.PutUint64(buf[1:], uint64(i))
-		_, err := w.w.Write(buf[:])
-		return err
+		return w.write(buf[:])
 	}
 }
 
@@ -114,14 +241,12 @@ func (w *Writer) WriteUint8(i uint8) error {
 	switch {
 	case i < 128:
 		buf[0] = posFixintTag(i)
-		_, err := w.w.Write(buf[:1])
-		return err
+		return w.write(buf[:1])
 
 	default:
 		buf[0] = tagUint8
 		buf[1] = i
-		_, err := w.w.Write(buf[:])
-		return err
+		return w.write(buf[:])
 	}
 }
 
@@ -133,8 +258,7 @@ func (w *Writer) WriteUint16(i uint16) error {
 	default:
 		buf := [3]byte{tagUint16}
 		binary.BigEndian.PutUint16(buf[1:], i)
-		_, err := w.w.Write(buf[:])
-		return err
+		return w.write(buf[:])
 	}
 }
 
@@ -148,8 +272,7 @@ func (w *Writer) WriteUint32(i uint32) error {
 	default:
 		buf := [5]byte{tagUint32}
 		binary.BigEndian.PutUint32(buf[1:], i)
-		_, err := w.w.Write(buf[:])
-		return err
+		return w.write(buf[:])
 	}
 }
 
@@ -165,8 +288,7 @@ func (w *Writer) WriteUint64(i uint64) error {
 	default:
 		buf := [9]byte{tagUint64}
 		binary.BigEndian.PutUint64(buf[1:], i)
-		_, err := w.w.Write(buf[:])
-		return err
+		return w.write(buf[:])
 	}
 }
 
@@ -177,44 +299,65 @@ func (w *Writer) WriteUint(i uint) error {
 
 // WriteFloat32 writes a 32-bit floating-point value to the MessagePack stream.
 func (w *Writer) WriteFloat32(f float32) error {
+	if w.canonical && f != f {
+		f = math.Float32frombits(canonicalNaN32)
+	}
 	buf := [5]byte{tagFloat32}
 	binary.BigEndian.PutUint32(buf[1:], math.Float32bits(f))
-	_, err := w.w.Write(buf[:])
-	return err
+	return w.write(buf[:])
 }
 
 // WriteFloat64 writes a 64-bit floating-point value to the MessagePack stream.
 func (w *Writer) WriteFloat64(f float64) error {
+	if w.canonical && f != f {
+		f = math.Float64frombits(canonicalNaN64)
+	}
 	buf := [9]byte{tagFloat64}
 	binary.BigEndian.PutUint64(buf[1:], math.Float64bits(f))
-	_, err := w.w.Write(buf[:])
-	return err
+	return w.write(buf[:])
 }
 
-// WriteBytes writes a binary value to the MessagePack stream.
+// WriteBytes writes a binary value to the MessagePack stream. In canonical
+// mode, b is written as a string value if it holds valid UTF-8.
 func (w *Writer) WriteBytes(b []byte) error {
-	return w.writeBlob(tagBin8, b)
+	if w.canonical && utf8.Valid(b) {
+		return w.writeString(b)
+	}
+	if err := w.writeBlobHeader(tagBin8, len(b)); err != nil {
+		return err
+	}
+	return w.writePayload(b)
 }
 
 // WriteString writes a string value to the MessagePack stream.
 func (w *Writer) WriteString(s string) error {
-	if len(s) <= 31 {
-		buf := [1]byte{fixstrTag(len(s))}
-		_, err := w.w.Write(buf[:])
-		if err == nil {
-			_, err = io.WriteString(w.w, s)
-		}
+	if err := w.writeStringHeader(len(s)); err != nil {
+		return err
+	}
+	return w.writeStringPayload(s)
+}
+
+// writeString writes the string value s given as a byte slice.
+func (w *Writer) writeString(s []byte) error {
+	if err := w.writeStringHeader(len(s)); err != nil {
 		return err
 	}
-	return w.writeBlob(tagStr8, []byte(s))
+	return w.writePayload(s)
+}
+
+func (w *Writer) writeStringHeader(n int) error {
+	if n <= 31 {
+		buf := [1]byte{fixstrTag(n)}
+		return w.write(buf[:])
+	}
+	return w.writeBlobHeader(tagStr8, n)
 }
 
 // WriteArrayHeader writes the header of an array value to the MessagePack stream.
 func (w *Writer) WriteArrayHeader(length int) error {
 	if length <= 15 {
 		buf := [1]byte{fixarrayTag(length)}
-		_, err := w.w.Write(buf[:])
-		return err
+		return w.write(buf[:])
 	}
 	return w.writeCollectionHeader(tagArray16, length)
 }
@@ -223,22 +366,26 @@ func (w *Writer) WriteArrayHeader(length int) error {
 func (w *Writer) WriteMapHeader(length int) error {
 	if length <= 15 {
 		buf := [1]byte{fixmapTag(length)}
-		_, err := w.w.Write(buf[:])
-		return err
+		return w.write(buf[:])
 	}
 	return w.writeCollectionHeader(tagMap16, length)
 }
 
 // WriteRaw writes raw bytes to the MessagePack stream, which represent an
-// already encoded section.
+// already encoded section. In canonical mode, r needs to consist of complete
+// values, which are canonicalized.
 func (w *Writer) WriteRaw(r Raw) error {
-	_, err := w.w.Write(r)
-	return err
+	if w.canonical {
+		return canonicalize(w, r)
+	}
+	return w.writePayload(r)
 }
 
-// WriteExt writes an extension value to the MessagePack stream.
+// WriteExt writes an extension value to the MessagePack stream. The
+// extension type -1 is reserved for timestamps and cannot be used here (see
+// WriteTime).
 func (w *Writer) WriteExt(typ int8, v encoding.BinaryMarshaler) error {
-	if typ < 0 {
+	if typ == extTime {
 		return newInvalidExtensionError(typ)
 	}
 
@@ -249,23 +396,105 @@ func (w *Writer) WriteExt(typ int8, v encoding.BinaryMarshaler) error {
 	return w.writeExtension(typ, data)
 }
 
-// WriteTime writes a time value to the MessagePack stream.
+// WriteTime writes a time value to the MessagePack stream. The time is
+// written with nanosecond precision using the timestamp layout configured
+// with SetTimestampLayout. By default, the smallest layout which is able to
+// represent the time is chosen, which is always the case in canonical mode.
 func (w *Writer) WriteTime(tm time.Time) error {
-	// To avoid undefined behaviour for timestamps before the
-	// year 1678 or after 2262, the 64-bit UNIX timestamp is
-	// used here.
+	secs, nsecs := tm.Unix(), uint32(tm.Nanosecond())
+
+	layout := w.tsLayout
+	if layout == TimestampAuto || w.canonical {
+		switch {
+		case secs < 0 || secs >= 1<<34:
+			layout = Timestamp96
+		case nsecs != 0 || secs > math.MaxUint32:
+			layout = Timestamp64
+		default:
+			layout = Timestamp32
+		}
+	}
+
 	var buf [12]byte
-	binary.BigEndian.PutUint64(buf[4:], uint64(tm.Unix()))
-	return w.writeExtension(extTime, buf[:])
+	switch layout {
+	case Timestamp32:
+		if secs < 0 || secs > math.MaxUint32 || nsecs != 0 {
+			return errorf("time %s cannot be represented as %s", tm, layout)
+		}
+		binary.BigEndian.PutUint32(buf[:4], uint32(secs))
+		return w.writeExtension(extTime, buf[:4])
+
+	case Timestamp64:
+		if secs < 0 || secs >= 1<<34 {
+			return errorf("time %s cannot be represented as %s", tm, layout)
+		}
+		binary.BigEndian.PutUint64(buf[:8], uint64(nsecs)<<34|uint64(secs))
+		return w.writeExtension(extTime, buf[:8])
+
+	case Timestamp96:
+		binary.BigEndian.PutUint32(buf[:4], nsecs)
+		binary.BigEndian.PutUint64(buf[4:], uint64(secs))
+		return w.writeExtension(extTime, buf[:])
+
+	default:
+		return errorf("invalid timestamp layout %d", int(layout))
+	}
 }
 
-func (w *Writer) writeBlob(baseTag byte, blob []byte) error {
+// WriteValue writes an arbitrary value to the MessagePack stream. If v
+// implements Encoder, its EncodeMsgpack method is used. Otherwise the value is
+// encoded using reflection, where every nested type which implements Encoder
+// is encoded with its own method.
+//
+// Pointers and interfaces are encoded as the value they refer to, slices and
+// arrays as arrays, maps as maps and time.Time values as timestamps. Nil
+// pointers, slices, maps and interfaces are encoded as nil. Byte slices and
+// byte arrays are encoded as binary values.
+//
+// A struct is encoded as a map from field names to field values. Only exported
+// fields are encoded. The encoding of each field can be customized with the
+// "msgpack" key in the field's tag:
+//
+//	// Field is encoded with the key "name".
+//	Field int `msgpack:"name"`
+//
+//	// Field is omitted if its value is empty.
+//	Field int `msgpack:"name,omitempty"`
+//
+//	// Field is ignored.
+//	Field int `msgpack:"-"`
+//
+//	// The fields of the embedded struct are encoded as if they
+//	// were declared in the outer struct.
+//	Embedded `msgpack:",inline"`
+//
+// Empty values are false, 0, nil pointers and interfaces, zero times and
+// arrays, slices, maps and strings of length zero. A struct with a blank
+// field tagged with the "array" option is encoded as an array of its field
+// values in declaration order instead of a map:
+//
+//	type Point struct {
+//		_    struct{} `msgpack:",array"`
+//		X, Y int
+//	}
+func (w *Writer) WriteValue(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		return w.WriteNil()
+	case Encoder:
+		return encodeEncoder(w, reflect.ValueOf(v))
+	}
+
+	rv := reflect.ValueOf(v)
+	return typeEncoder(rv.Type())(w, rv)
+}
+
+func (w *Writer) writeBlobHeader(baseTag byte, n int) error {
 	var (
 		buf [5]byte
 		p   []byte
 	)
 
-	n := len(blob)
 	switch {
 	case n <= math.MaxUint8:
 		buf[0] = baseTag
@@ -286,11 +515,7 @@ func (w *Writer) writeBlob(baseTag byte, blob []byte) error {
 		return errLengthLimitExceeded
 	}
 
-	_, err := w.w.Write(p)
-	if err == nil {
-		_, err = w.w.Write(blob)
-	}
-	return err
+	return w.write(p)
 }
 
 func (w *Writer) writeCollectionHeader(baseTag byte, length int) error {
@@ -299,14 +524,12 @@ func (w *Writer) writeCollectionHeader(baseTag byte, length int) error {
 	case length <= math.MaxUint16:
 		buf[0] = baseTag
 		binary.BigEndian.PutUint16(buf[1:], uint16(length))
-		_, err := w.w.Write(buf[:3])
-		return err
+		return w.write(buf[:3])
 
 	case length <= math.MaxUint32:
 		buf[0] = baseTag + 1
 		binary.BigEndian.PutUint32(buf[1:], uint32(length))
-		_, err := w.w.Write(buf[:5])
-		return err
+		return w.write(buf[:5])
 
 	default:
 		return errLengthLimitExceeded
@@ -355,18 +578,72 @@ func (w *Writer) writeExtension(typ int8, data []byte) error {
 		return errLengthLimitExceeded
 	}
 
-	_, err := w.w.Write(p)
-	if err == nil {
-		_, err = w.w.Write(data)
+	if err := w.write(p); err != nil {
+		return err
 	}
-	return err
+	return w.writePayload(data)
 }
 
 func (w *Writer) writeFixExt(tag byte, typ int8, data []byte) error {
 	buf := [2]byte{tag, byte(typ)}
-	_, err := w.w.Write(buf[:])
-	if err == nil {
-		_, err = w.w.Write(data)
+	if err := w.write(buf[:]); err != nil {
+		return err
+	}
+	return w.writePayload(data)
+}
+
+// write appends the small chunk p to the buffer. The buffer is flushed
+// beforehand if p does not fit anymore.
+func (w *Writer) write(p []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.w != nil && len(w.buf)+len(p) > cap(w.buf) {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	w.buf = append(w.buf, p...)
+	return nil
+}
+
+// writePayload writes p, which could be arbitrarily large. Payloads which
+// exceed the buffer capacity are written directly to the underlying
+// io.Writer.
+func (w *Writer) writePayload(p []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.w != nil && len(w.buf)+len(p) > cap(w.buf) {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if len(p) >= cap(w.buf) {
+			w.writes++
+			_, w.err = w.w.Write(p)
+			return w.err
+		}
+	}
+	w.buf = append(w.buf, p...)
+	return nil
+}
+
+// writeStringPayload works like writePayload, but without converting s to
+// a byte slice.
+func (w *Writer) writeStringPayload(s string) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.w != nil && len(w.buf)+len(s) > cap(w.buf) {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if len(s) >= cap(w.buf) {
+			w.writes++
+			_, w.err = io.WriteString(w.w, s)
+			return w.err
+		}
 	}
-	return err
+	w.buf = append(w.buf, s...)
+	return nil
 }
@@ -2,6 +2,7 @@ package msgpack
 
 import (
 	"bytes"
+	"io"
 	"math"
 	"strings"
 	"testing"
@@ -144,6 +145,10 @@ func TestWriterWrite(t *testing.T) {
 			data:  []byte{0x01, 0x02, 0x03, 0x04, 0x05},
 		},
 		// ext
+		{
+			write: func(w *Writer) error { return w.WriteExt(-2, bytesMarshaler{'0'}) },
+			data:  []byte{tagFixExt1, 0xfe, '0'},
+		},
 		{
 			write: func(w *Writer) error { return w.WriteExt(0xd, bytesMarshaler(bytes.Repeat([]byte{'0'}, 1))) },
 			data:  append([]byte{tagFixExt1, 0xd}, bytes.Repeat([]byte{'0'}, 1)...),
@@ -187,14 +192,50 @@ func TestWriterWrite(t *testing.T) {
 			write: func(w *Writer) error {
 				return w.WriteTime(time.Date(2017, time.September, 26, 13, 14, 15, 0, time.UTC))
 			},
+			data: []byte{tagFixExt4, 0xff, 0x59, 0xca, 0x52, 0xa7},
+		},
+		{
+			write: func(w *Writer) error {
+				return w.WriteTime(time.Date(2017, time.September, 26, 13, 14, 15, 1, time.UTC))
+			},
+			data: []byte{tagFixExt8, 0xff, 0x00, 0x00, 0x00, 0x04, 0x59, 0xca, 0x52, 0xa7},
+		},
+		{
+			write: func(w *Writer) error {
+				return w.WriteTime(time.Date(2200, time.January, 1, 0, 0, 0, 0, time.UTC))
+			},
+			data: []byte{tagFixExt8, 0xff, 0x00, 0x00, 0x00, 0x01, 0xb0, 0x9e, 0x19, 0x00},
+		},
+		{
+			write: func(w *Writer) error {
+				return w.WriteTime(time.Date(1969, time.December, 31, 23, 59, 59, 5, time.UTC))
+			},
+			data: []byte{tagExt8, 12, 0xff, 0x00, 0x00, 0x00, 0x05, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		},
+		{
+			write: func(w *Writer) error {
+				w.SetTimestampLayout(Timestamp96)
+				return w.WriteTime(time.Date(2017, time.September, 26, 13, 14, 15, 0, time.UTC))
+			},
 			data: []byte{tagExt8, 12, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x59, 0xca, 0x52, 0xa7},
 		},
+		{
+			write: func(w *Writer) error {
+				w.SetTimestampLayout(Timestamp64)
+				return w.WriteTime(time.Date(2017, time.September, 26, 13, 14, 15, 0, time.UTC))
+			},
+			data: []byte{tagFixExt8, 0xff, 0x00, 0x00, 0x00, 0x00, 0x59, 0xca, 0x52, 0xa7},
+		},
 	}
 
 	var buf bytes.Buffer
 	for _, test := range tests {
 		buf.Reset()
-		err := test.write(NewWriter(&buf))
+		w := NewWriter(&buf)
+		err := test.write(w)
+		if err == nil {
+			err = w.Flush()
+		}
 		if err != nil {
 			t.Errorf("unexpected write error: %v", err)
 		} else if !bytes.Equal(buf.Bytes(), test.data) {
@@ -203,6 +244,127 @@ func TestWriterWrite(t *testing.T) {
 	}
 }
 
+func TestWriterTimeLayout(t *testing.T) {
+	times := []time.Time{
+		time.Unix(0, 0),
+		time.Unix(1<<32-1, 0),
+		time.Unix(1<<32, 0),
+		time.Unix(1<<34-1, 999999999),
+		time.Unix(1<<34, 0),
+		time.Unix(-1, 999999999),
+		time.Date(1, time.January, 1, 0, 0, 0, 1, time.UTC),
+	}
+
+	for _, layout := range []TimestampLayout{TimestampAuto, Timestamp32, Timestamp64, Timestamp96} {
+		for _, tm := range times {
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			w.SetTimestampLayout(layout)
+			if err := w.WriteTime(tm); err != nil {
+				if layout == Timestamp32 || layout == Timestamp64 {
+					continue // not representable in the forced layout
+				}
+				t.Fatalf("unexpected error for %s (%s): %v", tm, layout, err)
+			}
+			w.Flush()
+
+			res, err := NewReader(&buf).ReadTime()
+			if err != nil {
+				t.Errorf("unexpected read error for %s (%s): %v", tm, layout, err)
+			} else if !res.Equal(tm) {
+				t.Errorf("unexpected time for %s (%s): %s", tm, layout, res)
+			}
+		}
+	}
+
+	w := NewWriter(io.Discard)
+	w.SetTimestampLayout(Timestamp32)
+	err := w.WriteTime(time.Unix(1, 1).UTC())
+	if err == nil || err.Error() != "time 1970-01-01 00:00:01.000000001 +0000 UTC cannot be represented as timestamp32" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWriterBuffering(t *testing.T) {
+	var out recordingWriter
+	w := NewWriter(&out)
+	for i := 0; i < 100; i++ {
+		if err := w.WriteInt(i); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if out.calls != 0 || w.Buffered() != 100 {
+		t.Errorf("unexpected state before flush: calls=%d buffered=%d", out.calls, w.Buffered())
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.calls != 1 || out.n != 100 || w.Buffered() != 0 {
+		t.Errorf("unexpected state after flush: calls=%d bytes=%d buffered=%d", out.calls, out.n, w.Buffered())
+	}
+
+	// large payloads bypass the buffer
+	out = recordingWriter{}
+	if err := w.WriteString(strings.Repeat("0", 4096)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.calls != 2 || out.n != 3+4096 || w.Buffered() != 0 {
+		t.Errorf("unexpected state after large write: calls=%d bytes=%d buffered=%d", out.calls, out.n, w.Buffered())
+	}
+
+	// reset discards buffered data
+	w.WriteNil()
+	var buf bytes.Buffer
+	w.Reset(&buf)
+	w.WriteBool(true)
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{tagTrue}) {
+		t.Errorf("unexpected data after reset: %x", buf.Bytes())
+	}
+}
+
+func TestWriterStickyError(t *testing.T) {
+	w := NewWriter(errorWriter{})
+	w.WriteNil()
+	if err := w.Flush(); err != io.ErrClosedPipe {
+		t.Errorf("unexpected flush error: %v", err)
+	}
+	if err := w.WriteNil(); err != io.ErrClosedPipe {
+		t.Errorf("unexpected write error: %v", err)
+	}
+}
+
+func TestWriterStringAllocs(t *testing.T) {
+	s := strings.Repeat("0", 64)
+	w := NewWriter(io.Discard)
+	allocs := testing.AllocsPerRun(100, func() {
+		w.WriteString(s)
+		w.Flush()
+	})
+	if allocs != 0 {
+		t.Errorf("unexpected number of allocations: %v", allocs)
+	}
+}
+
+type recordingWriter struct {
+	calls int
+	n     int
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.calls++
+	w.n += len(p)
+	return len(p), nil
+}
+
+type errorWriter struct{}
+
+func (errorWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
 type bytesMarshaler []byte
 
 func (m bytesMarshaler) MarshalBinary() ([]byte, error) {